@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// allowedDomainsSource is the source of the AllowedDomains setting in lookups.
+	allowedDomainsSource = "allowed"
+
+	// teamRemovalAction is the audit log action of removing a user from a team they may not
+	// be a member of.
+	teamRemovalAction = "remove_from_team"
+)
+
+// parseAllowedDomains parses the AllowedDomains setting: comma separated domains, matched like
+// the entries of the domain lists. It returns nil if no domain is allowed, i.e. if the allowlist
+// is off.
+func parseAllowedDomains(setting string) *domainSet {
+	var entries []string
+	for _, entry := range strings.Split(setting, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return newDomainSet(allowedDomainsSource, entries)
+}
+
+// parseTeamAllowedDomains parses the TeamAllowedDomains setting: comma separated pairs of a team
+// name or id and an allowed domain, e.g. "staff=example.com,staff=*.example.org". A team can be
+// listed several times to allow several domains.
+func parseTeamAllowedDomains(setting string) (map[string]*domainSet, error) {
+	entries := map[string][]string{}
+	for _, pair := range strings.Split(setting, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		team, domain, found := strings.Cut(pair, "=")
+		team = strings.ToLower(strings.TrimSpace(team))
+		domain = strings.TrimSpace(domain)
+		if !found || team == "" || domain == "" {
+			return nil, fmt.Errorf("expected team=domain, got %q", strings.TrimSpace(pair))
+		}
+		entries[team] = append(entries[team], domain)
+	}
+
+	sets := make(map[string]*domainSet, len(entries))
+	for team, domains := range entries {
+		sets[team] = newDomainSet(allowedDomainsSource+":"+team, domains)
+	}
+	return sets, nil
+}
+
+// checkAllowedDomain rejects new users whose email domain is not allowed, if the allowlist is
+// on.
+func (p *Plugin) checkAllowedDomain(user *model.User) error {
+	if p.allowedDomains == nil || user.IsBot {
+		return nil
+	}
+	if _, allowed := p.allowedDomains.match(emailDomain(user.Email)); allowed {
+		return nil
+	}
+	return &userViolation{
+		validator: validatorAllowedDomains,
+		reason:    fmt.Sprintf("email domain is not in the allowed domains: %v", user.Email),
+	}
+}
+
+// checkTeamAllowedDomain rejects members of a team whose email domain is not allowed in the
+// team, if the team has allowed domains. Moderators may join any team.
+func (p *Plugin) checkTeamAllowedDomain(user *model.User, team *model.Team) error {
+	allowed, ok := p.teamAllowedDomains[strings.ToLower(team.Name)]
+	if !ok {
+		allowed, ok = p.teamAllowedDomains[strings.ToLower(team.Id)]
+	}
+	if !ok || user.IsBot || p.isModerator(user.Id) {
+		return nil
+	}
+	if _, found := allowed.match(emailDomain(user.Email)); found {
+		return nil
+	}
+	return &userViolation{
+		validator: validatorAllowedDomains,
+		reason:    fmt.Sprintf("email domain is not in the allowed domains of team %s: %v", team.Name, user.Email),
+	}
+}
+
+// removeFromTeam removes a user from a team whose allowed domains they failed, and reports it to
+// the moderators and in the audit log. Only that team is left: the account and the other teams
+// of the user are kept, whatever the response to the allowed_domains check at signup.
+func (p *Plugin) removeFromTeam(user *model.User, team *model.Team, violation error) {
+	reason := violation.Error()
+	notification := &moderationNotification{
+		event: eventUserFlagged,
+		title: "User removed from team",
+		fields: []*model.SlackAttachmentField{
+			{Title: "Username", Value: user.Username, Short: true},
+			{Title: "Email", Value: user.Email, Short: true},
+			{Title: "Team", Value: team.Name, Short: true},
+			{Title: "User ID", Value: user.Id, Short: true},
+			{Title: "Reasons", Value: reason},
+		},
+	}
+	record := &AuditRecord{
+		UserID:  user.Id,
+		Feature: shadowNewUsers,
+		Action:  teamRemovalAction,
+		Excerpt: excerpt(reason),
+	}
+
+	if isShadowed(p.getConfiguration(), shadowNewUsers) {
+		record.Shadow = true
+		p.audit(record)
+		p.recordShadowDecision(shadowNewUsers, teamRemovalAction,
+			"user_id", user.Id,
+			"team_id", team.Id,
+			"reasons", reason,
+		)
+		notification.event = eventShadowDecision
+		notification.title = "Shadow mode: a user would be removed from a team"
+		p.notifyModerators(notification)
+		return
+	}
+
+	if appErr := p.API.DeleteTeamMember(team.Id, user.Id, p.actingUserID()); appErr != nil {
+		p.API.LogError("Failed to remove user from team",
+			"user_id", user.Id,
+			"team_id", team.Id,
+			"err", appErr.Error(),
+		)
+		notification.title = "User could not be removed from team"
+	}
+
+	p.audit(record)
+	p.notifyModerators(notification)
+}
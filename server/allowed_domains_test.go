@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTeamAllowedDomains(t *testing.T) {
+	sets, err := parseTeamAllowedDomains(" Staff=example.com, staff=*.example.org,ops=example.net ")
+	require.NoError(t, err)
+	require.Len(t, sets, 2)
+	assert.Equal(t, 2, sets["staff"].size())
+	assert.Equal(t, "allowed:ops", sets["ops"].source)
+
+	sets, err = parseTeamAllowedDomains("")
+	require.NoError(t, err)
+	assert.Empty(t, sets)
+
+	for _, setting := range []string{"example.com", "staff=", "=example.com"} {
+		_, err := parseTeamAllowedDomains(setting)
+		assert.Error(t, err, setting)
+	}
+}
+
+func TestAllowedDomains(t *testing.T) {
+	t.Run("only allowed domains may sign up", func(t *testing.T) {
+		p, api := newTestPlugin(t, &configuration{AllowedDomains: "example.com, *.example.org", EnableAuditLog: true})
+
+		assert.NoError(t, p.checkAllowedDomain(&model.User{Email: "staff@example.com"}))
+		assert.NoError(t, p.checkAllowedDomain(&model.User{Email: "staff@mail.example.org"}))
+		assert.NoError(t, p.checkAllowedDomain(&model.User{Email: "bot@localhost", IsBot: true}))
+
+		user := &model.User{Id: model.NewId(), Username: "outsider", Email: "outsider@example.org"}
+		api.users[user.Id] = user
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+		assert.NotZero(t, api.users[user.Id].DeleteAt)
+		assert.Equal(t, "sanitized-"+user.Id, api.users[user.Id].Username)
+	})
+
+	t.Run("any domain may sign up without an allowlist", func(t *testing.T) {
+		p, _ := newTestPlugin(t, &configuration{EnableAuditLog: true})
+		assert.NoError(t, p.checkAllowedDomain(&model.User{Email: "anyone@example.net"}))
+	})
+
+	t.Run("teams limit who may join them", func(t *testing.T) {
+		p, api := newTestPlugin(t, &configuration{TeamAllowedDomains: "community=example.com", EnableAuditLog: true})
+		staff := &model.User{Id: model.NewId(), Username: "staff", Email: "staff@example.com"}
+		outsider := &model.User{Id: model.NewId(), Username: "outsider", Email: "outsider@example.net"}
+		api.users[staff.Id] = staff
+		api.users[outsider.Id] = outsider
+
+		assert.NoError(t, p.checkAllowedDomain(outsider), "signup is not limited")
+
+		api.teamRoles["team"] = model.TeamUserRoleId
+		p.UserHasJoinedTeam(&plugin.Context{}, &model.TeamMember{TeamId: "team", UserId: staff.Id}, nil)
+		assert.Contains(t, api.teamRoles, "team")
+
+		p.UserHasJoinedTeam(&plugin.Context{}, &model.TeamMember{TeamId: "team", UserId: outsider.Id}, nil)
+		assert.NotContains(t, api.teamRoles, "team")
+		assert.Equal(t, []string{"bot"}, api.removedBy)
+
+		records, err := p.queryAuditLog(AuditQuery{UserID: outsider.Id})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, teamRemovalAction, records[0].Action)
+		assert.Equal(t, "email domain is not in the allowed domains of team community: outsider@example.net", records[0].Excerpt)
+	})
+
+	t.Run("users are only removed from the team that does not allow them", func(t *testing.T) {
+		// The response to the allowed_domains check at signup is left to the default,
+		// deactivation, which must not apply to joining a team.
+		p, api := newTestPlugin(t, &configuration{TeamAllowedDomains: "community=example.com"})
+		outsider := &model.User{Id: model.NewId(), Username: "outsider", Email: "outsider@example.net"}
+		api.users[outsider.Id] = outsider
+		api.teamRoles["team"] = model.TeamUserRoleId
+		api.teamRoles["other"] = model.TeamUserRoleId
+
+		p.UserHasJoinedTeam(&plugin.Context{}, &model.TeamMember{TeamId: "team", UserId: outsider.Id}, nil)
+
+		assert.Equal(t, map[string]string{"other": model.TeamUserRoleId}, api.teamRoles)
+		assert.Zero(t, api.users[outsider.Id].DeleteAt)
+		assert.Equal(t, "outsider", api.users[outsider.Id].Username)
+		flagged, err := p.getFlaggedUser(outsider.Id)
+		require.NoError(t, err)
+		assert.Nil(t, flagged)
+	})
+
+	t.Run("shadow mode keeps users in the team", func(t *testing.T) {
+		p, api := newTestPlugin(t, &configuration{TeamAllowedDomains: "community=example.com", ShadowMode: shadowNewUsers})
+		outsider := &model.User{Id: model.NewId(), Username: "outsider", Email: "outsider@example.net"}
+		api.users[outsider.Id] = outsider
+		api.teamRoles["team"] = model.TeamUserRoleId
+
+		p.UserHasJoinedTeam(&plugin.Context{}, &model.TeamMember{TeamId: "team", UserId: outsider.Id}, nil)
+
+		assert.Contains(t, api.teamRoles, "team")
+		assert.NotEmpty(t, api.infos)
+	})
+
+	t.Run("moderators may join any team", func(t *testing.T) {
+		p, api := newTestPlugin(t, &configuration{TeamAllowedDomains: "community=example.com", EnableAuditLog: true})
+		moderator := &model.User{Id: model.NewId(), Username: "mod", Email: "mod@example.net"}
+		api.users[moderator.Id] = moderator
+		api.admins[moderator.Id] = true
+
+		assert.NoError(t, p.checkTeamAllowedDomain(moderator, &model.Team{Id: "team", Name: "community"}))
+	})
+}
@@ -0,0 +1,825 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+)
+
+// Plugin Callback: ServeHTTP
+// Handles the HTTP requests sent to /plugins/{id}, such as clicks on interactive buttons.
+func (p *Plugin) ServeHTTP(_ *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/held/", p.handleHeldPostDecision)
+	mux.HandleFunc("/api/v1/pending/", p.handlePendingUserDecision)
+	mux.HandleFunc("/api/v1/quarantine/", p.handleQuarantineRelease)
+	mux.HandleFunc("/api/v1/audit", p.requireModerator(p.handleAuditLog))
+	mux.HandleFunc("/api/v1/rules/", p.requireModerator(p.handleRules))
+	mux.HandleFunc("/api/v1/test/text", p.requireModerator(p.handleTestText))
+	mux.HandleFunc("/api/v1/test/user", p.requireModerator(p.handleTestUser))
+	mux.HandleFunc("/api/v1/flagged", p.requireModerator(p.handleFlaggedUsers))
+	mux.HandleFunc("/api/v1/flagged/", p.requireModerator(p.handleFlaggedUser))
+	mux.HandleFunc("/api/v1/users/", p.requireModerator(p.handleUserAction))
+	mux.HandleFunc("/api/v1/cleanup", p.requireModerator(p.handleCleanupJobs))
+	mux.HandleFunc("/api/v1/domains/lists", p.requireModerator(p.handleDomainLists))
+	mux.HandleFunc("/api/v1/domains/lists/", p.requireModerator(p.handleDomainList))
+	mux.HandleFunc("/api/v1/domains/check", p.requireModerator(p.handleDomainCheck))
+	mux.HandleFunc("/api/v1/emails/banned", p.requireModerator(p.handleBannedEmails))
+	mux.HandleFunc("/api/v1/emails/banned/", p.requireModerator(p.handleBannedEmail))
+	mux.HandleFunc("/api/v1/emails/check", p.requireModerator(p.handleEmailCheck))
+	mux.ServeHTTP(w, r)
+}
+
+// requireModerator restricts a handler to system admins and moderators. The server sets the
+// Mattermost-User-Id header for authenticated requests only, whether they use a session or a
+// personal access token.
+func (p *Plugin) requireModerator(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("Mattermost-User-Id")
+		if userID == "" {
+			http.Error(w, "not authorized", http.StatusUnauthorized)
+			return
+		}
+		if !p.isModerator(userID) {
+			http.Error(w, "only moderators can use this API", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// handleHeldPostDecision handles the approve and deny buttons of a review post, which post to
+// /api/v1/held/{id}/{decision}.
+func (p *Plugin) handleHeldPostDecision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/held/"), "/")
+	if len(parts) != 2 || !model.IsValidId(parts[0]) ||
+		(parts[1] != holdDecisionApprove && parts[1] != holdDecisionDeny) {
+		http.NotFound(w, r)
+		return
+	}
+
+	userID := r.Header.Get("Mattermost-User-Id")
+	if !p.isModerator(userID) {
+		writeActionResponse(w, &model.PostActionIntegrationResponse{
+			EphemeralText: "Only moderators can review held posts.",
+		})
+		return
+	}
+
+	reviewPost, err := p.reviewHeldPost(parts[0], parts[1], userID)
+	if err != nil {
+		p.API.LogError("Failed to review held post", "held_post_id", parts[0], "err", err.Error())
+		writeActionResponse(w, &model.PostActionIntegrationResponse{
+			EphemeralText: "Something went wrong while reviewing the post. Check the server logs.",
+		})
+		return
+	}
+	if reviewPost == nil {
+		writeActionResponse(w, &model.PostActionIntegrationResponse{
+			EphemeralText: "This post has already been reviewed.",
+		})
+		return
+	}
+
+	writeActionResponse(w, &model.PostActionIntegrationResponse{Update: reviewPost})
+}
+
+// handlePendingUserDecision handles the approve and deny buttons of an approval request, which
+// post to /api/v1/pending/{user_id}/{decision}.
+func (p *Plugin) handlePendingUserDecision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/pending/"), "/")
+	if len(parts) != 2 || !model.IsValidId(parts[0]) ||
+		(parts[1] != holdDecisionApprove && parts[1] != holdDecisionDeny) {
+		http.NotFound(w, r)
+		return
+	}
+
+	userID := r.Header.Get("Mattermost-User-Id")
+	if !p.isModerator(userID) {
+		writeActionResponse(w, &model.PostActionIntegrationResponse{
+			EphemeralText: "Only moderators can approve new users.",
+		})
+		return
+	}
+
+	reviewPost, err := p.reviewPendingUser(parts[0], parts[1], userID)
+	if err != nil {
+		p.API.LogError("Failed to review pending user", "user_id", parts[0], "err", err.Error())
+		writeActionResponse(w, &model.PostActionIntegrationResponse{
+			EphemeralText: "Something went wrong while reviewing the user. Check the server logs.",
+		})
+		return
+	}
+	if reviewPost == nil {
+		writeActionResponse(w, &model.PostActionIntegrationResponse{
+			EphemeralText: "This user has already been reviewed.",
+		})
+		return
+	}
+
+	writeActionResponse(w, &model.PostActionIntegrationResponse{Update: reviewPost})
+}
+
+// handleQuarantineRelease handles the release button of a quarantine notification, which posts
+// to /api/v1/quarantine/{user_id}/release.
+func (p *Plugin) handleQuarantineRelease(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/quarantine/"), "/")
+	if len(parts) != 2 || !model.IsValidId(parts[0]) || parts[1] != quarantineRelease {
+		http.NotFound(w, r)
+		return
+	}
+
+	var request model.PostActionIntegrationRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+
+	userID := r.Header.Get("Mattermost-User-Id")
+	if !p.isModerator(userID) {
+		writeActionResponse(w, &model.PostActionIntegrationResponse{
+			EphemeralText: "Only moderators can release quarantined users.",
+		})
+		return
+	}
+
+	released, err := p.releaseUser(parts[0], userID)
+	if err != nil {
+		p.API.LogError("Failed to release quarantined user", "user_id", parts[0], "err", err.Error())
+		writeActionResponse(w, &model.PostActionIntegrationResponse{
+			EphemeralText: "Something went wrong while releasing the user. Check the server logs.",
+		})
+		return
+	}
+	if !released {
+		writeActionResponse(w, &model.PostActionIntegrationResponse{
+			EphemeralText: "This user is not quarantined.",
+		})
+		return
+	}
+
+	notification, err := p.decidedReviewPost(request.PostId, "Released", userID)
+	if err != nil {
+		p.API.LogWarn("Failed to update quarantine notification", "post_id", request.PostId, "err", err.Error())
+		writeActionResponse(w, &model.PostActionIntegrationResponse{EphemeralText: "The user has been released."})
+		return
+	}
+	writeActionResponse(w, &model.PostActionIntegrationResponse{Update: notification})
+}
+
+func writeActionResponse(w http.ResponseWriter, response *model.PostActionIntegrationResponse) {
+	writeJSON(w, response)
+}
+
+// handleAuditLog returns a page of the audit log, newest records first. The user_id, rule_id,
+// since and until (milliseconds) query parameters filter the records; page and per_page page
+// through them.
+func (p *Plugin) handleAuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	query := AuditQuery{
+		UserID: params.Get("user_id"),
+		RuleID: params.Get("rule_id"),
+	}
+	for name, value := range map[string]*int64{"since": &query.Since, "until": &query.Until} {
+		if params.Get(name) == "" {
+			continue
+		}
+		parsed, err := strconv.ParseInt(params.Get(name), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid "+name, http.StatusBadRequest)
+			return
+		}
+		*value = parsed
+	}
+	for name, value := range map[string]*int{"page": &query.Page, "per_page": &query.PerPage} {
+		if params.Get(name) == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(params.Get(name))
+		if err != nil || parsed < 0 {
+			http.Error(w, "invalid "+name, http.StatusBadRequest)
+			return
+		}
+		*value = parsed
+	}
+	if query.PerPage > 200 {
+		query.PerPage = 200
+	}
+
+	records, err := p.queryAuditLog(query)
+	if err != nil {
+		p.API.LogError("Failed to query audit log", "err", err.Error())
+		http.Error(w, "failed to query audit log", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, records)
+}
+
+// handleRules manages the rules of each kind: GET and POST on /api/v1/rules/{kind} list and
+// create rules, GET, PUT and DELETE on /api/v1/rules/{kind}/{id} read, replace and delete one.
+func (p *Plugin) handleRules(w http.ResponseWriter, r *http.Request) {
+	kind, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/rules/"), "/")
+	if !isRuleKind(kind) || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	actor := r.Header.Get("Mattermost-User-Id")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		rules, _, err := p.listStoredRules(kind)
+		if err != nil {
+			p.API.LogError("Failed to list rules", "kind", kind, "err", err.Error())
+			http.Error(w, "failed to list rules", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, rules)
+
+	case id == "" && r.Method == http.MethodPost:
+		var rule Rule
+		if !readJSON(w, r, &rule) {
+			return
+		}
+		if err := p.createStoredRule(kind, &rule); err != nil {
+			p.writeRuleError(w, kind, err)
+			return
+		}
+		p.auditRuleChange(actor, kind, "create_rule", &rule)
+		writeJSONStatus(w, http.StatusCreated, &rule)
+
+	case id != "" && r.Method == http.MethodGet:
+		rule, err := p.getStoredRule(kind, id)
+		if err != nil {
+			p.writeRuleError(w, kind, err)
+			return
+		}
+		if rule == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, rule)
+
+	case id != "" && r.Method == http.MethodPut:
+		var rule Rule
+		if !readJSON(w, r, &rule) {
+			return
+		}
+		rule.ID = id
+		if err := p.replaceStoredRule(kind, &rule); err != nil {
+			p.writeRuleError(w, kind, err)
+			return
+		}
+		p.auditRuleChange(actor, kind, "update_rule", &rule)
+		writeJSON(w, &rule)
+
+	case id != "" && r.Method == http.MethodDelete:
+		if err := p.deleteStoredRule(kind, id); err != nil {
+			p.writeRuleError(w, kind, err)
+			return
+		}
+		p.auditRuleChange(actor, kind, "delete_rule", &Rule{ID: id})
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (p *Plugin) writeRuleError(w http.ResponseWriter, kind string, err error) {
+	switch {
+	case errors.Is(err, errRuleNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errRuleExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errInvalidRule):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		p.API.LogError("Failed to update rules", "kind", kind, "err", err.Error())
+		http.Error(w, "failed to update rules", http.StatusInternalServerError)
+	}
+}
+
+func (p *Plugin) auditRuleChange(actor, kind, action string, rule *Rule) {
+	p.audit(&AuditRecord{
+		Actor:   actor,
+		Feature: "rules",
+		Action:  action,
+		Rules:   []string{rule.ID},
+		Excerpt: excerpt(strings.TrimSuffix(kind+": "+rule.Pattern, ": ")),
+	})
+}
+
+// textTestResponse is what the word filter would do with a text.
+type textTestResponse struct {
+	Action  string   `json:"action"`
+	Reason  string   `json:"reason,omitempty"`
+	Matches []string `json:"matches"`
+	Rules   []*Rule  `json:"rules"`
+	// Message is the text as it would be published, after censoring.
+	Message string `json:"message"`
+}
+
+// handleTestText runs a text through the word filter, as if the requesting user had posted it
+// to the given channel, without enforcing anything.
+func (p *Plugin) handleTestText(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request struct {
+		Message   string `json:"message"`
+		ChannelID string `json:"channel_id"`
+	}
+	if !readJSON(w, r, &request) {
+		return
+	}
+
+	writeJSON(w, p.testText(r.Header.Get("Mattermost-User-Id"), request.ChannelID, request.Message))
+}
+
+func (p *Plugin) testText(userID, channelID, message string) *textTestResponse {
+	post := &model.Post{UserId: userID, ChannelId: channelID, Message: message}
+	result := p.checkPostBadWords(p.getConfiguration(), post)
+
+	response := &textTestResponse{
+		Action:  result.Action.String(),
+		Reason:  result.Reason,
+		Matches: result.Matches,
+		Rules:   result.Rules,
+		Message: message,
+	}
+	if result.Post != nil {
+		response.Message = result.Post.Message
+	}
+	if response.Matches == nil {
+		response.Matches = []string{}
+	}
+	if response.Rules == nil {
+		response.Rules = []*Rule{}
+	}
+	return response
+}
+
+// userTestResponse is what the checks of new users would find about a user.
+type userTestResponse struct {
+	RequiresModeration bool     `json:"requires_moderation"`
+	Reasons            []string `json:"reasons"`
+}
+
+// handleTestUser runs the checks of new users against an existing user, given by user_id, or
+// against the username, nickname and email of a hypothetical one.
+func (p *Plugin) handleTestUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request struct {
+		UserID   string `json:"user_id"`
+		Username string `json:"username"`
+		Nickname string `json:"nickname"`
+		Email    string `json:"email"`
+	}
+	if !readJSON(w, r, &request) {
+		return
+	}
+
+	user := &model.User{Username: request.Username, Nickname: request.Nickname, Email: request.Email}
+	if request.UserID != "" {
+		existing, appErr := p.API.GetUser(request.UserID)
+		if appErr != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		user = existing
+	}
+
+	writeJSON(w, p.testUser(user))
+}
+
+func (p *Plugin) testUser(user *model.User) *userTestResponse {
+	response := &userTestResponse{Reasons: []string{}}
+	for _, err := range p.RequiresModeration(user, p.userValidators()...) {
+		response.RequiresModeration = true
+		response.Reasons = append(response.Reasons, err.Error())
+	}
+	return response
+}
+
+// handleFlaggedUsers lists the flagged users, most recently flagged first.
+func (p *Plugin) handleFlaggedUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flaggedUsers, err := p.listFlaggedUsers()
+	if err != nil {
+		p.API.LogError("Failed to list flagged users", "err", err.Error())
+		http.Error(w, "failed to list flagged users", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, flaggedUsers)
+}
+
+// handleFlaggedUser reads (GET), flags (PUT, with an optional reason) and unflags (DELETE) the
+// user of /api/v1/flagged/{user_id}.
+func (p *Plugin) handleFlaggedUser(w http.ResponseWriter, r *http.Request) {
+	userID := strings.TrimPrefix(r.URL.Path, "/api/v1/flagged/")
+	if !model.IsValidId(userID) {
+		http.NotFound(w, r)
+		return
+	}
+	actor := r.Header.Get("Mattermost-User-Id")
+
+	switch r.Method {
+	case http.MethodGet:
+		flagged, err := p.getFlaggedUser(userID)
+		if err != nil {
+			p.API.LogError("Failed to load flagged user", "user_id", userID, "err", err.Error())
+			http.Error(w, "failed to load flagged user", http.StatusInternalServerError)
+			return
+		}
+		if flagged == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, flagged)
+
+	case http.MethodPut:
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if r.ContentLength != 0 && !readJSON(w, r, &request) {
+			return
+		}
+		if _, appErr := p.API.GetUser(userID); appErr != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		flagged, err := p.flagUser(userID, request.Reason, actor)
+		if err != nil {
+			p.API.LogError("Failed to flag user", "user_id", userID, "err", err.Error())
+			http.Error(w, "failed to flag user", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, flagged)
+
+	case http.MethodDelete:
+		unflagged, err := p.unflagUser(userID, actor)
+		if err != nil {
+			p.API.LogError("Failed to unflag user", "user_id", userID, "err", err.Error())
+			http.Error(w, "failed to unflag user", http.StatusInternalServerError)
+			return
+		}
+		if !unflagged {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUserAction acts on a user on POST to /api/v1/users/{user_id}/{action}: restore
+// reactivates a user deactivated by the plugin and rebuilds them from their snapshot, release
+// lifts their quarantine.
+func (p *Plugin) handleUserAction(w http.ResponseWriter, r *http.Request) {
+	userID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/users/"), "/")
+	if !model.IsValidId(userID) || (action != "restore" && action != quarantineRelease) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor := r.Header.Get("Mattermost-User-Id")
+
+	if action == quarantineRelease {
+		released, err := p.releaseUser(userID, actor)
+		if err != nil {
+			p.API.LogError("Failed to release quarantined user", "user_id", userID, "err", err.Error())
+			http.Error(w, "failed to release user", http.StatusInternalServerError)
+			return
+		}
+		if !released {
+			http.Error(w, "user is not quarantined", http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	result, err := p.restoreUser(actor, userID)
+	if errors.Is(err, errUserNotRestorable) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		p.API.LogError("Failed to restore user", "user_id", userID, "err", err.Error())
+		http.Error(w, "failed to restore user", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, result)
+}
+
+// handleCleanupJobs lists the cleanups of users with steps that failed, oldest first.
+func (p *Plugin) handleCleanupJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobs, err := p.listCleanupJobs()
+	if err != nil {
+		p.API.LogError("Failed to list cleanups", "err", err.Error())
+		http.Error(w, "failed to list cleanups", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, jobs)
+}
+
+// domainListInfo describes an uploaded domain list without its entries.
+type domainListInfo struct {
+	Name        string `json:"name"`
+	Source      string `json:"source"`
+	Format      string `json:"format"`
+	Description string `json:"description,omitempty"`
+	Entries     int    `json:"entries"`
+	UpdatedBy   string `json:"updated_by"`
+	UpdatedAt   int64  `json:"updated_at"`
+}
+
+// handleDomainLists lists the uploaded domain lists, sorted by name.
+func (p *Plugin) handleDomainLists(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	lists, err := p.listDomainLists()
+	if err != nil {
+		p.API.LogError("Failed to list domain lists", "err", err.Error())
+		http.Error(w, "failed to list domain lists", http.StatusInternalServerError)
+		return
+	}
+	infos := make([]*domainListInfo, 0, len(lists))
+	for _, list := range lists {
+		infos = append(infos, &domainListInfo{
+			Name:        list.Name,
+			Source:      domainListSource(list.Name),
+			Format:      list.Format,
+			Description: list.Description,
+			Entries:     len(list.Entries),
+			UpdatedBy:   list.UpdatedBy,
+			UpdatedAt:   list.UpdatedAt,
+		})
+	}
+	writeJSON(w, infos)
+}
+
+// handleDomainList manages an uploaded domain list on /api/v1/domains/lists/{name}: GET reads
+// it, PUT uploads or replaces it and DELETE deletes it. The body of a PUT is the list itself, in
+// the format given by the format query parameter: text, json or csv.
+func (p *Plugin) handleDomainList(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/domains/lists/")
+	if validateDomainListName(name) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	actor := r.Header.Get("Mattermost-User-Id")
+
+	switch r.Method {
+	case http.MethodGet:
+		list, err := p.getDomainList(name)
+		if err != nil {
+			p.API.LogError("Failed to get domain list", "name", name, "err", err.Error())
+			http.Error(w, "failed to get domain list", http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, list)
+
+	case http.MethodPut:
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = domainListFormatText
+		}
+		entries, err := parseDomainList(format, http.MaxBytesReader(w, r.Body, maxDomainListSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		list := &DomainList{
+			Name:        name,
+			Format:      format,
+			Description: r.URL.Query().Get("description"),
+			Entries:     entries,
+		}
+		if err := p.saveDomainList(list, actor); err != nil {
+			p.API.LogError("Failed to save domain list", "name", name, "err", err.Error())
+			http.Error(w, "failed to save domain list", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, list)
+
+	case http.MethodDelete:
+		deleted, err := p.deleteDomainList(name, actor)
+		if err != nil {
+			p.API.LogError("Failed to delete domain list", "name", name, "err", err.Error())
+			http.Error(w, "failed to delete domain list", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDomainCheck tells which entries of which domain lists block the domain or email address
+// of the domain query parameter.
+func (p *Plugin) handleDomainCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	domain := r.URL.Query().Get("domain")
+	if domain == "" {
+		http.Error(w, "missing domain", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, p.explainDomain(domain))
+}
+
+// handleBannedEmails lists the banned email addresses on GET, most recently banned first, and
+// bans the normalized form of {"email": "...", "reason": "..."} on POST.
+func (p *Plugin) handleBannedEmails(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		bans, err := p.listBannedEmails()
+		if err != nil {
+			p.API.LogError("Failed to list banned emails", "err", err.Error())
+			http.Error(w, "failed to list banned emails", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, bans)
+
+	case http.MethodPost:
+		var request struct {
+			Email  string `json:"email"`
+			Reason string `json:"reason"`
+		}
+		if !readJSON(w, r, &request) {
+			return
+		}
+		if !strings.Contains(request.Email, "@") {
+			http.Error(w, "invalid email address", http.StatusBadRequest)
+			return
+		}
+		banned, err := p.banEmail(request.Email, request.Reason, r.Header.Get("Mattermost-User-Id"))
+		if err != nil {
+			p.API.LogError("Failed to ban email", "err", err.Error())
+			http.Error(w, "failed to ban email", http.StatusInternalServerError)
+			return
+		}
+		writeJSONStatus(w, http.StatusCreated, banned)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleBannedEmail lifts the ban of the normalized form of the address on DELETE to
+// /api/v1/emails/banned/{email}.
+func (p *Plugin) handleBannedEmail(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimPrefix(r.URL.Path, "/api/v1/emails/banned/")
+	if !strings.Contains(email, "@") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	unbanned, err := p.unbanEmail(email, r.Header.Get("Mattermost-User-Id"))
+	if err != nil {
+		p.API.LogError("Failed to unban email", "err", err.Error())
+		http.Error(w, "failed to unban email", http.StatusInternalServerError)
+		return
+	}
+	if !unbanned {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// emailCheckResponse is what the checks of new users make of an email address.
+type emailCheckResponse struct {
+	Normalized string       `json:"normalized"`
+	DigitRatio float64      `json:"digit_ratio"`
+	Entropy    float64      `json:"entropy"`
+	Banned     *BannedEmail `json:"banned,omitempty"`
+	Reasons    []string     `json:"reasons"`
+}
+
+// handleEmailCheck runs the email checks of new users against the email query parameter, and
+// shows its normalized form and local part scores.
+func (p *Plugin) handleEmailCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	email := r.URL.Query().Get("email")
+	if !strings.Contains(email, "@") {
+		http.Error(w, "invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	response, err := p.checkEmail(email)
+	if err != nil {
+		p.API.LogError("Failed to check email", "err", err.Error())
+		http.Error(w, "failed to check email", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, response)
+}
+
+// checkEmail runs the email checks of new users against an address.
+func (p *Plugin) checkEmail(email string) (*emailCheckResponse, error) {
+	normalized := normalizeEmail(email)
+	local, _, _ := strings.Cut(normalized, "@")
+	banned, err := p.getBannedEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &emailCheckResponse{
+		Normalized: normalized,
+		DigitRatio: localPartDigitRatio(local),
+		Entropy:    localPartEntropy(local),
+		Banned:     banned,
+		Reasons:    []string{},
+	}
+	user := &model.User{Email: email}
+	for _, err := range p.RequiresModeration(user, p.checkBadEmail, p.checkAllowedDomain, p.checkBannedEmail, p.checkSuspiciousEmail) {
+		response.Reasons = append(response.Reasons, err.Error())
+	}
+	return response, nil
+}
+
+// maxRequestBodySize bounds the JSON bodies the API reads.
+const maxRequestBodySize = 1 << 20
+
+// readJSON decodes the body of the request, and answers with an error if it cannot.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(v); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
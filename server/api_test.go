@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI(t *testing.T) {
+	moderatorID := model.NewId()
+	newAPIPlugin := func(t *testing.T) (*Plugin, *MembershipMockAPI) {
+		p, api := newTestPlugin(t, &configuration{
+			CensorCharacter: "*",
+			PostFilters:     "bad_words",
+			EnableAuditLog:  true,
+		})
+		api.admins[moderatorID] = true
+		api.GetUserFunc = func(userID string) (*model.User, *model.AppError) {
+			if userID == "missing" {
+				return nil, model.NewAppError("GetUser", "not_found", nil, "", http.StatusNotFound)
+			}
+			return &model.User{Id: userID, Username: "user-" + userID, Email: userID + "@example.com"}, nil
+		}
+		return p, api
+	}
+
+	request := func(p *Plugin, userID, method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if userID != "" {
+			r.Header.Set("Mattermost-User-Id", userID)
+		}
+		w := httptest.NewRecorder()
+		p.ServeHTTP(&plugin.Context{}, w, r)
+		return w
+	}
+
+	t.Run("requires a moderator", func(t *testing.T) {
+		p, _ := newAPIPlugin(t)
+
+		assert.Equal(t, http.StatusUnauthorized, request(p, "", http.MethodGet, "/api/v1/rules/words", "").Code)
+		assert.Equal(t, http.StatusForbidden, request(p, model.NewId(), http.MethodGet, "/api/v1/rules/words", "").Code)
+		assert.Equal(t, http.StatusOK, request(p, moderatorID, http.MethodGet, "/api/v1/rules/words", "").Code)
+	})
+
+	t.Run("manages word rules", func(t *testing.T) {
+		p, _ := newAPIPlugin(t)
+
+		w := request(p, moderatorID, http.MethodPost, "/api/v1/rules/words", `{"id": "spam", "pattern": "buy now"}`)
+		require.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, http.StatusConflict,
+			request(p, moderatorID, http.MethodPost, "/api/v1/rules/words", `{"id": "spam", "pattern": "sale"}`).Code)
+		assert.Equal(t, http.StatusBadRequest,
+			request(p, moderatorID, http.MethodPost, "/api/v1/rules/words", `{"id": "broken", "pattern": "(("}`).Code)
+
+		post, _ := p.FilterPost(&model.Post{UserId: "author", Message: "buy now!"})
+		require.NotNil(t, post)
+		assert.Equal(t, "*******!", post.Message)
+
+		w = request(p, moderatorID, http.MethodPut, "/api/v1/rules/words/spam", `{"pattern": "sale", "action": "alert"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		w = request(p, moderatorID, http.MethodGet, "/api/v1/rules/words/spam", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var rule Rule
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&rule))
+		assert.Equal(t, Rule{ID: "spam", Pattern: "sale", Action: RuleActionAlert}, rule)
+
+		post, _ = p.FilterPost(&model.Post{UserId: "author", Message: "buy now!"})
+		assert.Equal(t, "buy now!", post.Message)
+
+		assert.Equal(t, http.StatusNoContent, request(p, moderatorID, http.MethodDelete, "/api/v1/rules/words/spam", "").Code)
+		assert.Equal(t, http.StatusNotFound, request(p, moderatorID, http.MethodDelete, "/api/v1/rules/words/spam", "").Code)
+		assert.Empty(t, p.getStoredRules(ruleKindWords))
+
+		records, err := p.queryAuditLog(AuditQuery{RuleID: "spam"})
+		require.NoError(t, err)
+		require.Len(t, records, 4)
+		assert.Equal(t, "delete_rule", records[0].Action)
+		assert.Equal(t, moderatorID, records[0].Actor)
+	})
+
+	t.Run("username and domain rules check new users", func(t *testing.T) {
+		p, _ := newAPIPlugin(t)
+
+		require.Equal(t, http.StatusCreated,
+			request(p, moderatorID, http.MethodPost, "/api/v1/rules/usernames", `{"id": "admins", "pattern": "admin"}`).Code)
+		require.Equal(t, http.StatusCreated,
+			request(p, moderatorID, http.MethodPost, "/api/v1/rules/domains", `{"id": "spam-domain", "pattern": "spam\\.example"}`).Code)
+		assert.Equal(t, http.StatusBadRequest,
+			request(p, moderatorID, http.MethodPost, "/api/v1/rules/domains", `{"id": "x", "pattern": "x", "action": "reject"}`).Code)
+		assert.Equal(t, http.StatusBadRequest,
+			request(p, moderatorID, http.MethodPost, "/api/v1/rules/usernames", `{"id": "x", "pattern": "x", "exceptions": ["y"]}`).Code)
+		assert.Equal(t, http.StatusNotFound,
+			request(p, moderatorID, http.MethodGet, "/api/v1/rules/channels", "").Code)
+
+		assert.EqualError(t, p.checkBadUsername(&model.User{Username: "the-admin"}), "username matches rule admins: the-admin")
+		assert.EqualError(t, p.checkBadEmail(&model.User{Email: "a@spam.example"}), "email domain matches rule spam-domain: a@spam.example")
+		assert.NoError(t, p.checkBadEmail(&model.User{Email: "a@example.com"}))
+	})
+
+	t.Run("tests texts and users", func(t *testing.T) {
+		p, _ := newAPIPlugin(t)
+		require.NoError(t, p.createStoredRule(ruleKindWords, &Rule{ID: "spam", Pattern: "buy now", Action: RuleActionReject}))
+		require.NoError(t, p.createStoredRule(ruleKindUsernames, &Rule{ID: "admins", Pattern: "admin"}))
+
+		w := request(p, moderatorID, http.MethodPost, "/api/v1/test/text", `{"message": "please buy now"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		var text textTestResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&text))
+		assert.Equal(t, "reject", text.Action)
+		assert.Equal(t, []string{"buy now"}, text.Matches)
+		require.Len(t, text.Rules, 1)
+		assert.Equal(t, "spam", text.Rules[0].ID)
+
+		w = request(p, moderatorID, http.MethodPost, "/api/v1/test/user", `{"username": "admin", "email": "admin@example.com"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		var user userTestResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&user))
+		assert.True(t, user.RequiresModeration)
+		assert.Equal(t, []string{"username matches rule admins: admin"}, user.Reasons)
+
+		assert.Equal(t, http.StatusNotFound,
+			request(p, moderatorID, http.MethodPost, "/api/v1/test/user", `{"user_id": "missing"}`).Code)
+		assert.Equal(t, http.StatusBadRequest,
+			request(p, moderatorID, http.MethodPost, "/api/v1/test/text", `message`).Code)
+	})
+
+	t.Run("manages flagged users", func(t *testing.T) {
+		p, api := newAPIPlugin(t)
+		userID := model.NewId()
+
+		assert.Equal(t, http.StatusNotFound, request(p, moderatorID, http.MethodGet, "/api/v1/flagged/"+userID, "").Code)
+
+		w := request(p, moderatorID, http.MethodPut, "/api/v1/flagged/"+userID, `{"reason": "spammy invites"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = request(p, moderatorID, http.MethodGet, "/api/v1/flagged", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var flaggedUsers []*FlaggedUser
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&flaggedUsers))
+		require.Len(t, flaggedUsers, 1)
+		assert.Equal(t, userID, flaggedUsers[0].UserID)
+		assert.Equal(t, "spammy invites", flaggedUsers[0].Reason)
+		assert.Equal(t, moderatorID, flaggedUsers[0].FlaggedBy)
+
+		assert.Contains(t, api.kv, flaggedUsersKey, "flags are kept under one key")
+
+		assert.Equal(t, http.StatusNoContent, request(p, moderatorID, http.MethodDelete, "/api/v1/flagged/"+userID, "").Code)
+		assert.Equal(t, http.StatusNotFound, request(p, moderatorID, http.MethodDelete, "/api/v1/flagged/"+userID, "").Code)
+
+		records, err := p.queryAuditLog(AuditQuery{UserID: userID})
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, "unflag", records[0].Action)
+		assert.Equal(t, "flag", records[1].Action)
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// pendingUserKeyPrefix prefixes the KV store keys of new users waiting for approval.
+const pendingUserKeyPrefix = "pending_user_"
+
+// PendingUser is a new user that is deactivated until a moderator approves or denies them.
+type PendingUser struct {
+	UserID       string   `json:"user_id"`
+	Reasons      []string `json:"reasons"`
+	RequestedAt  int64    `json:"requested_at"`
+	ReviewPostID string   `json:"review_post_id"`
+}
+
+func pendingUserKey(userID string) string {
+	return pendingUserKeyPrefix + userID
+}
+
+// validateApprovalResponses checks that new users waiting for approval can be reviewed: every
+// check and rule that responds with approval needs the moderation channel.
+func (p *Plugin) validateApprovalResponses(configuration *configuration, responses map[string]UserResponse) error {
+	if configuration.ModerationChannelID != "" {
+		return nil
+	}
+
+	var approving []string
+	for _, validator := range userValidatorNames {
+		if responses[validator] == UserResponseApproval {
+			approving = append(approving, "check "+validator)
+		}
+	}
+	for _, kind := range []string{ruleKindUsernames, ruleKindDomains} {
+		for _, rule := range p.getStoredRules(kind) {
+			if rule.Response == UserResponseApproval {
+				approving = append(approving, "rule "+rule.ID)
+			}
+		}
+	}
+
+	if len(approving) > 0 {
+		return fmt.Errorf("a moderation channel is needed to review the new users sent for approval by %s", strings.Join(approving, ", "))
+	}
+	return nil
+}
+
+// requireApproval deactivates a new user and asks the moderators to approve or deny them in
+// the moderation channel.
+func (p *Plugin) requireApproval(user *model.User, reasons []string) error {
+	channelID := p.getConfiguration().ModerationChannelID
+	if channelID == "" {
+		return errors.New("no moderation channel is configured to approve new users")
+	}
+
+	reviewPost := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channelID,
+		Message:   fmt.Sprintf("The new user @%s needs the approval of a moderator.", user.Username),
+	}
+	model.ParseSlackAttachment(reviewPost, []*model.SlackAttachment{{
+		Fields: []*model.SlackAttachmentField{
+			{Title: "Username", Value: user.Username, Short: true},
+			{Title: "Email", Value: user.Email, Short: true},
+			{Title: "Reasons", Value: strings.Join(reasons, "\n")},
+		},
+		Actions: []*model.PostAction{
+			decisionAction("pending/"+user.Id, holdDecisionApprove, "Approve", "good"),
+			decisionAction("pending/"+user.Id, holdDecisionDeny, "Deny", "danger"),
+		},
+	}})
+	created, appErr := p.API.CreatePost(reviewPost)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to post the approval request")
+	}
+
+	if err := p.storePendingUser(&PendingUser{
+		UserID:       user.Id,
+		Reasons:      reasons,
+		RequestedAt:  model.GetMillis(),
+		ReviewPostID: created.Id,
+	}); err != nil {
+		_ = p.API.DeletePost(created.Id)
+		return err
+	}
+
+	if appErr := p.API.UpdateUserActive(user.Id, false); appErr != nil {
+		return errors.Wrap(appErr, "failed to deactivate user until approval")
+	}
+	return nil
+}
+
+func (p *Plugin) storePendingUser(pending *PendingUser) error {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode pending user")
+	}
+	if appErr := p.API.KVSet(pendingUserKey(pending.UserID), data); appErr != nil {
+		return errors.Wrap(appErr, "failed to store pending user")
+	}
+	return nil
+}
+
+// takePendingUser removes a pending user from the store and returns it. It returns nil if the
+// user is not pending, for instance because another moderator already reviewed them.
+func (p *Plugin) takePendingUser(userID string) (*PendingUser, error) {
+	data, appErr := p.API.KVGet(pendingUserKey(userID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to load pending user")
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	var pending PendingUser
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, errors.Wrap(err, "failed to decode pending user")
+	}
+
+	deleted, appErr := p.API.KVCompareAndDelete(pendingUserKey(userID), data)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to remove pending user")
+	}
+	if !deleted {
+		return nil, nil
+	}
+	return &pending, nil
+}
+
+// reviewPendingUser carries out a moderator's decision about a new user: approved users are
+// reactivated, denied users are sanitized like any other failed new user. It returns the
+// updated review post, or nil if the user was already reviewed.
+func (p *Plugin) reviewPendingUser(userID, decision, moderatorID string) (*model.Post, error) {
+	pending, err := p.takePendingUser(userID)
+	if err != nil || pending == nil {
+		return nil, err
+	}
+
+	outcome := "Denied"
+	if decision == holdDecisionApprove {
+		outcome = "Approved"
+		if appErr := p.API.UpdateUserActive(userID, true); appErr != nil {
+			// Put the user back so that they can be reviewed again.
+			_ = p.storePendingUser(pending)
+			return nil, errors.Wrap(appErr, "failed to reactivate approved user")
+		}
+	} else {
+		user, appErr := p.API.GetUser(userID)
+		if appErr != nil {
+			_ = p.storePendingUser(pending)
+			return nil, errors.Wrap(appErr, "failed to get denied user")
+		}
+		if err := p.cleanupUser(user); err != nil {
+			p.API.LogWarn("Cleanup of denied user is incomplete", "user_id", userID, "err", err.Error())
+		}
+	}
+
+	p.audit(&AuditRecord{
+		Actor:   moderatorID,
+		UserID:  userID,
+		Feature: shadowNewUsers,
+		Action:  decision,
+		Excerpt: excerpt(strings.Join(pending.Reasons, "; ")),
+	})
+
+	return p.decidedReviewPost(pending.ReviewPostID, outcome, moderatorID)
+}
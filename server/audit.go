@@ -0,0 +1,473 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// The audit log is stored in the KV store as a sequence of pages of auditPageSize records.
+// auditHeadKey holds the number of the page records are appended to; once it is full, the
+// next page is started. Pages are only ever appended to, so that paging through the log from
+// the head backwards returns the newest records first.
+//
+// Records are queued by the hooks and appended in batches by a background writer, so that
+// posting never waits for the KV store. Every page and index expires after the retention
+// period of the AuditRetentionDays setting, counted from its last write.
+//
+// The pages holding records of a user or a rule are listed under an index key, so that the
+// history of a user or a rule is read without going through the whole log.
+const (
+	auditHeadKey            = "audit_head"
+	auditPageKeyPrefix      = "audit_page_"
+	auditUserIndexKeyPrefix = "audit_user_"
+	auditRuleIndexKeyPrefix = "audit_rule_"
+	auditPageSize           = 100
+
+	// auditIndexSize bounds the number of pages an index lists; older pages are forgotten.
+	auditIndexSize = 1000
+
+	// auditQueueSize bounds the number of records waiting to be written. Records that do not
+	// fit are dropped, rather than slowing down the hooks.
+	auditQueueSize = 1000
+
+	// auditExcerptLength is the number of characters of a message kept in its record.
+	auditExcerptLength = 200
+
+	// auditActorSystem is the actor of decisions the plugin takes on its own.
+	auditActorSystem = "system"
+
+	// auditAppendAttempts bounds the retries of concurrent writes to the same key.
+	auditAppendAttempts = 10
+)
+
+// AuditRecord is a single decision of the plugin.
+type AuditRecord struct {
+	ID        string   `json:"id"`
+	Timestamp int64    `json:"timestamp"`
+	Actor     string   `json:"actor"`
+	UserID    string   `json:"user_id"`
+	ChannelID string   `json:"channel_id,omitempty"`
+	Feature   string   `json:"feature"`
+	Action    string   `json:"action"`
+	Rules     []string `json:"rules,omitempty"`
+	Excerpt   string   `json:"excerpt,omitempty"`
+	Shadow    bool     `json:"shadow,omitempty"`
+}
+
+// AuditQuery filters the records of the audit log. Zero values do not filter.
+type AuditQuery struct {
+	UserID string
+	RuleID string
+	// Since and Until bound the timestamps of the records, in milliseconds.
+	Since int64
+	Until int64
+
+	Page    int
+	PerPage int
+}
+
+func auditPageKey(page int) string {
+	return auditPageKeyPrefix + strconv.Itoa(page)
+}
+
+func auditUserIndexKey(userID string) string {
+	return auditUserIndexKeyPrefix + userID
+}
+
+// auditRuleIndexKey hashes the rule id, which can be longer than a key may be.
+func auditRuleIndexKey(ruleID string) string {
+	hash := sha256.Sum256([]byte(ruleID))
+	return auditRuleIndexKeyPrefix + hex.EncodeToString(hash[:])
+}
+
+// parseAuditRetention validates the AuditRetentionDays setting and stores the retention period
+// in seconds, zero to keep records forever.
+func parseAuditRetention(configuration *configuration) error {
+	configuration.auditRetentionSeconds = 0
+	setting := strings.TrimSpace(configuration.AuditRetentionDays)
+	if setting == "" {
+		return nil
+	}
+	days, err := strconv.Atoi(setting)
+	if err != nil || days < 0 {
+		return fmt.Errorf("invalid number of days: %q", configuration.AuditRetentionDays)
+	}
+	configuration.auditRetentionSeconds = int64(days) * 24 * 60 * 60
+	return nil
+}
+
+// excerpt shortens a message for the audit log.
+func excerpt(message string) string {
+	runes := []rune(message)
+	if len(runes) <= auditExcerptLength {
+		return message
+	}
+	return string(runes[:auditExcerptLength]) + "…"
+}
+
+// audit queues a record for the audit log, if it is enabled. Failures are logged, so that
+// they never keep the plugin from taking its decision. When the background writer is not
+// running, before the plugin is activated or once it is deactivated, the record is written
+// at once.
+func (p *Plugin) audit(record *AuditRecord) {
+	if !p.getConfiguration().EnableAuditLog {
+		return
+	}
+
+	record.ID = model.NewId()
+	record.Timestamp = model.GetMillis()
+	if record.Actor == "" {
+		record.Actor = auditActorSystem
+	}
+
+	p.auditLock.RLock()
+	defer p.auditLock.RUnlock()
+	if p.auditQueue == nil {
+		if err := p.appendAuditRecords([]*AuditRecord{record}, nil); err != nil {
+			p.API.LogError("Failed to write audit record", "feature", record.Feature, "action", record.Action, "err", err.Error())
+		}
+		return
+	}
+	select {
+	case p.auditQueue <- record:
+	default:
+		p.API.LogError("Audit log queue is full, record dropped", "feature", record.Feature, "action", record.Action)
+	}
+}
+
+// startAuditWriter starts writing the queued audit records in the background, until
+// stopAuditWriter is called.
+func (p *Plugin) startAuditWriter() {
+	queue := make(chan *AuditRecord, auditQueueSize)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	p.auditLock.Lock()
+	p.auditQueue = queue
+	p.auditLock.Unlock()
+	p.stopAuditWriter = func() {
+		// Records audited from now on are written at once, the queued ones by the writer.
+		p.auditLock.Lock()
+		p.auditQueue = nil
+		p.auditLock.Unlock()
+		close(stop)
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+		// indexed remembers the page last added to each index, to skip updating it again.
+		indexed := map[string]int{}
+		for {
+			select {
+			case record := <-queue:
+				p.writeAuditBatch(record, queue, indexed)
+			case <-stop:
+				// Write what is left before the plugin stops.
+				for len(queue) > 0 {
+					p.writeAuditBatch(<-queue, queue, indexed)
+				}
+				return
+			}
+		}
+	}()
+}
+
+// writeAuditBatch appends a record together with the records queued behind it.
+func (p *Plugin) writeAuditBatch(record *AuditRecord, queue chan *AuditRecord, indexed map[string]int) {
+	batch := []*AuditRecord{record}
+	for len(batch) < auditPageSize && len(queue) > 0 {
+		batch = append(batch, <-queue)
+	}
+	if err := p.appendAuditRecords(batch, indexed); err != nil {
+		p.API.LogError("Failed to write audit records", "count", len(batch), "err", err.Error())
+	}
+}
+
+// auditFilterResult records the decision of a post filter.
+func (p *Plugin) auditFilterResult(feature string, post *model.Post, result *FilterResult, shadow bool) {
+	if result.Action == FilterActionAllow && len(result.Rules) == 0 {
+		return
+	}
+
+	action := result.Action.String()
+	if result.Action == FilterActionAllow {
+		action = string(RuleActionAlert)
+	}
+	var rules []string
+	for _, rule := range result.Rules {
+		rules = append(rules, rule.ID)
+	}
+
+	p.audit(&AuditRecord{
+		UserID:    post.UserId,
+		ChannelID: post.ChannelId,
+		Feature:   feature,
+		Action:    action,
+		Rules:     rules,
+		Excerpt:   excerpt(post.Message),
+		Shadow:    shadow,
+	})
+}
+
+// appendAuditRecords appends records to the head page of the audit log, starting new pages as
+// they fill up, and adds the pages to the indexes of the users and rules of the records.
+// indexed, if not nil, remembers the page last added to each index across calls.
+func (p *Plugin) appendAuditRecords(records []*AuditRecord, indexed map[string]int) error {
+	expireInSeconds := p.getConfiguration().auditRetentionSeconds
+	for attempt := 0; len(records) > 0; attempt++ {
+		if attempt == auditAppendAttempts {
+			return fmt.Errorf("gave up appending audit records after %d attempts", auditAppendAttempts)
+		}
+
+		head, headData, err := p.auditHead()
+		if err != nil {
+			return err
+		}
+
+		page, pageData, err := p.auditPage(head)
+		if err != nil {
+			return err
+		}
+
+		room := auditPageSize - len(page)
+		if room <= 0 {
+			// Start the next page, unless someone else already did.
+			next, _ := json.Marshal(head + 1)
+			if _, appErr := p.API.KVCompareAndSet(auditHeadKey, headData, next); appErr != nil {
+				return errors.Wrap(appErr, "failed to start audit page")
+			}
+			continue
+		}
+
+		appended := records[:min(room, len(records))]
+		data, err := json.Marshal(append(page, appended...))
+		if err != nil {
+			return errors.Wrap(err, "failed to encode audit page")
+		}
+		stored, appErr := p.API.KVSetWithOptions(auditPageKey(head), data, model.PluginKVSetOptions{
+			Atomic:          true,
+			OldValue:        pageData,
+			ExpireInSeconds: expireInSeconds,
+		})
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to append audit records")
+		}
+		if !stored {
+			continue
+		}
+
+		if err := p.indexAuditRecords(head, appended, indexed); err != nil {
+			return err
+		}
+		records = records[len(appended):]
+		attempt = -1
+	}
+	return nil
+}
+
+// indexAuditRecords adds a page to the indexes of the users and rules of its new records.
+func (p *Plugin) indexAuditRecords(page int, records []*AuditRecord, indexed map[string]int) error {
+	var keys []string
+	for _, record := range records {
+		if record.UserID != "" {
+			keys = append(keys, auditUserIndexKey(record.UserID))
+		}
+		for _, rule := range record.Rules {
+			keys = append(keys, auditRuleIndexKey(rule))
+		}
+	}
+
+	for _, key := range keys {
+		if last, ok := indexed[key]; ok && last == page {
+			continue
+		}
+		if err := p.addToAuditIndex(key, page); err != nil {
+			return err
+		}
+		if indexed != nil {
+			indexed[key] = page
+		}
+	}
+	return nil
+}
+
+// addToAuditIndex adds a page to an index, unless it is already listed.
+func (p *Plugin) addToAuditIndex(key string, page int) error {
+	for attempt := 0; attempt < auditAppendAttempts; attempt++ {
+		pages, oldData, err := p.auditIndex(key)
+		if err != nil {
+			return err
+		}
+		if len(pages) > 0 && pages[len(pages)-1] >= page {
+			return nil
+		}
+
+		pages = append(pages, page)
+		if len(pages) > auditIndexSize {
+			pages = pages[len(pages)-auditIndexSize:]
+		}
+		data, err := json.Marshal(pages)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode audit index")
+		}
+		stored, appErr := p.API.KVSetWithOptions(key, data, model.PluginKVSetOptions{
+			Atomic:          true,
+			OldValue:        oldData,
+			ExpireInSeconds: p.getConfiguration().auditRetentionSeconds,
+		})
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to update audit index")
+		}
+		if stored {
+			return nil
+		}
+	}
+	return fmt.Errorf("gave up updating audit index after %d attempts", auditAppendAttempts)
+}
+
+// auditIndex returns the pages listed by an index, oldest first, and its stored value.
+func (p *Plugin) auditIndex(key string) ([]int, []byte, error) {
+	data, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return nil, nil, errors.Wrap(appErr, "failed to load audit index")
+	}
+	if data == nil {
+		return nil, nil, nil
+	}
+
+	var pages []int
+	if err := json.Unmarshal(data, &pages); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to decode audit index")
+	}
+	return pages, data, nil
+}
+
+// auditHead returns the number of the page records are appended to, and its stored value.
+func (p *Plugin) auditHead() (int, []byte, error) {
+	data, appErr := p.API.KVGet(auditHeadKey)
+	if appErr != nil {
+		return 0, nil, errors.Wrap(appErr, "failed to load audit head")
+	}
+	if data == nil {
+		return 0, nil, nil
+	}
+
+	var head int
+	if err := json.Unmarshal(data, &head); err != nil {
+		return 0, nil, errors.Wrap(err, "failed to decode audit head")
+	}
+	return head, data, nil
+}
+
+// auditPage returns the records of a page, oldest first, and its stored value.
+func (p *Plugin) auditPage(page int) ([]*AuditRecord, []byte, error) {
+	data, appErr := p.API.KVGet(auditPageKey(page))
+	if appErr != nil {
+		return nil, nil, errors.Wrap(appErr, "failed to load audit page")
+	}
+	if data == nil {
+		return nil, nil, nil
+	}
+
+	var records []*AuditRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to decode audit page")
+	}
+	return records, data, nil
+}
+
+// queryAuditLog returns the records matching the query, newest first. Queries for a user or a
+// rule only read the pages listed by its index; other queries read the pages from the head
+// backwards, until a page has expired.
+func (p *Plugin) queryAuditLog(query AuditQuery) ([]*AuditRecord, error) {
+	if query.PerPage <= 0 {
+		query.PerPage = 50
+	}
+	skip := query.Page * query.PerPage
+
+	pages, err := p.auditQueryPages(query)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*AuditRecord{}
+	for _, page := range pages {
+		records, data, err := p.auditPage(page)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil && query.UserID == "" && query.RuleID == "" {
+			// Older pages have expired too.
+			break
+		}
+
+		for i := len(records) - 1; i >= 0; i-- {
+			record := records[i]
+			if query.Since > 0 && record.Timestamp < query.Since {
+				// Older pages only hold older records.
+				return results, nil
+			}
+			if !query.matches(record) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			results = append(results, record)
+			if len(results) == query.PerPage {
+				return results, nil
+			}
+		}
+	}
+	return results, nil
+}
+
+// auditQueryPages returns the pages that can hold records matching the query, newest first.
+func (p *Plugin) auditQueryPages(query AuditQuery) ([]int, error) {
+	var indexKey string
+	switch {
+	case query.UserID != "":
+		indexKey = auditUserIndexKey(query.UserID)
+	case query.RuleID != "":
+		indexKey = auditRuleIndexKey(query.RuleID)
+	default:
+		head, _, err := p.auditHead()
+		if err != nil {
+			return nil, err
+		}
+		pages := make([]int, 0, head+1)
+		for page := head; page >= 0; page-- {
+			pages = append(pages, page)
+		}
+		return pages, nil
+	}
+
+	pages, _, err := p.auditIndex(indexKey)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(pages)))
+	return pages, nil
+}
+
+func (q AuditQuery) matches(record *AuditRecord) bool {
+	if q.UserID != "" && record.UserID != q.UserID {
+		return false
+	}
+	if q.RuleID != "" && !containsString(record.Rules, q.RuleID) {
+		return false
+	}
+	if q.Until > 0 && record.Timestamp > q.Until {
+		return false
+	}
+	return true
+}
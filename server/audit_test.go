@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	newAuditPlugin := func(t *testing.T) (*Plugin, *MembershipMockAPI) {
+		p, api := newTestPlugin(t, &configuration{
+			BadWordsList:   "abc",
+			RejectPosts:    true,
+			WarningMessage: "Not allowed: %s",
+			PostFilters:    "bad_words",
+			EnableAuditLog: true,
+			Moderators:     "mod",
+		})
+		api.GetUserFunc = func(userID string) (*model.User, *model.AppError) {
+			return &model.User{Id: userID, Username: userID}, nil
+		}
+		return p, api
+	}
+
+	t.Run("records filter decisions", func(t *testing.T) {
+		p, _ := newAuditPlugin(t)
+
+		rpost, _ := p.FilterPost(&model.Post{UserId: "author", ChannelId: "channel", Message: "oh abc"})
+		assert.Nil(t, rpost)
+
+		records, err := p.queryAuditLog(AuditQuery{})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, auditActorSystem, records[0].Actor)
+		assert.Equal(t, "author", records[0].UserID)
+		assert.Equal(t, "channel", records[0].ChannelID)
+		assert.Equal(t, badWordsFilterName, records[0].Feature)
+		assert.Equal(t, "reject", records[0].Action)
+		assert.Equal(t, []string{badWordsListRuleID}, records[0].Rules)
+		assert.Equal(t, "oh abc", records[0].Excerpt)
+	})
+
+	t.Run("nothing is recorded when disabled", func(t *testing.T) {
+		p, api := newAuditPlugin(t)
+		p.configuration.EnableAuditLog = false
+
+		p.FilterPost(&model.Post{UserId: "author", Message: "oh abc"})
+		assert.Empty(t, api.kv)
+	})
+
+	t.Run("pages through records newest first", func(t *testing.T) {
+		p, api := newAuditPlugin(t)
+
+		for i := 0; i < 2*auditPageSize+10; i++ {
+			p.audit(&AuditRecord{UserID: fmt.Sprintf("user%d", i), Feature: "test", Action: "reject"})
+		}
+		assert.Contains(t, api.kv, auditPageKey(2))
+
+		records, err := p.queryAuditLog(AuditQuery{PerPage: 20})
+		require.NoError(t, err)
+		require.Len(t, records, 20)
+		assert.Equal(t, "user209", records[0].UserID)
+		assert.Equal(t, "user190", records[19].UserID)
+
+		records, err = p.queryAuditLog(AuditQuery{Page: 10, PerPage: 20})
+		require.NoError(t, err)
+		require.Len(t, records, 10)
+		assert.Equal(t, "user9", records[0].UserID)
+		assert.Equal(t, "user0", records[9].UserID)
+	})
+
+	t.Run("filters by user, rule and time", func(t *testing.T) {
+		p, _ := newAuditPlugin(t)
+
+		for i, record := range []*AuditRecord{
+			{UserID: "alice", Rules: []string{"spam"}},
+			{UserID: "bob", Rules: []string{"slurs", "spam"}},
+			{UserID: "alice", Rules: []string{"slurs"}},
+			{UserID: "bob"},
+		} {
+			record.Timestamp = int64(1000 * (i + 1))
+			require.NoError(t, p.appendAuditRecords([]*AuditRecord{record}, nil))
+		}
+
+		userIDs := func(query AuditQuery) []string {
+			records, err := p.queryAuditLog(query)
+			require.NoError(t, err)
+			var ids []string
+			for _, record := range records {
+				ids = append(ids, fmt.Sprintf("%s@%d", record.UserID, record.Timestamp))
+			}
+			return ids
+		}
+
+		assert.Equal(t, []string{"alice@3000", "alice@1000"}, userIDs(AuditQuery{UserID: "alice"}))
+		assert.Equal(t, []string{"bob@2000", "alice@1000"}, userIDs(AuditQuery{RuleID: "spam"}))
+		assert.Equal(t, []string{"alice@3000", "bob@2000"}, userIDs(AuditQuery{Since: 2000, Until: 3000}))
+	})
+
+	t.Run("indexes the pages of users and rules", func(t *testing.T) {
+		p, api := newAuditPlugin(t)
+
+		var batch []*AuditRecord
+		for i := 0; i < auditPageSize+10; i++ {
+			batch = append(batch, &AuditRecord{UserID: "bob", Feature: "test", Action: "reject"})
+		}
+		batch[0].UserID = "alice"
+		batch[0].Rules = []string{"spam"}
+		batch = append(batch, &AuditRecord{UserID: "alice", Feature: "test", Action: "reject"})
+		require.NoError(t, p.appendAuditRecords(batch, map[string]int{}))
+
+		assert.Equal(t, `[0,1]`, string(api.kv[auditUserIndexKey("alice")]))
+		assert.Equal(t, `[0,1]`, string(api.kv[auditUserIndexKey("bob")]))
+		assert.Equal(t, `[0]`, string(api.kv[auditRuleIndexKey("spam")]))
+
+		// Pages outside the index are not read.
+		delete(api.kv, auditPageKey(1))
+		records, err := p.queryAuditLog(AuditQuery{RuleID: "spam"})
+		require.NoError(t, err)
+		assert.Len(t, records, 1)
+	})
+
+	t.Run("records expire after the retention period", func(t *testing.T) {
+		p, api := newAuditPlugin(t)
+		p.configuration.AuditRetentionDays = "30"
+		require.NoError(t, parseAuditRetention(p.configuration))
+
+		p.audit(&AuditRecord{UserID: "alice", Feature: "test", Action: "reject"})
+		assert.Equal(t, int64(30*24*60*60), api.expiries[auditPageKey(0)])
+		assert.Equal(t, int64(30*24*60*60), api.expiries[auditUserIndexKey("alice")])
+
+		// Once the oldest pages expired, only the newer ones are read.
+		api.kv[auditHeadKey] = []byte("2")
+		api.kv[auditPageKey(2)] = api.kv[auditPageKey(0)]
+		delete(api.kv, auditPageKey(0))
+		records, err := p.queryAuditLog(AuditQuery{})
+		require.NoError(t, err)
+		assert.Len(t, records, 1)
+
+		for _, setting := range []string{"a month", "-1"} {
+			assert.Error(t, parseAuditRetention(&configuration{AuditRetentionDays: setting}), setting)
+		}
+	})
+
+	t.Run("records are written in the background", func(t *testing.T) {
+		p, api := newAuditPlugin(t)
+		p.startAuditWriter()
+
+		for i := 0; i < 10; i++ {
+			p.FilterPost(&model.Post{UserId: "author", Message: "oh abc"})
+		}
+		p.stopAuditWriter()
+
+		var records []*AuditRecord
+		require.NoError(t, json.Unmarshal(api.kv[auditPageKey(0)], &records))
+		assert.Len(t, records, 10)
+	})
+
+	t.Run("records are written at once after the writer stops", func(t *testing.T) {
+		p, api := newAuditPlugin(t)
+		p.startAuditWriter()
+		p.FilterPost(&model.Post{UserId: "author", Message: "oh abc"})
+		p.stopAuditWriter()
+
+		p.FilterPost(&model.Post{UserId: "author", Message: "oh abc"})
+
+		var records []*AuditRecord
+		require.NoError(t, json.Unmarshal(api.kv[auditPageKey(0)], &records))
+		assert.Len(t, records, 2)
+	})
+
+	t.Run("the audit endpoint is for moderators", func(t *testing.T) {
+		p, _ := newAuditPlugin(t)
+		p.FilterPost(&model.Post{UserId: "author", Message: "oh abc"})
+
+		get := func(userID, query string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/audit"+query, nil)
+			r.Header.Set("Mattermost-User-Id", userID)
+			w := httptest.NewRecorder()
+			p.ServeHTTP(&plugin.Context{}, w, r)
+			return w
+		}
+
+		assert.Equal(t, http.StatusForbidden, get("author", "").Code)
+		assert.Equal(t, http.StatusBadRequest, get("mod", "?since=yesterday").Code)
+
+		w := get("mod", "?user_id=author")
+		require.Equal(t, http.StatusOK, w.Code)
+		var records []*AuditRecord
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&records))
+		assert.Len(t, records, 1)
+	})
+}
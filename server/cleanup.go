@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// cleanupJobKeyPrefix prefixes the KV store keys of cleanups with steps left to retry.
+	cleanupJobKeyPrefix = "cleanup_job_"
+
+	// cleanupJobsKey lists the users with incomplete cleanups, so that the retries find them
+	// without going through every key of the plugin.
+	cleanupJobsKey = "cleanup_jobs"
+
+	// cleanupRetryLockKey keeps the servers of a cluster from retrying cleanups at the same
+	// time. It expires with the retry interval.
+	cleanupRetryLockKey = "cleanup_retry_lock"
+
+	// cleanupRetryInterval is how often failed cleanup steps are retried.
+	cleanupRetryInterval = 5 * time.Minute
+
+	// cleanupMaxAttempts is how many times a step is tried before it is reported to the
+	// moderators as failed for good.
+	cleanupMaxAttempts = 5
+)
+
+// The steps of the cleanup of a user, in the order they run. Every step runs even if an
+// earlier one failed, so that a user whose rename fails is still deactivated.
+const (
+	cleanupStepSanitize        = "sanitize"
+	cleanupStepRemoveFromTeams = "remove_from_teams"
+	cleanupStepDeactivate      = "deactivate"
+)
+
+var cleanupSteps = []string{cleanupStepSanitize, cleanupStepRemoveFromTeams, cleanupStepDeactivate}
+
+// The statuses of a cleanup step.
+const (
+	cleanupStepPending = "pending"
+	cleanupStepDone    = "done"
+	cleanupStepFailed  = "failed"
+)
+
+// CleanupStep is a single step of the cleanup of a user.
+type CleanupStep struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// CleanupJob tracks the cleanup of a user. It is only stored while some of its steps failed:
+// pending steps are retried in the background, failed steps wait for a moderator.
+type CleanupJob struct {
+	UserID    string         `json:"user_id"`
+	Steps     []*CleanupStep `json:"steps"`
+	CreatedAt int64          `json:"created_at"`
+	UpdatedAt int64          `json:"updated_at"`
+}
+
+func cleanupJobKey(userID string) string {
+	return cleanupJobKeyPrefix + userID
+}
+
+func newCleanupJob(userID string) *CleanupJob {
+	job := &CleanupJob{UserID: userID, CreatedAt: model.GetMillis()}
+	for _, name := range cleanupSteps {
+		job.Steps = append(job.Steps, &CleanupStep{Name: name, Status: cleanupStepPending})
+	}
+	return job
+}
+
+// stepsWithStatus returns the steps of the job that have the status.
+func (j *CleanupJob) stepsWithStatus(status string) []*CleanupStep {
+	var steps []*CleanupStep
+	for _, step := range j.Steps {
+		if step.Status == status {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+// cleanupUser sanitizes the user, removes them from their teams and deactivates them. The user
+// is snapshotted first, so that they can be restored after a false positive. Failed steps are
+// retried in the background; the returned error lists them.
+func (p *Plugin) cleanupUser(user *model.User) error {
+	// Record the user as they are, so that they can be restored after a false positive
+	if _, err := p.snapshotUser(user); err != nil {
+		p.API.LogError("Failed to snapshot user before sanitizing",
+			"user_id", user.Id,
+			"username", user.Username,
+			"nickname", user.Nickname,
+			"email", user.Email,
+			"err", err.Error(),
+		)
+	}
+
+	// Keep the user from signing up again with a variant of their address.
+	if p.getConfiguration().BanSanitizedEmails && user.Email != "" {
+		if _, err := p.banEmail(user.Email, "user was sanitized and deactivated", p.botUserID); err != nil {
+			p.API.LogError("Failed to ban email of sanitized user", "user_id", user.Id, "err", err.Error())
+		}
+	}
+
+	return p.runCleanupJob(newCleanupJob(user.Id), user, false)
+}
+
+// runCleanupJob runs the pending steps of a cleanup. The job is stored while it has steps
+// left, and removed once it completes; stored tells whether it was stored before. Steps that
+// run out of attempts are reported to the moderators.
+func (p *Plugin) runCleanupJob(job *CleanupJob, user *model.User, stored bool) error {
+	var failed []string
+	var exhausted []*CleanupStep
+	for _, step := range job.stepsWithStatus(cleanupStepPending) {
+		step.Attempts++
+		if err := p.runCleanupStep(step.Name, user); err != nil {
+			step.LastError = err.Error()
+			failed = append(failed, fmt.Sprintf("%s: %s", step.Name, err.Error()))
+			if step.Attempts >= cleanupMaxAttempts {
+				step.Status = cleanupStepFailed
+				exhausted = append(exhausted, step)
+			}
+			continue
+		}
+		step.Status = cleanupStepDone
+		step.LastError = ""
+	}
+	job.UpdatedAt = model.GetMillis()
+
+	if len(job.stepsWithStatus(cleanupStepDone)) == len(job.Steps) {
+		if stored {
+			if err := p.deleteCleanupJob(job.UserID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := p.storeCleanupJob(job); err != nil {
+		p.API.LogError("Failed to store cleanup for retry", "user_id", job.UserID, "err", err.Error())
+	}
+	if len(exhausted) > 0 {
+		p.reportCleanupFailure(job, exhausted)
+	}
+	return fmt.Errorf("cleanup of user %s is incomplete: %s", job.UserID, strings.Join(failed, "; "))
+}
+
+// runCleanupStep runs a single step of the cleanup of the user.
+func (p *Plugin) runCleanupStep(name string, user *model.User) error {
+	switch name {
+	case cleanupStepSanitize:
+		user.Nickname = fmt.Sprintf("sanitized-%s", user.Id)
+		user.Username = fmt.Sprintf("sanitized-%s", user.Id)
+		if _, appErr := p.API.UpdateUser(user); appErr != nil {
+			return errors.Wrap(appErr, "failed to sanitize user")
+		}
+	case cleanupStepRemoveFromTeams:
+		return p.RemoveUserFromTeams(user)
+	case cleanupStepDeactivate:
+		// Perform a soft delete so the account _can_ be restored.
+		if appErr := p.API.DeleteUser(user.Id); appErr != nil {
+			return errors.Wrap(appErr, "failed to deactivate user")
+		}
+	default:
+		return fmt.Errorf("unknown cleanup step: %s", name)
+	}
+	return nil
+}
+
+func (p *Plugin) storeCleanupJob(job *CleanupJob) error {
+	// List the job first, so that a stored job is never missed by the retries.
+	if err := updateKVStrings(p.API, cleanupJobsKey, "cleanup list", job.UserID, true); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode cleanup")
+	}
+	if appErr := p.API.KVSet(cleanupJobKey(job.UserID), data); appErr != nil {
+		return errors.Wrap(appErr, "failed to store cleanup")
+	}
+	return nil
+}
+
+// cancelCleanup forgets the cleanup of a user, so that a restored user is not sanitized again
+// by a retry.
+func (p *Plugin) cancelCleanup(userID string) error {
+	return p.deleteCleanupJob(userID)
+}
+
+// deleteCleanupJob removes the cleanup of a user and its entry in the list of cleanups.
+func (p *Plugin) deleteCleanupJob(userID string) error {
+	if appErr := p.API.KVDelete(cleanupJobKey(userID)); appErr != nil {
+		return errors.Wrap(appErr, "failed to remove cleanup")
+	}
+	return updateKVStrings(p.API, cleanupJobsKey, "cleanup list", userID, false)
+}
+
+// getCleanupJob returns the incomplete cleanup of a user, or nil if there is none.
+func (p *Plugin) getCleanupJob(userID string) (*CleanupJob, error) {
+	data, appErr := p.API.KVGet(cleanupJobKey(userID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to load cleanup")
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var job CleanupJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, errors.Wrap(err, "failed to decode cleanup")
+	}
+	return &job, nil
+}
+
+// listCleanupJobs returns the incomplete cleanups, oldest first.
+func (p *Plugin) listCleanupJobs() ([]*CleanupJob, error) {
+	userIDs, _, err := loadKVStrings(p.API, cleanupJobsKey, "cleanup list")
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*CleanupJob, 0, len(userIDs))
+	for _, userID := range userIDs {
+		job, err := p.getCleanupJob(userID)
+		if err != nil {
+			return nil, err
+		}
+		if job != nil {
+			jobs = append(jobs, job)
+		}
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt < jobs[j].CreatedAt
+	})
+	return jobs, nil
+}
+
+// retryCleanupJobs retries the pending steps of every incomplete cleanup.
+func (p *Plugin) retryCleanupJobs() error {
+	jobs, err := p.listCleanupJobs()
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if len(job.stepsWithStatus(cleanupStepPending)) == 0 {
+			continue
+		}
+		user, appErr := p.API.GetUser(job.UserID)
+		if appErr != nil {
+			p.API.LogWarn("Failed to get user to retry cleanup", "user_id", job.UserID, "err", appErr.Error())
+			continue
+		}
+		if err := p.runCleanupJob(job, user, true); err != nil {
+			p.API.LogWarn("Retried cleanup is still incomplete", "user_id", job.UserID, "err", err.Error())
+		}
+	}
+	return nil
+}
+
+// startCleanupRetries retries failed cleanup steps every cleanupRetryInterval, on one server
+// of the cluster at a time, until stopCleanupRetries is called.
+func (p *Plugin) startCleanupRetries() {
+	stop := make(chan struct{})
+	p.stopCleanupRetries = func() { close(stop) }
+
+	go func() {
+		ticker := time.NewTicker(cleanupRetryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				locked, appErr := p.API.KVSetWithOptions(cleanupRetryLockKey, []byte("locked"), model.PluginKVSetOptions{
+					Atomic:          true,
+					OldValue:        nil,
+					ExpireInSeconds: int64(cleanupRetryInterval.Seconds()) - 1,
+				})
+				if appErr != nil || !locked {
+					continue
+				}
+				if err := p.retryCleanupJobs(); err != nil {
+					p.API.LogError("Failed to retry cleanups", "err", err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// reportCleanupFailure tells the moderators about cleanup steps that failed for good, so that
+// they can finish the cleanup by hand.
+func (p *Plugin) reportCleanupFailure(job *CleanupJob, steps []*CleanupStep) {
+	lines := make([]string, 0, len(steps))
+	for _, step := range steps {
+		lines = append(lines, fmt.Sprintf("%s: %s", step.Name, step.LastError))
+	}
+
+	p.API.LogError("Cleanup of user failed", "user_id", job.UserID, "steps", strings.Join(lines, "; "))
+	p.audit(&AuditRecord{
+		UserID:  job.UserID,
+		Feature: shadowNewUsers,
+		Action:  "cleanup_failed",
+		Excerpt: excerpt(strings.Join(lines, "; ")),
+	})
+	p.notifyModerators(&moderationNotification{
+		event:  eventCleanupFailed,
+		userID: job.UserID,
+		fields: []*model.SlackAttachmentField{
+			{Title: "Failed steps", Value: strings.Join(lines, "\n")},
+		},
+	})
+}
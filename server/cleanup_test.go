@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanupUser(t *testing.T) {
+	newCleanupPlugin := func(t *testing.T) (*Plugin, *MembershipMockAPI, *model.User) {
+		p, api := newTestPlugin(t, &configuration{
+			EnableAuditLog:          true,
+			ModerationChannelID:     "moderation",
+			ModerationNotifications: eventCleanupFailed,
+		})
+		user := &model.User{Id: model.NewId(), Username: "spammer", Roles: model.SystemUserRoleId}
+		api.users[user.Id] = user
+		api.teamRoles["team"] = model.TeamUserRoleId
+		return p, api, user
+	}
+
+	t.Run("completed cleanups are not stored", func(t *testing.T) {
+		p, api, user := newCleanupPlugin(t)
+
+		require.NoError(t, p.cleanupUser(&model.User{Id: user.Id, Username: user.Username}))
+
+		assert.NotZero(t, api.users[user.Id].DeleteAt)
+		assert.Equal(t, "sanitized-"+user.Id, api.users[user.Id].Username)
+		assert.NotContains(t, api.kv, cleanupJobKey(user.Id))
+	})
+
+	t.Run("failed steps do not keep the user active and are retried", func(t *testing.T) {
+		p, api, user := newCleanupPlugin(t)
+		api.failUserUpdates = 1
+
+		err := p.cleanupUser(&model.User{Id: user.Id, Username: user.Username})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), cleanupStepSanitize)
+		assert.NotZero(t, api.users[user.Id].DeleteAt, "the user is deactivated anyway")
+		assert.Empty(t, api.teamRoles)
+		assert.Equal(t, "spammer", api.users[user.Id].Username)
+
+		job, err := p.getCleanupJob(user.Id)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		assert.Equal(t, []*CleanupStep{
+			{Name: cleanupStepSanitize, Status: cleanupStepPending, Attempts: 1, LastError: job.Steps[0].LastError},
+			{Name: cleanupStepRemoveFromTeams, Status: cleanupStepDone, Attempts: 1},
+			{Name: cleanupStepDeactivate, Status: cleanupStepDone, Attempts: 1},
+		}, job.Steps)
+		assert.JSONEq(t, `["`+user.Id+`"]`, string(api.kv[cleanupJobsKey]), "the retries find the job in the list")
+
+		require.NoError(t, p.retryCleanupJobs())
+		assert.Equal(t, "sanitized-"+user.Id, api.users[user.Id].Username)
+		assert.NotContains(t, api.kv, cleanupJobKey(user.Id))
+		assert.JSONEq(t, `[]`, string(api.kv[cleanupJobsKey]))
+	})
+
+	t.Run("steps that keep failing are reported to the moderators", func(t *testing.T) {
+		p, api, user := newCleanupPlugin(t)
+		api.failUserUpdates = cleanupMaxAttempts
+
+		require.Error(t, p.cleanupUser(&model.User{Id: user.Id, Username: user.Username}))
+		for attempt := 1; attempt < cleanupMaxAttempts; attempt++ {
+			require.NoError(t, p.retryCleanupJobs())
+		}
+
+		job, err := p.getCleanupJob(user.Id)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		assert.Equal(t, cleanupStepFailed, job.Steps[0].Status)
+		assert.Equal(t, cleanupMaxAttempts, job.Steps[0].Attempts)
+
+		require.Len(t, api.posts, 1)
+		for _, post := range api.posts {
+			assert.Equal(t, "User cleanup failed", post.Attachments()[0].Title)
+		}
+
+		// Failed steps are left to the moderators.
+		require.NoError(t, p.retryCleanupJobs())
+		job, err = p.getCleanupJob(user.Id)
+		require.NoError(t, err)
+		assert.Equal(t, cleanupMaxAttempts, job.Steps[0].Attempts)
+
+		jobs, err := p.listCleanupJobs()
+		require.NoError(t, err)
+		assert.Len(t, jobs, 1)
+	})
+
+	t.Run("restoring a user cancels their cleanup", func(t *testing.T) {
+		p, api, user := newCleanupPlugin(t)
+		api.failUserUpdates = 1
+
+		require.Error(t, p.cleanupUser(&model.User{Id: user.Id, Username: user.Username, Roles: user.Roles}))
+		_, err := p.restoreUser("mod", user.Id)
+		require.NoError(t, err)
+		assert.NotContains(t, api.kv, cleanupJobKey(user.Id))
+		assert.JSONEq(t, `[]`, string(api.kv[cleanupJobsKey]))
+
+		require.NoError(t, p.retryCleanupJobs())
+		assert.Equal(t, "spammer", api.users[user.Id].Username)
+		assert.Zero(t, api.users[user.Id].DeleteAt)
+	})
+}
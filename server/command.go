@@ -0,0 +1,422 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	commandTrigger = "toolkit"
+
+	// commandHistoryLength is the number of audit records /toolkit history shows.
+	commandHistoryLength = 20
+)
+
+const commandHelp = `###### Community Toolkit
+* |/toolkit test <text>| - Show which rules match the text and how it would be censored
+* |/toolkit check-user @user| - Run the new user checks against an existing user
+* |/toolkit history @user| - Show the latest moderation decisions about a user
+* |/toolkit restore @user| - Reactivate a user deactivated by the plugin and restore their original username and memberships
+* |/toolkit release @user| - Let a quarantined user post again
+* |/toolkit lists reload| - Reload the word, username and domain lists and rules
+* |/toolkit domains list| - List the uploaded domain lists
+* |/toolkit domains check <domain>| - Show which domain lists block a domain or email address
+* |/toolkit domains add <list> <domain>...| - Add domains to a domain list, creating it if needed
+* |/toolkit domains remove <list> <domain>...| - Remove domains from a domain list
+* |/toolkit domains delete <list>| - Delete a domain list
+* |/toolkit emails check <email>| - Show the normalized form of an email address and the email checks it fails
+* |/toolkit emails ban <email> [reason]| - Keep new users from signing up with any variant of an email address
+* |/toolkit emails unban <email>| - Lift the ban of an email address`
+
+// registerCommand registers /toolkit with its autocomplete.
+func (p *Plugin) registerCommand() error {
+	autocomplete := model.NewAutocompleteData(commandTrigger, "[command]", "Community Toolkit moderation commands")
+
+	test := model.NewAutocompleteData("test", "<text>", "Show which rules match the text and how it would be censored")
+	test.AddTextArgument("Text to run through the word filter", "<text>", "")
+	autocomplete.AddCommand(test)
+
+	for _, subcommand := range []struct{ trigger, help string }{
+		{"check-user", "Run the new user checks against an existing user"},
+		{"history", "Show the latest moderation decisions about a user"},
+		{"restore", "Reactivate a user deactivated by the plugin and restore their original username and memberships"},
+		{"release", "Let a quarantined user post again"},
+	} {
+		data := model.NewAutocompleteData(subcommand.trigger, "@user", subcommand.help)
+		data.AddTextArgument("User", "@user", "")
+		autocomplete.AddCommand(data)
+	}
+
+	lists := model.NewAutocompleteData("lists", "reload", "Manage the word, username and domain lists")
+	lists.AddCommand(model.NewAutocompleteData("reload", "", "Reload the lists and rules"))
+	autocomplete.AddCommand(lists)
+
+	domains := model.NewAutocompleteData("domains", "[list|check|add|remove|delete]", "Manage the uploaded domain lists")
+	domains.AddCommand(model.NewAutocompleteData("list", "", "List the uploaded domain lists"))
+	domains.AddCommand(model.NewAutocompleteData("check", "<domain>", "Show which domain lists block a domain or email address"))
+	domains.AddCommand(model.NewAutocompleteData("add", "<list> <domain>...", "Add domains to a domain list, creating it if needed"))
+	domains.AddCommand(model.NewAutocompleteData("remove", "<list> <domain>...", "Remove domains from a domain list"))
+	domains.AddCommand(model.NewAutocompleteData("delete", "<list>", "Delete a domain list"))
+	autocomplete.AddCommand(domains)
+
+	emails := model.NewAutocompleteData("emails", "[check|ban|unban]", "Check and ban email addresses")
+	emails.AddCommand(model.NewAutocompleteData("check", "<email>", "Show the normalized form of an email address and the email checks it fails"))
+	emails.AddCommand(model.NewAutocompleteData("ban", "<email> [reason]", "Keep new users from signing up with any variant of an email address"))
+	emails.AddCommand(model.NewAutocompleteData("unban", "<email>", "Lift the ban of an email address"))
+	autocomplete.AddCommand(emails)
+
+	if err := p.API.RegisterCommand(&model.Command{
+		Trigger:          commandTrigger,
+		DisplayName:      "Community Toolkit",
+		Description:      "Community Toolkit moderation commands",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: test, check-user, history, restore, release, lists, domains, emails",
+		AutoCompleteHint: "[command]",
+		AutocompleteData: autocomplete,
+	}); err != nil {
+		return errors.Wrap(err, "failed to register command")
+	}
+	return nil
+}
+
+// Plugin Callback: ExecuteCommand
+// Handles /toolkit. Every response is ephemeral, so only the invoking moderator sees it.
+func (p *Plugin) ExecuteCommand(_ *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	fields := strings.Fields(args.Command)
+	if len(fields) == 0 || fields[0] != "/"+commandTrigger {
+		return commandResponse(fmt.Sprintf("Unknown command: %s", args.Command)), nil
+	}
+	if !p.isModerator(args.UserId) {
+		return commandResponse("Only moderators can use /" + commandTrigger + "."), nil
+	}
+	if len(fields) < 2 {
+		return commandResponse(strings.ReplaceAll(commandHelp, "|", "`")), nil
+	}
+
+	subcommand, parameters := fields[1], fields[2:]
+	switch subcommand {
+	case "test":
+		// Keep the text as typed, including its spacing.
+		_, text, _ := strings.Cut(args.Command, subcommand)
+		return commandResponse(p.executeTestCommand(args, strings.TrimSpace(text))), nil
+	case "check-user":
+		return commandResponse(p.executeUserCommand(parameters, p.executeCheckUserCommand)), nil
+	case "history":
+		return commandResponse(p.executeUserCommand(parameters, p.executeHistoryCommand)), nil
+	case "restore":
+		return commandResponse(p.executeUserCommand(parameters, func(user *model.User) string {
+			return p.executeRestoreCommand(args.UserId, user)
+		})), nil
+	case "release":
+		return commandResponse(p.executeUserCommand(parameters, func(user *model.User) string {
+			return p.executeReleaseCommand(args.UserId, user)
+		})), nil
+	case "lists":
+		if len(parameters) != 1 || parameters[0] != "reload" {
+			return commandResponse("Usage: `/toolkit lists reload`"), nil
+		}
+		return commandResponse(p.executeReloadListsCommand()), nil
+	case "domains":
+		return commandResponse(p.executeDomainsCommand(args.UserId, parameters)), nil
+	case "emails":
+		return commandResponse(p.executeEmailsCommand(args.UserId, parameters)), nil
+	default:
+		return commandResponse(strings.ReplaceAll(commandHelp, "|", "`")), nil
+	}
+}
+
+func commandResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
+
+func (p *Plugin) executeTestCommand(args *model.CommandArgs, text string) string {
+	if text == "" {
+		return "Usage: `/toolkit test <text>`"
+	}
+
+	result := p.testText(args.UserId, args.ChannelId, text)
+	if len(result.Rules) == 0 {
+		return "No rule matches the text."
+	}
+
+	lines := []string{
+		fmt.Sprintf("**Action:** %s", result.Action),
+		fmt.Sprintf("**Matches:** %s", strings.Join(result.Matches, ", ")),
+		"**Rules:**",
+	}
+	for _, rule := range result.Rules {
+		line := fmt.Sprintf("* `%s` (%s): `%s`", rule.ID, rule.Action, rule.Pattern)
+		if rule.Category != "" {
+			line += ", category " + rule.Category
+		}
+		lines = append(lines, line)
+	}
+	if result.Action == FilterActionCensor.String() {
+		lines = append(lines, "**Censored:**", "```", result.Message, "```")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// executeUserCommand looks up the user a subcommand is about, given as @username, username or
+// user id.
+func (p *Plugin) executeUserCommand(parameters []string, execute func(*model.User) string) string {
+	if len(parameters) != 1 {
+		return "Please specify a single user, e.g. `@username`."
+	}
+
+	name := strings.TrimPrefix(parameters[0], "@")
+	user, appErr := p.API.GetUserByUsername(name)
+	if appErr != nil && model.IsValidId(name) {
+		user, appErr = p.API.GetUser(name)
+	}
+	if appErr != nil {
+		return fmt.Sprintf("User %s not found.", parameters[0])
+	}
+	return execute(user)
+}
+
+func (p *Plugin) executeCheckUserCommand(user *model.User) string {
+	result := p.testUser(user)
+	if !result.RequiresModeration {
+		return fmt.Sprintf("@%s passes every new user check.", user.Username)
+	}
+
+	lines := []string{fmt.Sprintf("@%s fails the new user checks:", user.Username)}
+	for _, reason := range result.Reasons {
+		lines = append(lines, "* "+reason)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p *Plugin) executeHistoryCommand(user *model.User) string {
+	records, err := p.queryAuditLog(AuditQuery{UserID: user.Id, PerPage: commandHistoryLength})
+	if err != nil {
+		p.API.LogError("Failed to query audit log", "user_id", user.Id, "err", err.Error())
+		return "Failed to read the audit log. Check the server logs."
+	}
+	if len(records) == 0 {
+		return fmt.Sprintf("No moderation decisions about @%s were recorded.", user.Username)
+	}
+
+	lines := []string{
+		fmt.Sprintf("Latest moderation decisions about @%s:", user.Username),
+		"",
+		"| Time | Feature | Action | Rules | Actor | Excerpt |",
+		"|------|---------|--------|-------|-------|---------|",
+	}
+	for _, record := range records {
+		action := record.Action
+		if record.Shadow {
+			action += " (shadow)"
+		}
+		actor := record.Actor
+		if actor != auditActorSystem {
+			actor = p.userMention(actor)
+		}
+		lines = append(lines, fmt.Sprintf("| %s | %s | %s | %s | %s | %s |",
+			time.UnixMilli(record.Timestamp).UTC().Format(time.RFC3339),
+			record.Feature,
+			action,
+			strings.Join(record.Rules, ", "),
+			actor,
+			strings.NewReplacer("|", `\|`, "\n", " ").Replace(record.Excerpt),
+		))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p *Plugin) executeRestoreCommand(actor string, user *model.User) string {
+	result, err := p.restoreUser(actor, user.Id)
+	if errors.Is(err, errUserNotRestorable) {
+		return fmt.Sprintf("@%s is not deactivated.", user.Username)
+	}
+	if err != nil {
+		p.API.LogError("Failed to restore user", "user_id", user.Id, "err", err.Error())
+		return fmt.Sprintf("Failed to restore @%s. Check the server logs.", user.Username)
+	}
+
+	if !result.HadSnapshot {
+		return fmt.Sprintf("@%s has been reactivated. There is no snapshot to restore their username and memberships from.", result.Username)
+	}
+	if len(result.Failures) == 0 {
+		return fmt.Sprintf("@%s has been restored.", result.Username)
+	}
+	lines := []string{fmt.Sprintf("@%s has been partially restored. Run the command again to retry, or fix these manually:", result.Username)}
+	for _, failure := range result.Failures {
+		lines = append(lines, "* "+failure)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p *Plugin) executeReleaseCommand(actor string, user *model.User) string {
+	released, err := p.releaseUser(user.Id, actor)
+	if err != nil {
+		p.API.LogError("Failed to release quarantined user", "user_id", user.Id, "err", err.Error())
+		return fmt.Sprintf("Failed to release @%s. Check the server logs.", user.Username)
+	}
+	if !released {
+		return fmt.Sprintf("@%s is not quarantined.", user.Username)
+	}
+	return fmt.Sprintf("@%s has been released from quarantine.", user.Username)
+}
+
+func (p *Plugin) executeReloadListsCommand() string {
+	if err := p.OnConfigurationChange(); err != nil {
+		p.API.LogError("Failed to reload configuration", "err", err.Error())
+		return fmt.Sprintf("Failed to reload the lists: %s", err.Error())
+	}
+	if err := p.loadStoredRules(); err != nil {
+		p.API.LogError("Failed to reload rules", "err", err.Error())
+		return fmt.Sprintf("Failed to reload the rules: %s", err.Error())
+	}
+	if err := p.loadDomainLists(); err != nil {
+		p.API.LogError("Failed to reload domain lists", "err", err.Error())
+		return fmt.Sprintf("Failed to reload the domain lists: %s", err.Error())
+	}
+	return "The lists and rules have been reloaded."
+}
+
+const domainsCommandUsage = "Usage: `/toolkit domains list|check <domain>|add <list> <domain>...|remove <list> <domain>...|delete <list>`"
+
+func (p *Plugin) executeDomainsCommand(actor string, parameters []string) string {
+	if len(parameters) == 0 {
+		return domainsCommandUsage
+	}
+
+	action, parameters := parameters[0], parameters[1:]
+	switch {
+	case action == "list" && len(parameters) == 0:
+		lists, err := p.listDomainLists()
+		if err != nil {
+			p.API.LogError("Failed to list domain lists", "err", err.Error())
+			return "Failed to list the domain lists. Check the server logs."
+		}
+		if len(lists) == 0 {
+			return "No domain list has been uploaded."
+		}
+		lines := []string{"**Domain lists:**"}
+		for _, list := range lists {
+			line := fmt.Sprintf("* `%s`: %d entries, updated %s", list.Name, len(list.Entries),
+				time.UnixMilli(list.UpdatedAt).UTC().Format(time.RFC3339))
+			if list.Description != "" {
+				line += " - " + list.Description
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "\n")
+
+	case action == "check" && len(parameters) == 1:
+		matches := p.explainDomain(parameters[0])
+		if len(matches) == 0 {
+			return fmt.Sprintf("`%s` is not in any domain list.", parameters[0])
+		}
+		lines := []string{fmt.Sprintf("`%s` is blocked by:", parameters[0])}
+		for _, match := range matches {
+			lines = append(lines, fmt.Sprintf("* `%s` in %s", match.Entry, match.Source))
+		}
+		return strings.Join(lines, "\n")
+
+	case (action == "add" || action == "remove") && len(parameters) >= 2:
+		name, entries := parameters[0], parameters[1:]
+		if err := validateDomainListName(name); err != nil {
+			return err.Error()
+		}
+		entries, err := parseDomainList(domainListFormatText, strings.NewReader(strings.Join(entries, "\n")))
+		if err != nil {
+			return err.Error()
+		}
+
+		var list *DomainList
+		if action == "add" {
+			list, err = p.addToDomainList(name, entries, actor)
+		} else {
+			list, err = p.removeFromDomainList(name, entries, actor)
+		}
+		if err != nil {
+			p.API.LogError("Failed to update domain list", "name", name, "err", err.Error())
+			return fmt.Sprintf("Failed to update the domain list `%s`. Check the server logs.", name)
+		}
+		if list == nil {
+			return fmt.Sprintf("There is no domain list `%s`.", name)
+		}
+		return fmt.Sprintf("The domain list `%s` now has %d entries.", name, len(list.Entries))
+
+	case action == "delete" && len(parameters) == 1:
+		name := parameters[0]
+		if err := validateDomainListName(name); err != nil {
+			return err.Error()
+		}
+		deleted, err := p.deleteDomainList(name, actor)
+		if err != nil {
+			p.API.LogError("Failed to delete domain list", "name", name, "err", err.Error())
+			return fmt.Sprintf("Failed to delete the domain list `%s`. Check the server logs.", name)
+		}
+		if !deleted {
+			return fmt.Sprintf("There is no domain list `%s`.", name)
+		}
+		return fmt.Sprintf("The domain list `%s` has been deleted.", name)
+
+	default:
+		return domainsCommandUsage
+	}
+}
+
+const emailsCommandUsage = "Usage: `/toolkit emails check <email>|ban <email> [reason]|unban <email>`"
+
+func (p *Plugin) executeEmailsCommand(actor string, parameters []string) string {
+	if len(parameters) < 2 || !strings.Contains(parameters[1], "@") {
+		return emailsCommandUsage
+	}
+
+	action, email := parameters[0], parameters[1]
+	switch {
+	case action == "check" && len(parameters) == 2:
+		result, err := p.checkEmail(email)
+		if err != nil {
+			p.API.LogError("Failed to check email", "err", err.Error())
+			return "Failed to check the email address. Check the server logs."
+		}
+		lines := []string{
+			fmt.Sprintf("**Normalized:** `%s`", result.Normalized),
+			fmt.Sprintf("**Digits:** %.0f%%, **entropy:** %.2f bits per character", result.DigitRatio*100, result.Entropy),
+		}
+		if len(result.Reasons) == 0 {
+			lines = append(lines, "The address passes the email checks.")
+		}
+		for _, reason := range result.Reasons {
+			lines = append(lines, "* "+reason)
+		}
+		return strings.Join(lines, "\n")
+
+	case action == "ban":
+		reason := strings.Join(parameters[2:], " ")
+		banned, err := p.banEmail(email, reason, actor)
+		if err != nil {
+			p.API.LogError("Failed to ban email", "err", err.Error())
+			return "Failed to ban the email address. Check the server logs."
+		}
+		return fmt.Sprintf("`%s` and its variants have been banned.", banned.Email)
+
+	case action == "unban" && len(parameters) == 2:
+		unbanned, err := p.unbanEmail(email, actor)
+		if err != nil {
+			p.API.LogError("Failed to unban email", "err", err.Error())
+			return "Failed to unban the email address. Check the server logs."
+		}
+		if !unbanned {
+			return fmt.Sprintf("`%s` is not banned.", normalizeEmail(email))
+		}
+		return fmt.Sprintf("`%s` has been unbanned.", normalizeEmail(email))
+
+	default:
+		return emailsCommandUsage
+	}
+}
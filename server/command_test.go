@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToolkitCommand(t *testing.T) {
+	moderator := &model.User{Id: model.NewId(), Username: "mod"}
+	spammer := &model.User{Id: model.NewId(), Username: "spammer-admin", DeleteAt: 1}
+
+	newCommandPlugin := func(t *testing.T) (*Plugin, *MembershipMockAPI) {
+		p, api := newTestPlugin(t, &configuration{
+			CensorCharacter: "*",
+			EnableAuditLog:  true,
+			Moderators:      "mod",
+		})
+		for _, user := range []*model.User{moderator, spammer} {
+			clone := *user
+			api.users[user.Id] = &clone
+		}
+		require.NoError(t, p.createStoredRule(ruleKindWords, &Rule{ID: "spam", Pattern: "buy now", Category: "spam"}))
+		require.NoError(t, p.createStoredRule(ruleKindUsernames, &Rule{ID: "admins", Pattern: "admin"}))
+		return p, api
+	}
+
+	execute := func(p *Plugin, userID, command string) string {
+		response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{UserId: userID, Command: command})
+		require.Nil(t, appErr)
+		assert.Equal(t, model.CommandResponseTypeEphemeral, response.ResponseType)
+		return response.Text
+	}
+
+	t.Run("is for moderators", func(t *testing.T) {
+		p, _ := newCommandPlugin(t)
+		assert.Equal(t, "Only moderators can use /toolkit.", execute(p, spammer.Id, "/toolkit test buy now"))
+		assert.Contains(t, execute(p, moderator.Id, "/toolkit"), "/toolkit test <text>")
+	})
+
+	t.Run("tests a text", func(t *testing.T) {
+		p, _ := newCommandPlugin(t)
+
+		assert.Equal(t, "**Action:** censor\n"+
+			"**Matches:** buy now\n"+
+			"**Rules:**\n"+
+			"* `spam` (censor): `buy now`, category spam\n"+
+			"**Censored:**\n```\nplease *******\n```",
+			execute(p, moderator.Id, "/toolkit test please buy now"))
+		assert.Equal(t, "No rule matches the text.", execute(p, moderator.Id, "/toolkit test hello"))
+	})
+
+	t.Run("checks a user", func(t *testing.T) {
+		p, _ := newCommandPlugin(t)
+
+		assert.Equal(t, "@spammer-admin fails the new user checks:\n* username matches rule admins: spammer-admin",
+			execute(p, moderator.Id, "/toolkit check-user @spammer-admin"))
+		assert.Equal(t, "@mod passes every new user check.", execute(p, moderator.Id, "/toolkit check-user "+moderator.Id))
+		assert.Equal(t, "User @nobody not found.", execute(p, moderator.Id, "/toolkit check-user @nobody"))
+	})
+
+	t.Run("restores a user and shows the history", func(t *testing.T) {
+		p, api := newCommandPlugin(t)
+
+		assert.Equal(t, "No moderation decisions about @spammer-admin were recorded.",
+			execute(p, moderator.Id, "/toolkit history @spammer-admin"))
+
+		assert.Equal(t, "@spammer-admin has been reactivated. There is no snapshot to restore their username and memberships from.", execute(p, moderator.Id, "/toolkit restore @spammer-admin"))
+		assert.Zero(t, api.users[spammer.Id].DeleteAt)
+		assert.Equal(t, "@mod is not deactivated.", execute(p, moderator.Id, "/toolkit restore @mod"))
+
+		history := execute(p, moderator.Id, "/toolkit history @spammer-admin")
+		assert.Contains(t, history, "| new_users | restore |  | @mod |  |")
+	})
+
+	t.Run("releases a quarantined user", func(t *testing.T) {
+		p, _ := newCommandPlugin(t)
+		require.NoError(t, p.quarantineUser(spammer.Id, "suspicious", moderator.Id))
+
+		assert.Equal(t, "@spammer-admin has been released from quarantine.", execute(p, moderator.Id, "/toolkit release @spammer-admin"))
+		assert.False(t, p.isQuarantined(spammer.Id))
+		assert.Equal(t, "@spammer-admin is not quarantined.", execute(p, moderator.Id, "/toolkit release @spammer-admin"))
+	})
+
+	t.Run("reloads the lists", func(t *testing.T) {
+		p, api := newCommandPlugin(t)
+		api.kv[storedRulesKey(ruleKindWords)] = []byte(`[]`)
+
+		assert.Equal(t, "The lists and rules have been reloaded.", execute(p, moderator.Id, "/toolkit lists reload"))
+		assert.Empty(t, p.getStoredRules(ruleKindWords))
+		assert.Equal(t, "Usage: `/toolkit lists reload`", execute(p, moderator.Id, "/toolkit lists"))
+	})
+
+	t.Run("manages domain lists", func(t *testing.T) {
+		p, _ := newCommandPlugin(t)
+
+		assert.Equal(t, "No domain list has been uploaded.", execute(p, moderator.Id, "/toolkit domains list"))
+		assert.Equal(t, "The domain list `spam` now has 2 entries.",
+			execute(p, moderator.Id, "/toolkit domains add spam spam.example *.junk.example"))
+		assert.Equal(t, "`user@mail.junk.example` is blocked by:\n* `*.junk.example` in list:spam",
+			execute(p, moderator.Id, "/toolkit domains check user@mail.junk.example"))
+		assert.Contains(t, execute(p, moderator.Id, "/toolkit domains list"), "* `spam`: 2 entries")
+
+		assert.Equal(t, "The domain list `spam` now has 1 entries.",
+			execute(p, moderator.Id, "/toolkit domains remove spam spam.example"))
+		assert.Equal(t, "`spam.example` is not in any domain list.", execute(p, moderator.Id, "/toolkit domains check spam.example"))
+		assert.Equal(t, "The domain list `spam` has been deleted.", execute(p, moderator.Id, "/toolkit domains delete spam"))
+		assert.Equal(t, "There is no domain list `spam`.", execute(p, moderator.Id, "/toolkit domains delete spam"))
+		assert.Equal(t, domainsCommandUsage, execute(p, moderator.Id, "/toolkit domains add spam"))
+	})
+}
@@ -24,16 +24,54 @@ import (
 // If you add non-reference types to your configuration struct, be sure to rewrite Clone as a deep
 // copy appropriate for your types.
 type configuration struct {
-	BadDomainsList     string
-	BadUsernamesList   string
-	BuiltinBadDomains  bool
-	BadWordsList       string
-	BlockNewUserPM     bool
-	BlockNewUserPMTime string
-	CensorCharacter    string
-	ExcludeBots        bool
-	RejectPosts        bool
-	WarningMessage     string `json:"WarningMessage"`
+	ActingUsername          string
+	AllowedDomains          string
+	AllowedWordsList        string
+	AuditRetentionDays      string
+	BadDomainsList          string
+	BadUsernamesList        string
+	BuiltinBadDomains       bool
+	BadWordsList            string
+	BanSanitizedEmails      bool
+	BlockNewUserPM          bool
+	BlockNewUserPMTime      string
+	CensorCharacter         string
+	CodeOfConductURL        string
+	EnableAuditLog          bool
+	ExcludeBots             bool
+	LogWordMatches          bool
+	MaxEmailDigitRatio      string
+	MaxEmailEntropy         string
+	MessageTemplates        string
+	ModerationChannelID     string
+	ModerationNotifications string
+	Moderators              string
+	PostFilters             string
+	QuarantineChannelID     string
+	QuarantinePosts         string
+	QuarantineTeamID        string
+	RejectPosts             bool
+	ShadowMode              string
+	SkipMarkdownRegions     string
+	TeamAllowedDomains      string
+	TextNormalization       string
+	UserResponses           string
+	WarningDelivery         string
+	WarningMessage          string `json:"WarningMessage"`
+	WordRules               string
+
+	// actingUserID is the id of the ActingUsername user, resolved when the configuration
+	// changes.
+	actingUserID string
+
+	// auditRetentionSeconds is the parsed AuditRetentionDays setting, zero to keep the audit
+	// log forever.
+	auditRetentionSeconds int64
+
+	// maxEmailDigitRatio and maxEmailEntropy are the parsed MaxEmailDigitRatio and
+	// MaxEmailEntropy settings, zero when off.
+	maxEmailDigitRatio float64
+	maxEmailEntropy    float64
 }
 
 //go:embed bad-domains.txt
@@ -42,6 +80,11 @@ var builtinDomainList string
 // The default regex template
 const defaultRegexTemplate = `(?mi)\b(%s)\b`
 
+// wordRegexTemplate matches whole words like defaultRegexTemplate, but its word boundaries know
+// the letters, digits and marks of every script, where \b only knows ASCII. The characters
+// around a word are part of the match, so the word is the first group; see findWords.
+const wordRegexTemplate = `(?mi)(?:^|[^\p{L}\p{N}\p{Mn}_])(%s)(?:$|[^\p{L}\p{N}\p{Mn}_])`
+
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
 // your configuration has reference types.
 func (c *configuration) Clone() *configuration {
@@ -90,12 +133,17 @@ func (p *Plugin) setConfiguration(configuration *configuration) {
 	p.configuration = configuration
 }
 
+// setupBadDomainList selects the domain lists new users are checked against.
 func (p *Plugin) setupBadDomainList() error {
-	domainList, err := jsonArrayToStringSlice(builtinDomainList)
-	if err != nil {
-		return errors.Wrap(err, "failed to pase builtin domains list")
+	var lists domainLists
+	if p.getConfiguration().BuiltinBadDomains {
+		builtin, err := builtinBadDomains()
+		if err != nil {
+			return err
+		}
+		lists = append(lists, builtin)
 	}
-	p.badDomainsList = domainList
+	p.updateDomainLists(func() { p.badDomains = lists })
 	return nil
 }
 
@@ -108,17 +156,89 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
+	if err := p.validatePostFilters(configuration); err != nil {
+		return errors.Wrap(err, "invalid post filter pipeline")
+	}
+
+	if err := p.validateShadowMode(configuration); err != nil {
+		return errors.Wrap(err, "invalid shadow mode")
+	}
+
+	if _, err := parseModerationEvents(configuration.ModerationNotifications); err != nil {
+		return errors.Wrap(err, "invalid moderation notifications")
+	}
+
+	if _, err := parseNormalizationStages(configuration.TextNormalization); err != nil {
+		return errors.Wrap(err, "invalid text normalization")
+	}
+
+	if _, err := parseMarkdownRegions(configuration.SkipMarkdownRegions); err != nil {
+		return errors.Wrap(err, "invalid markdown regions")
+	}
+
+	wordRules, err := loadWordRules(configuration.WordRules)
+	if err != nil {
+		return errors.Wrap(err, "invalid word rules")
+	}
+
+	if err := p.validateHoldActions(configuration, wordRules); err != nil {
+		return errors.Wrap(err, "invalid hold actions")
+	}
+
+	customMessages, err := parseMessageTemplates(configuration.MessageTemplates)
+	if err != nil {
+		return errors.Wrap(err, "invalid message templates")
+	}
+
+	userResponses, err := parseUserResponses(configuration.UserResponses)
+	if err != nil {
+		return errors.Wrap(err, "invalid user responses")
+	}
+
+	if err := p.validateApprovalResponses(configuration, userResponses); err != nil {
+		return errors.Wrap(err, "invalid user responses")
+	}
+
+	if err := validateQuarantinePosts(configuration.QuarantinePosts); err != nil {
+		return errors.Wrap(err, "invalid quarantine posts")
+	}
+
+	if err := parseAuditRetention(configuration); err != nil {
+		return errors.Wrap(err, "invalid audit retention")
+	}
+
+	if err := parseEmailHeuristics(configuration); err != nil {
+		return errors.Wrap(err, "invalid email heuristics")
+	}
+
+	teamAllowedDomains, err := parseTeamAllowedDomains(configuration.TeamAllowedDomains)
+	if err != nil {
+		return errors.Wrap(err, "invalid team allowed domains")
+	}
+
+	if err := p.resolveActingUser(configuration); err != nil {
+		return errors.Wrap(err, "invalid acting user")
+	}
+
 	p.setConfiguration(configuration)
 
 	if p.cache == nil {
 		p.cache = NewLRUCache(50)
 	}
 
-	p.badWordsRegex = splitWordListToRegex(configuration.BadWordsList)
+	p.badWordsRegex = splitWordListToRegex(configuration.BadWordsList, wordRegexTemplate)
+	p.badWordsEntries = compileListEntries(configuration.BadWordsList)
+	p.allowedWordsRegex = splitWordListToRegex(configuration.AllowedWordsList, wordRegexTemplate)
 	p.badDomainsRegex = splitWordListToRegex(configuration.BadDomainsList)
 	p.badUsernamesRegex = splitWordListToRegex(configuration.BadUsernamesList, `(?mi)(%s)`)
+	p.structuredWordRules = wordRules
+	p.customMessages = customMessages
+	p.allowedDomains = parseAllowedDomains(configuration.AllowedDomains)
+	p.teamAllowedDomains = teamAllowedDomains
 
-	p.setupBadDomainList()
+	if err := p.setupBadDomainList(); err != nil {
+		return errors.Wrap(err, "failed to set up bad domain lists")
+	}
 
 	return nil
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestWordListToRegex(t *testing.T) {
@@ -86,7 +87,7 @@ func TestOnConfigurationChange(t *testing.T) {
 		assert.NotNil(t, p.badWordsRegex)
 		assert.NotNil(t, p.badDomainsRegex)
 		assert.NotNil(t, p.badUsernamesRegex)
-		assert.NotNil(t, p.badDomainsList)
+		assert.NotEmpty(t, p.badDomains)
 
 		// Verify configuration values
 		cfg := p.getConfiguration()
@@ -229,8 +230,13 @@ func TestOnConfigurationChange(t *testing.T) {
 		err := p.OnConfigurationChange()
 
 		assert.NoError(t, err)
-		assert.NotNil(t, p.badDomainsList)
-		assert.Greater(t, len(*p.badDomainsList), 0) // Should have loaded builtin domains
+		require.Len(t, p.badDomains, 1)
+		assert.Greater(t, p.badDomains[0].size(), 0) // Should have loaded builtin domains
+
+		// Reloading the configuration reuses the parsed list
+		builtin := p.badDomains[0]
+		require.NoError(t, p.OnConfigurationChange())
+		assert.Same(t, builtin, p.badDomains[0])
 	})
 
 	t.Run("updates configuration atomically", func(t *testing.T) {
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// domainListKeyPrefix prefixes the KV store keys of the domain lists managed at runtime.
+	domainListKeyPrefix = "domain_list_"
+
+	// domainListNamesKey lists the names of the domain lists, so that they are loaded without
+	// going through every key of the plugin.
+	domainListNamesKey = "domain_lists"
+
+	// domainListsChangedEvent tells the other servers of a cluster to reload the domain lists.
+	domainListsChangedEvent = "domain_lists_changed"
+
+	// domainListsFeature names the domain lists in the audit log.
+	domainListsFeature = "domain_lists"
+
+	// maxDomainListSize bounds the size of an uploaded domain list.
+	maxDomainListSize = 10 << 20
+)
+
+// The formats domain lists can be uploaded in.
+const (
+	// domainListFormatText is one domain per line, with # comments.
+	domainListFormatText = "text"
+	// domainListFormatJSON is a JSON array of domains, like the built-in list.
+	domainListFormatJSON = "json"
+	// domainListFormatCSV is CSV with the domains in the first column, and an optional
+	// "domain" header.
+	domainListFormatCSV = "csv"
+)
+
+var domainListNameRegex = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// DomainList is a list of bad domains uploaded by an admin, which new users are checked
+// against along with the built-in list.
+type DomainList struct {
+	Name        string   `json:"name"`
+	Format      string   `json:"format"`
+	Description string   `json:"description,omitempty"`
+	Entries     []string `json:"entries"`
+	UpdatedBy   string   `json:"updated_by"`
+	UpdatedAt   int64    `json:"updated_at"`
+}
+
+// domainListSource is the source of the domains of an uploaded list in lookups and reports.
+func domainListSource(name string) string {
+	return "list:" + name
+}
+
+func domainListKey(name string) string {
+	return domainListKeyPrefix + name
+}
+
+func validateDomainListName(name string) error {
+	if !domainListNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid list name %q: use 1 to 64 lowercase letters, digits, - and _", name)
+	}
+	return nil
+}
+
+// parseDomainList parses the entries of a domain list in one of the supported formats.
+func parseDomainList(format string, r io.Reader) ([]string, error) {
+	var entries []string
+	switch format {
+	case domainListFormatText, "":
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxDomainListSize)
+		for scanner.Scan() {
+			line, _, _ := strings.Cut(scanner.Text(), "#")
+			if line = strings.TrimSpace(line); line != "" {
+				entries = append(entries, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, errors.Wrap(err, "failed to read domain list")
+		}
+	case domainListFormatJSON:
+		if err := json.NewDecoder(r).Decode(&entries); err != nil {
+			return nil, errors.Wrap(err, "domain list must be a JSON array of domains")
+		}
+	case domainListFormatCSV:
+		reader := csv.NewReader(r)
+		reader.Comment = '#'
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read CSV domain list")
+		}
+		for i, record := range records {
+			if len(record) == 0 {
+				continue
+			}
+			if i == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "domain") {
+				continue
+			}
+			entries = append(entries, record[0])
+		}
+	default:
+		return nil, fmt.Errorf("unknown domain list format: %q", format)
+	}
+
+	valid := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.ContainsAny(entry, " \t@/") {
+			return nil, fmt.Errorf("invalid domain: %q", entry)
+		}
+		valid = append(valid, entry)
+	}
+	return valid, nil
+}
+
+// getUploadedDomains returns the sets of the uploaded domain lists.
+func (p *Plugin) getUploadedDomains() domainLists {
+	p.domainListsLock.RLock()
+	defer p.domainListsLock.RUnlock()
+
+	return p.uploadedDomains
+}
+
+// checkedDomainLists returns the domain lists new users are checked against: the built-in
+// list, if enabled, then the uploaded lists.
+func (p *Plugin) checkedDomainLists() domainLists {
+	p.domainListsLock.RLock()
+	defer p.domainListsLock.RUnlock()
+
+	return p.checkedDomains
+}
+
+// updateDomainLists replaces the built-in or the uploaded domain lists with update, and combines
+// them again, so that checking a new user does not have to.
+func (p *Plugin) updateDomainLists(update func()) {
+	p.domainListsLock.Lock()
+	defer p.domainListsLock.Unlock()
+
+	update()
+	lists := make(domainLists, 0, len(p.badDomains)+len(p.uploadedDomains))
+	lists = append(lists, p.badDomains...)
+	p.checkedDomains = append(lists, p.uploadedDomains...)
+}
+
+// loadDomainLists builds the sets of the uploaded domain lists from the KV store.
+func (p *Plugin) loadDomainLists() error {
+	lists, err := p.listDomainLists()
+	if err != nil {
+		return err
+	}
+
+	sets := make(domainLists, 0, len(lists))
+	for _, list := range lists {
+		sets = append(sets, newDomainSet(domainListSource(list.Name), list.Entries))
+	}
+
+	p.updateDomainLists(func() { p.uploadedDomains = sets })
+	return nil
+}
+
+// listDomainLists returns every uploaded domain list, sorted by name.
+func (p *Plugin) listDomainLists() ([]*DomainList, error) {
+	names, _, err := loadKVStrings(p.API, domainListNamesKey, "domain list names")
+	if err != nil {
+		return nil, err
+	}
+
+	lists := make([]*DomainList, 0, len(names))
+	for _, name := range names {
+		list, err := p.getDomainList(name)
+		if err != nil {
+			return nil, err
+		}
+		if list != nil {
+			lists = append(lists, list)
+		}
+	}
+
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].Name < lists[j].Name
+	})
+	return lists, nil
+}
+
+// getDomainList returns an uploaded domain list, or nil if there is none by that name.
+func (p *Plugin) getDomainList(name string) (*DomainList, error) {
+	list, _, err := p.loadDomainList(name)
+	return list, err
+}
+
+// loadDomainList returns an uploaded domain list and its stored data, or nil if there is none
+// by that name.
+func (p *Plugin) loadDomainList(name string) (*DomainList, []byte, error) {
+	data, appErr := p.API.KVGet(domainListKey(name))
+	if appErr != nil {
+		return nil, nil, errors.Wrap(appErr, "failed to load domain list")
+	}
+	if data == nil {
+		return nil, nil, nil
+	}
+	var list DomainList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to decode domain list")
+	}
+	return &list, data, nil
+}
+
+// saveDomainList creates or replaces an uploaded domain list.
+func (p *Plugin) saveDomainList(list *DomainList, actor string) error {
+	if err := validateDomainListName(list.Name); err != nil {
+		return err
+	}
+	list.UpdatedBy = actor
+	list.UpdatedAt = model.GetMillis()
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode domain list")
+	}
+	// List the name first, so that a stored list is never missed when loading the lists.
+	if err := updateKVStrings(p.API, domainListNamesKey, "domain list names", list.Name, true); err != nil {
+		return err
+	}
+	if appErr := p.API.KVSet(domainListKey(list.Name), data); appErr != nil {
+		return errors.Wrap(appErr, "failed to store domain list")
+	}
+	return p.domainListSaved(list, actor)
+}
+
+// domainListSaved records a saved domain list in the audit log and reloads the domain lists.
+func (p *Plugin) domainListSaved(list *DomainList, actor string) error {
+	p.audit(&AuditRecord{
+		Actor:   actor,
+		Feature: domainListsFeature,
+		Action:  "save_list",
+		Rules:   []string{list.Name},
+		Excerpt: fmt.Sprintf("%d entries", len(list.Entries)),
+	})
+	return p.domainListsChanged()
+}
+
+// updateDomainList applies an edit to an uploaded domain list, retrying when the list is
+// changed concurrently. The edit gets nil if there is no list by that name, and returns nil to
+// leave the store untouched.
+func (p *Plugin) updateDomainList(name, actor string, edit func(*DomainList) *DomainList) (*DomainList, error) {
+	if err := validateDomainListName(name); err != nil {
+		return nil, err
+	}
+	load := func() (*DomainList, []byte, error) {
+		return p.loadDomainList(name)
+	}
+	list, updated, err := updateKVJSON(p.API, domainListKey(name), "domain list "+name, load,
+		func(list *DomainList) (*DomainList, bool, error) {
+			created := list == nil
+			if list = edit(list); list == nil {
+				return nil, false, nil
+			}
+			if created {
+				if err := updateKVStrings(p.API, domainListNamesKey, "domain list names", name, true); err != nil {
+					return nil, false, err
+				}
+			}
+			list.UpdatedBy = actor
+			list.UpdatedAt = model.GetMillis()
+			return list, true, nil
+		})
+	if err != nil || !updated {
+		return nil, err
+	}
+	return list, p.domainListSaved(list, actor)
+}
+
+// addToDomainList adds entries to an uploaded domain list, creating it if needed.
+func (p *Plugin) addToDomainList(name string, entries []string, actor string) (*DomainList, error) {
+	return p.updateDomainList(name, actor, func(list *DomainList) *DomainList {
+		if list == nil {
+			list = &DomainList{Name: name, Format: domainListFormatText}
+		}
+		existing := make(map[string]struct{}, len(list.Entries)+len(entries))
+		for _, entry := range list.Entries {
+			existing[entry] = struct{}{}
+		}
+		for _, entry := range entries {
+			if _, found := existing[entry]; !found {
+				existing[entry] = struct{}{}
+				list.Entries = append(list.Entries, entry)
+			}
+		}
+		return list
+	})
+}
+
+// removeFromDomainList removes entries from an uploaded domain list. It returns nil if there
+// is no list by that name.
+func (p *Plugin) removeFromDomainList(name string, entries []string, actor string) (*DomainList, error) {
+	removed := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		removed[entry] = struct{}{}
+	}
+	return p.updateDomainList(name, actor, func(list *DomainList) *DomainList {
+		if list == nil {
+			return nil
+		}
+		kept := list.Entries[:0]
+		for _, entry := range list.Entries {
+			if _, found := removed[entry]; !found {
+				kept = append(kept, entry)
+			}
+		}
+		list.Entries = kept
+		return list
+	})
+}
+
+// deleteDomainList deletes an uploaded domain list. It returns false if there is none by that
+// name.
+func (p *Plugin) deleteDomainList(name, actor string) (bool, error) {
+	list, err := p.getDomainList(name)
+	if err != nil || list == nil {
+		return false, err
+	}
+	if appErr := p.API.KVDelete(domainListKey(name)); appErr != nil {
+		return false, errors.Wrap(appErr, "failed to delete domain list")
+	}
+	if err := updateKVStrings(p.API, domainListNamesKey, "domain list names", name, false); err != nil {
+		return false, err
+	}
+
+	p.audit(&AuditRecord{
+		Actor:   actor,
+		Feature: domainListsFeature,
+		Action:  "delete_list",
+		Rules:   []string{name},
+	})
+	return true, p.domainListsChanged()
+}
+
+// domainListsChanged reloads the domain lists on every server.
+func (p *Plugin) domainListsChanged() error {
+	if err := p.loadDomainLists(); err != nil {
+		return err
+	}
+	if err := p.API.PublishPluginClusterEvent(
+		model.PluginClusterEvent{Id: domainListsChangedEvent},
+		model.PluginClusterEventSendOptions{SendType: model.PluginClusterEventSendTypeReliable},
+	); err != nil {
+		p.API.LogWarn("Failed to tell the cluster to reload domain lists", "err", err.Error())
+	}
+	return nil
+}
+
+// DomainMatch is an entry of a domain list that matches a domain.
+type DomainMatch struct {
+	Entry  string `json:"entry"`
+	Source string `json:"source"`
+}
+
+// explainDomain returns every entry of the checked domain lists that matches the domain of an
+// email address or a domain, to tell why it is blocked.
+func (p *Plugin) explainDomain(emailOrDomain string) []*DomainMatch {
+	domain := normalizeDomain(emailOrDomain)
+	if strings.Contains(emailOrDomain, "@") {
+		domain = emailDomain(emailOrDomain)
+	}
+
+	matches := []*DomainMatch{}
+	for _, set := range p.checkedDomainLists() {
+		if entry, found := set.match(domain); found {
+			matches = append(matches, &DomainMatch{Entry: entry, Source: set.source})
+		}
+	}
+	return matches
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDomainList(t *testing.T) {
+	for format, data := range map[string]string{
+		domainListFormatText: "# spam sources\nspam.example\n\n *.throwaway.example  # subdomains only\n",
+		domainListFormatJSON: `["spam.example", "*.throwaway.example"]`,
+		domainListFormatCSV:  "domain,added\n# spam sources\nspam.example,2024-01-01\n*.throwaway.example\n",
+	} {
+		t.Run(format, func(t *testing.T) {
+			entries, err := parseDomainList(format, strings.NewReader(data))
+			require.NoError(t, err)
+			assert.Equal(t, []string{"spam.example", "*.throwaway.example"}, entries)
+		})
+	}
+
+	t.Run("rejects invalid lists", func(t *testing.T) {
+		for format, data := range map[string]string{
+			domainListFormatText: "user@spam.example",
+			domainListFormatJSON: `{"domains": ["spam.example"]}`,
+			"xml":                "<domains/>",
+		} {
+			_, err := parseDomainList(format, strings.NewReader(data))
+			assert.Error(t, err, format)
+		}
+	})
+}
+
+func TestDomainListsAPI(t *testing.T) {
+	moderatorID := model.NewId()
+	newDomainListsPlugin := func(t *testing.T) (*Plugin, *MembershipMockAPI) {
+		p, api := newTestPlugin(t, &configuration{BuiltinBadDomains: true, EnableAuditLog: true})
+		api.users[moderatorID] = &model.User{Id: moderatorID, Username: "mod"}
+		api.admins[moderatorID] = true
+		return p, api
+	}
+
+	request := func(p *Plugin, method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Mattermost-User-Id", moderatorID)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(&plugin.Context{}, w, r)
+		return w
+	}
+
+	t.Run("uploaded lists are merged with the builtin list", func(t *testing.T) {
+		p, _ := newDomainListsPlugin(t)
+
+		w := request(p, http.MethodPut, "/api/v1/domains/lists/spam?format=csv&description=Spam+sources",
+			"domain\nspam.example\n10minutemail.com\n")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		err := p.checkBadEmail(&model.User{Email: "user@mail.spam.example"})
+		require.Error(t, err)
+		assert.Equal(t, "email domain matches spam.example in domain list:spam: user@mail.spam.example", err.Error())
+		err = p.checkBadEmail(&model.User{Email: "user@10minutemail.com"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "builtin list")
+
+		w = request(p, http.MethodGet, "/api/v1/domains/check?domain=user@10minutemail.com", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var matches []*DomainMatch
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&matches))
+		assert.Equal(t, []*DomainMatch{
+			{Entry: "10minutemail.com", Source: builtinDomainSource},
+			{Entry: "10minutemail.com", Source: "list:spam"},
+		}, matches)
+
+		w = request(p, http.MethodGet, "/api/v1/domains/lists", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var infos []*domainListInfo
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&infos))
+		require.Len(t, infos, 1)
+		assert.Equal(t, "Spam sources", infos[0].Description)
+		assert.Equal(t, 2, infos[0].Entries)
+		assert.Equal(t, moderatorID, infos[0].UpdatedBy)
+	})
+
+	t.Run("replaces and deletes lists", func(t *testing.T) {
+		p, api := newDomainListsPlugin(t)
+
+		require.Equal(t, http.StatusOK, request(p, http.MethodPut, "/api/v1/domains/lists/spam", "spam.example").Code)
+		require.Equal(t, http.StatusOK,
+			request(p, http.MethodPut, "/api/v1/domains/lists/spam?format=json", `["junk.example"]`).Code)
+		assert.NoError(t, p.checkBadEmail(&model.User{Email: "user@spam.example"}))
+		assert.Error(t, p.checkBadEmail(&model.User{Email: "user@junk.example"}))
+
+		assert.JSONEq(t, `["spam"]`, string(api.kv[domainListNamesKey]))
+
+		assert.Equal(t, http.StatusNoContent, request(p, http.MethodDelete, "/api/v1/domains/lists/spam", "").Code)
+		assert.NoError(t, p.checkBadEmail(&model.User{Email: "user@junk.example"}))
+		assert.JSONEq(t, `[]`, string(api.kv[domainListNamesKey]))
+		assert.Equal(t, http.StatusNotFound, request(p, http.MethodDelete, "/api/v1/domains/lists/spam", "").Code)
+	})
+
+	t.Run("rejects invalid lists", func(t *testing.T) {
+		p, _ := newDomainListsPlugin(t)
+
+		assert.Equal(t, http.StatusBadRequest,
+			request(p, http.MethodPut, "/api/v1/domains/lists/spam?format=xml", "<domains/>").Code)
+		assert.Equal(t, http.StatusNotFound,
+			request(p, http.MethodPut, "/api/v1/domains/lists/Spam%20List", "spam.example").Code)
+	})
+
+	t.Run("adds and removes entries", func(t *testing.T) {
+		p, api := newDomainListsPlugin(t)
+
+		list, err := p.addToDomainList("spam", []string{"spam.example", "junk.example", "spam.example"}, moderatorID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"spam.example", "junk.example"}, list.Entries)
+
+		list, err = p.removeFromDomainList("spam", []string{"spam.example", "other.example"}, moderatorID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"junk.example"}, list.Entries)
+		assert.NoError(t, p.checkBadEmail(&model.User{Email: "user@spam.example"}))
+
+		list, err = p.removeFromDomainList("missing", []string{"spam.example"}, moderatorID)
+		require.NoError(t, err)
+		assert.Nil(t, list)
+		assert.JSONEq(t, `["spam"]`, string(api.kv[domainListNamesKey]), "only created lists are listed")
+	})
+
+	t.Run("concurrent edits are kept", func(t *testing.T) {
+		p, api := newDomainListsPlugin(t)
+		_, err := p.addToDomainList("spam", []string{"spam.example"}, moderatorID)
+		require.NoError(t, err)
+
+		api.KVCompareAndSetFunc = func(key string, oldValue, newValue []byte) (bool, *model.AppError) {
+			api.KVCompareAndSetFunc = nil
+			// Another moderator adds an entry in the meantime.
+			_, err := p.addToDomainList("spam", []string{"junk.example"}, moderatorID)
+			require.NoError(t, err)
+			return api.KVCompareAndSet(key, oldValue, newValue)
+		}
+		list, err := p.addToDomainList("spam", []string{"other.example"}, moderatorID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"spam.example", "junk.example", "other.example"}, list.Entries)
+	})
+
+	t.Run("lists are loaded from the KV store", func(t *testing.T) {
+		p, api := newDomainListsPlugin(t)
+		require.NoError(t, p.saveDomainList(&DomainList{Name: "spam", Entries: []string{"spam.example"}}, moderatorID))
+
+		reloaded := &Plugin{configuration: &configuration{}}
+		reloaded.SetAPI(api)
+		reloaded.OnPluginClusterEvent(&plugin.Context{}, model.PluginClusterEvent{Id: domainListsChangedEvent})
+		assert.Error(t, reloaded.checkBadEmail(&model.User{Email: "user@spam.example"}))
+	})
+
+	t.Run("only listed lists are loaded", func(t *testing.T) {
+		p, api := newDomainListsPlugin(t)
+		api.kv[domainListKey("stray")] = []byte(`{"name": "stray", "entries": ["stray.example"]}`)
+		require.NoError(t, p.saveDomainList(&DomainList{Name: "spam", Entries: []string{"spam.example"}}, moderatorID))
+
+		lists, err := p.listDomainLists()
+		require.NoError(t, err)
+		require.Len(t, lists, 1)
+		assert.Equal(t, "spam", lists[0].Name)
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/idna"
+)
+
+// emailDomain returns the normalized domain of an email address, or "" if the address has
+// no domain.
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return normalizeDomain(email[at+1:])
+}
+
+// normalizeDomain lowercases a domain, strips its trailing dot and converts internationalized
+// labels to punycode, so that "Bücher.Example." and "xn--bcher-kva.example" compare equal.
+func normalizeDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	// ASCII domains are already in punycode, which spares most addresses the idna lookup.
+	if isASCII(domain) {
+		return domain
+	}
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		return ascii
+	}
+	// Domains idna rejects, e.g. with underscores, are still matched as they are.
+	return domain
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeDomainEntry normalizes an entry of a domain list. Wildcard entries, written
+// "*.example.com" or ".example.com", are normalized to ".example.com".
+func normalizeDomainEntry(entry string) string {
+	entry = strings.TrimSpace(entry)
+	wildcard := false
+	if rest, found := strings.CutPrefix(entry, "*."); found {
+		entry, wildcard = rest, true
+	} else if rest, found := strings.CutPrefix(entry, "."); found {
+		entry, wildcard = rest, true
+	}
+
+	entry = normalizeDomain(entry)
+	if entry == "" {
+		return ""
+	}
+	if wildcard {
+		return "." + entry
+	}
+	return entry
+}
+
+// domainSet is a list of domains with lookups in constant time per label of the domain
+// looked up, whatever the size of the list.
+type domainSet struct {
+	// source names where the domains come from, e.g. "builtin".
+	source string
+	// domains are the entries that match a domain and its subdomains.
+	domains map[string]struct{}
+	// wildcards are the entries that only match subdomains, without their "*." prefix.
+	wildcards map[string]struct{}
+}
+
+// newDomainSet builds a domain set from the entries of a domain list.
+func newDomainSet(source string, entries []string) *domainSet {
+	set := &domainSet{
+		source:    source,
+		domains:   make(map[string]struct{}, len(entries)),
+		wildcards: map[string]struct{}{},
+	}
+	for _, entry := range entries {
+		entry = normalizeDomainEntry(entry)
+		if wildcard, found := strings.CutPrefix(entry, "."); found {
+			set.wildcards[wildcard] = struct{}{}
+		} else if entry != "" {
+			set.domains[entry] = struct{}{}
+		}
+	}
+	return set
+}
+
+// size returns the number of entries of the set.
+func (s *domainSet) size() int {
+	return len(s.domains) + len(s.wildcards)
+}
+
+// match looks up a normalized domain, on label boundaries: "example.com" matches
+// "mail.example.com" but not "badexample.com", and "*.example.com" only matches subdomains.
+// It returns the entry that matched.
+func (s *domainSet) match(domain string) (string, bool) {
+	if domain == "" {
+		return "", false
+	}
+	if _, ok := s.domains[domain]; ok {
+		return domain, true
+	}
+	for i := 0; i < len(domain); i++ {
+		if domain[i] != '.' {
+			continue
+		}
+		parent := domain[i+1:]
+		if _, ok := s.domains[parent]; ok {
+			return parent, true
+		}
+		if _, ok := s.wildcards[parent]; ok {
+			return "*." + parent, true
+		}
+	}
+	return "", false
+}
+
+// domainLists are domain sets looked up together, in order.
+type domainLists []*domainSet
+
+// match looks up a normalized domain in every set, and returns the first entry that matched
+// and the source of its set.
+func (l domainLists) match(domain string) (entry, source string, found bool) {
+	for _, set := range l {
+		if entry, found := set.match(domain); found {
+			return entry, set.source, true
+		}
+	}
+	return "", "", false
+}
+
+// builtinDomainSource is the source of the built-in list of bad domains.
+const builtinDomainSource = "builtin"
+
+// builtinBadDomains parses the built-in list of bad domains the first time it is needed. The
+// set is shared by every configuration, so reloading the configuration does not parse it again.
+var builtinBadDomains = sync.OnceValues(func() (*domainSet, error) {
+	entries, err := jsonArrayToStringSlice(builtinDomainList)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse builtin domains list")
+	}
+	return newDomainSet(builtinDomainSource, *entries), nil
+})
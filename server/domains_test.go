@@ -0,0 +1,156 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeDomainEntry(t *testing.T) {
+	for entry, expected := range map[string]string{
+		"Example.COM":       "example.com",
+		" example.com. ":    "example.com",
+		"*.example.com":     ".example.com",
+		".example.com":      ".example.com",
+		"bücher.example":    "xn--bcher-kva.example",
+		"*.BÜCHER.example.": ".xn--bcher-kva.example",
+		"under_score.com":   "under_score.com",
+		"":                  "",
+	} {
+		assert.Equal(t, expected, normalizeDomainEntry(entry), entry)
+	}
+}
+
+func TestDomainSet(t *testing.T) {
+	set := newDomainSet("test", []string{
+		"10minutemail.com",
+		"*.wildcard.net",
+		"bücher.example",
+		"",
+	})
+	assert.Equal(t, 3, set.size())
+
+	for email, expected := range map[string]string{
+		"user@10minutemail.com":          "10minutemail.com",
+		"user@mail.10minutemail.com":     "10minutemail.com",
+		"user@a.b.10minutemail.com":      "10minutemail.com",
+		"user@10MinuteMail.COM":          "10minutemail.com",
+		"user@10minutemail.com.":         "10minutemail.com",
+		"user@not10minutemail.com":       "",
+		"user@10minutemail.com.evil.org": "",
+		"user@wildcard.net":              "",
+		"user@mail.wildcard.net":         "*.wildcard.net",
+		"user@xn--bcher-kva.example":     "xn--bcher-kva.example",
+		"user@shop.Bücher.example":       "xn--bcher-kva.example",
+		"quoted@local@10minutemail.com":  "10minutemail.com",
+		"user@example.org":               "",
+		"no-at-sign.10minutemail.com":    "",
+		"":                               "",
+		"user@":                          "",
+	} {
+		entry, found := set.match(emailDomain(email))
+		assert.Equal(t, expected != "", found, email)
+		assert.Equal(t, expected, entry, email)
+	}
+}
+
+func TestDomainLists(t *testing.T) {
+	lists := domainLists{
+		newDomainSet("first", []string{"spam.com"}),
+		newDomainSet("second", []string{"spam.com", "junk.org"}),
+	}
+
+	entry, source, found := lists.match("mail.junk.org")
+	assert.True(t, found)
+	assert.Equal(t, "junk.org", entry)
+	assert.Equal(t, "second", source)
+
+	_, source, _ = lists.match("spam.com")
+	assert.Equal(t, "first", source)
+
+	_, _, found = lists.match("example.com")
+	assert.False(t, found)
+}
+
+func BenchmarkBuiltinBadDomains(b *testing.B) {
+	b.Run("parse", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			entries, err := jsonArrayToStringSlice(builtinDomainList)
+			if err != nil {
+				b.Fatal(err)
+			}
+			newDomainSet(builtinDomainSource, *entries)
+		}
+	})
+
+	builtin, err := builtinBadDomains()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for name, email := range map[string]string{
+		"hit":       "user@10minutemail.com",
+		"subdomain": "user@a.b.c.10minutemail.com",
+		"miss":      "user@mail.example.com",
+	} {
+		domain := emailDomain(email)
+		b.Run("match "+name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				builtin.match(domain)
+			}
+		})
+	}
+}
+
+func BenchmarkCheckBadEmail(b *testing.B) {
+	p := &Plugin{configuration: &configuration{BuiltinBadDomains: true}}
+	if err := p.setupBadDomainList(); err != nil {
+		b.Fatal(err)
+	}
+	user := &model.User{Email: "someone@mail.example.com"}
+
+	// Checking a new user must not allocate: the lists are combined when they are loaded, and
+	// ASCII domains skip the idna lookup.
+	if allocs := testing.AllocsPerRun(100, func() { _ = p.checkBadEmail(user) }); allocs > 0 {
+		b.Fatalf("checkBadEmail allocates %v times per user", allocs)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.checkBadEmail(user); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkBuiltinBadDomainsMemory reports the heap the built-in domain set keeps, which every
+// server holds for as long as the plugin runs.
+func BenchmarkBuiltinBadDomainsMemory(b *testing.B) {
+	entries, err := jsonArrayToStringSlice(builtinDomainList)
+	if err != nil {
+		b.Fatal(err)
+	}
+	sets := make([]*domainSet, b.N)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := range sets {
+		sets[i] = newDomainSet(builtinDomainSource, *entries)
+	}
+	b.StopTimer()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	perEntry := float64(after.HeapAlloc-before.HeapAlloc) / float64(b.N*sets[0].size())
+	b.ReportMetric(perEntry, "heap-B/entry")
+	if perEntry > 64 {
+		b.Fatalf("the built-in domain set keeps %.0f bytes per entry", perEntry)
+	}
+	runtime.KeepAlive(sets)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// bannedEmailKeyPrefix prefixes the KV store keys of the banned email addresses. The keys
+	// hold a hash of the normalized address, as addresses can be longer than KV store keys.
+	bannedEmailKeyPrefix = "banned_email_"
+
+	// bannedEmailsKey lists the normalized banned addresses, so that they are listed without
+	// going through every key of the plugin.
+	bannedEmailsKey = "banned_emails"
+
+	// bannedEmailsFeature names the banned email addresses in the audit log.
+	bannedEmailsFeature = "banned_emails"
+
+	// minHeuristicLocalPartLength is the length below which local parts are too short for the
+	// digit ratio and entropy to tell anything.
+	minHeuristicLocalPartLength = 8
+)
+
+// emailProvider describes how a mail provider delivers variants of an address to the same
+// mailbox.
+type emailProvider struct {
+	// domain is the canonical domain of the provider.
+	domain string
+	// foldDots tells whether the provider ignores dots in local parts.
+	foldDots bool
+	// tagSeparator starts the tag of a subaddress, e.g. "+" in "user+tag@example.com".
+	tagSeparator string
+}
+
+// defaultEmailProvider applies to the domains of no known provider. Most mail servers deliver
+// "+" subaddresses to the mailbox of the address without its tag.
+var defaultEmailProvider = emailProvider{tagSeparator: "+"}
+
+// emailProviders are the providers whose addresses are normalized beyond plus-tags, keyed by
+// domain.
+var emailProviders = map[string]emailProvider{
+	"gmail.com":      {domain: "gmail.com", foldDots: true, tagSeparator: "+"},
+	"googlemail.com": {domain: "gmail.com", foldDots: true, tagSeparator: "+"},
+	"yahoo.com":      {domain: "yahoo.com", tagSeparator: "-"},
+	"ymail.com":      {domain: "ymail.com", tagSeparator: "-"},
+}
+
+// normalizeEmail returns the canonical form of an email address, shared by the variants a
+// provider delivers to the same mailbox: lowercased, without a subaddress tag, and without dots
+// for Gmail, so that "S.P.A.M+1@GoogleMail.com" becomes "spam@gmail.com". Addresses without a
+// domain are only lowercased.
+func normalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], normalizeDomain(email[at+1:])
+
+	provider, known := emailProviders[domain]
+	if !known {
+		provider = defaultEmailProvider
+	}
+	if provider.domain != "" {
+		domain = provider.domain
+	}
+	if tagged, _, found := strings.Cut(local, provider.tagSeparator); found && tagged != "" {
+		local = tagged
+	}
+	if provider.foldDots {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+	return local + "@" + domain
+}
+
+// localPartDigitRatio returns the share of digits in the local part of a normalized address.
+func localPartDigitRatio(local string) float64 {
+	if local == "" {
+		return 0
+	}
+	digits := 0
+	for _, r := range local {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return float64(digits) / float64(len([]rune(local)))
+}
+
+// localPartEntropy returns the Shannon entropy of the local part of a normalized address, in
+// bits per character. Random strings score higher than names and words.
+func localPartEntropy(local string) float64 {
+	counts := map[rune]int{}
+	total := 0
+	for _, r := range local {
+		counts[r]++
+		total++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		frequency := float64(count) / float64(total)
+		entropy -= frequency * math.Log2(frequency)
+	}
+	return entropy
+}
+
+// parseEmailHeuristics parses the thresholds of the local part heuristics. Empty settings
+// turn the heuristic off.
+func parseEmailHeuristics(configuration *configuration) error {
+	for _, threshold := range []struct {
+		setting string
+		value   *float64
+		max     float64
+	}{
+		{configuration.MaxEmailDigitRatio, &configuration.maxEmailDigitRatio, 1},
+		{configuration.MaxEmailEntropy, &configuration.maxEmailEntropy, math.Inf(1)},
+	} {
+		*threshold.value = 0
+		if strings.TrimSpace(threshold.setting) == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(threshold.setting), 64)
+		if err != nil || value <= 0 || value > threshold.max {
+			return fmt.Errorf("invalid threshold: %q", threshold.setting)
+		}
+		*threshold.value = value
+	}
+	return nil
+}
+
+// checkSuspiciousEmail rejects new users whose local part looks generated: too many digits, or
+// too random.
+func (p *Plugin) checkSuspiciousEmail(user *model.User) error {
+	configuration := p.getConfiguration()
+	if configuration.maxEmailDigitRatio == 0 && configuration.maxEmailEntropy == 0 {
+		return nil
+	}
+
+	local, _, _ := strings.Cut(normalizeEmail(user.Email), "@")
+	if len([]rune(local)) < minHeuristicLocalPartLength {
+		return nil
+	}
+	if ratio := localPartDigitRatio(local); configuration.maxEmailDigitRatio != 0 && ratio > configuration.maxEmailDigitRatio {
+		return &userViolation{
+			validator: validatorSuspiciousEmails,
+			reason:    fmt.Sprintf("email address is %.0f%% digits: %v", ratio*100, user.Email),
+		}
+	}
+	if entropy := localPartEntropy(local); configuration.maxEmailEntropy != 0 && entropy > configuration.maxEmailEntropy {
+		return &userViolation{
+			validator: validatorSuspiciousEmails,
+			reason:    fmt.Sprintf("email address looks random (%.2f bits of entropy per character): %v", entropy, user.Email),
+		}
+	}
+	return nil
+}
+
+// BannedEmail is the normalized email address of a banned user. New users whose address
+// normalizes to it fail the banned_emails check.
+type BannedEmail struct {
+	Email    string `json:"email"`
+	Reason   string `json:"reason,omitempty"`
+	BannedBy string `json:"banned_by"`
+	BannedAt int64  `json:"banned_at"`
+}
+
+func bannedEmailKey(normalized string) string {
+	hash := sha256.Sum256([]byte(normalized))
+	return bannedEmailKeyPrefix + hex.EncodeToString(hash[:])
+}
+
+// checkBannedEmail rejects new users whose normalized address is banned.
+func (p *Plugin) checkBannedEmail(user *model.User) error {
+	if user.Email == "" {
+		return nil
+	}
+	banned, err := p.getBannedEmail(user.Email)
+	if err != nil {
+		p.API.LogWarn("Failed to look up banned email", "user_id", user.Id, "err", err.Error())
+		return nil
+	}
+	if banned == nil {
+		return nil
+	}
+	return &userViolation{
+		validator: validatorBannedEmails,
+		reason:    fmt.Sprintf("email address is banned as %s: %v", banned.Email, user.Email),
+	}
+}
+
+// getBannedEmail returns the ban of the normalized form of an email address, or nil if it is not
+// banned.
+func (p *Plugin) getBannedEmail(email string) (*BannedEmail, error) {
+	data, appErr := p.API.KVGet(bannedEmailKey(normalizeEmail(email)))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to load banned email")
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var banned BannedEmail
+	if err := json.Unmarshal(data, &banned); err != nil {
+		return nil, errors.Wrap(err, "failed to decode banned email")
+	}
+	return &banned, nil
+}
+
+// banEmail bans the normalized form of an email address, and returns the ban.
+func (p *Plugin) banEmail(email, reason, actor string) (*BannedEmail, error) {
+	banned := &BannedEmail{
+		Email:    normalizeEmail(email),
+		Reason:   reason,
+		BannedBy: actor,
+		BannedAt: model.GetMillis(),
+	}
+	if !strings.Contains(banned.Email, "@") {
+		return nil, fmt.Errorf("invalid email address: %q", email)
+	}
+
+	data, err := json.Marshal(banned)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode banned email")
+	}
+	// List the address first, so that a stored ban is never missing from the list.
+	if err := updateKVStrings(p.API, bannedEmailsKey, "banned email list", banned.Email, true); err != nil {
+		return nil, err
+	}
+	if appErr := p.API.KVSet(bannedEmailKey(banned.Email), data); appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to store banned email")
+	}
+
+	p.audit(&AuditRecord{
+		Actor:   actor,
+		Feature: bannedEmailsFeature,
+		Action:  "ban",
+		Excerpt: excerpt(strings.TrimSuffix(banned.Email+": "+reason, ": ")),
+	})
+	return banned, nil
+}
+
+// unbanEmail lifts the ban of the normalized form of an email address. It returns false if the
+// address was not banned.
+func (p *Plugin) unbanEmail(email, actor string) (bool, error) {
+	banned, err := p.getBannedEmail(email)
+	if err != nil || banned == nil {
+		return false, err
+	}
+	if appErr := p.API.KVDelete(bannedEmailKey(banned.Email)); appErr != nil {
+		return false, errors.Wrap(appErr, "failed to remove banned email")
+	}
+	if err := updateKVStrings(p.API, bannedEmailsKey, "banned email list", banned.Email, false); err != nil {
+		return false, err
+	}
+
+	p.audit(&AuditRecord{
+		Actor:   actor,
+		Feature: bannedEmailsFeature,
+		Action:  "unban",
+		Excerpt: excerpt(banned.Email),
+	})
+	return true, nil
+}
+
+// listBannedEmails returns the banned addresses, most recently banned first.
+func (p *Plugin) listBannedEmails() ([]*BannedEmail, error) {
+	emails, _, err := loadKVStrings(p.API, bannedEmailsKey, "banned email list")
+	if err != nil {
+		return nil, err
+	}
+
+	bans := make([]*BannedEmail, 0, len(emails))
+	for _, email := range emails {
+		data, appErr := p.API.KVGet(bannedEmailKey(email))
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to load banned email")
+		}
+		if data == nil {
+			continue
+		}
+		var banned BannedEmail
+		if err := json.Unmarshal(data, &banned); err != nil {
+			return nil, errors.Wrap(err, "failed to decode banned email")
+		}
+		bans = append(bans, &banned)
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].BannedAt > bans[j].BannedAt
+	})
+	return bans, nil
+}
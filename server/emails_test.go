@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	for email, expected := range map[string]string{
+		"spam@example.com":       "spam@example.com",
+		" Spam+1@Example.COM ":   "spam@example.com",
+		"s.p.a.m+news@gmail.com": "spam@gmail.com",
+		"S.P.A.M@GoogleMail.com": "spam@gmail.com",
+		"s.p.a.m@example.com":    "s.p.a.m@example.com",
+		"spam-1@yahoo.com":       "spam@yahoo.com",
+		"spam-1@example.com":     "spam-1@example.com",
+		"+spam@example.com":      "+spam@example.com",
+		"spam@bücher.example":    "spam@xn--bcher-kva.example",
+		"spam@mail.example.com.": "spam@mail.example.com",
+		"not an address":         "not an address",
+	} {
+		assert.Equal(t, expected, normalizeEmail(email), email)
+	}
+}
+
+func TestLocalPartHeuristics(t *testing.T) {
+	assert.Equal(t, 0.5, localPartDigitRatio("spam1234"))
+	assert.Zero(t, localPartDigitRatio(""))
+	assert.Zero(t, localPartEntropy("aaaaaaaa"))
+	assert.InDelta(t, 3, localPartEntropy("abcdefgh"), 0.001)
+
+	p, _ := newTestPlugin(t, &configuration{MaxEmailDigitRatio: "0.5", MaxEmailEntropy: "3.5"})
+
+	assert.NoError(t, p.checkSuspiciousEmail(&model.User{Email: "jane.doe@example.com"}))
+	assert.NoError(t, p.checkSuspiciousEmail(&model.User{Email: "jsmi1234@example.com"}), "half digits is allowed")
+	assert.NoError(t, p.checkSuspiciousEmail(&model.User{Email: "a1234@example.com"}), "short local parts are not scored")
+	assert.EqualError(t, p.checkSuspiciousEmail(&model.User{Email: "js8392017@example.com"}),
+		"email address is 78% digits: js8392017@example.com")
+	assert.EqualError(t, p.checkSuspiciousEmail(&model.User{Email: "qxvbnrtkzwplmh@example.com"}),
+		"email address looks random (3.81 bits of entropy per character): qxvbnrtkzwplmh@example.com")
+
+	for _, setting := range []string{"half", "0", "1.5"} {
+		assert.Error(t, parseEmailHeuristics(&configuration{MaxEmailDigitRatio: setting}), setting)
+	}
+}
+
+func TestBannedEmails(t *testing.T) {
+	newBanningPlugin := func(t *testing.T) (*Plugin, *MembershipMockAPI) {
+		return newTestPlugin(t, &configuration{BadUsernamesList: "spammer", EnableAuditLog: true})
+	}
+
+	t.Run("variants of banned addresses cannot sign up", func(t *testing.T) {
+		p, api := newBanningPlugin(t)
+		banned, err := p.banEmail("S.Pam+1@gmail.com", "spam", "mod")
+		require.NoError(t, err)
+		assert.Equal(t, "spam@gmail.com", banned.Email)
+
+		err = p.checkBannedEmail(&model.User{Email: "spam+2@googlemail.com"})
+		require.Error(t, err)
+		assert.Equal(t, "email address is banned as spam@gmail.com: spam+2@googlemail.com", err.Error())
+		assert.NoError(t, p.checkBannedEmail(&model.User{Email: "ham@gmail.com"}))
+
+		bans, err := p.listBannedEmails()
+		require.NoError(t, err)
+		require.Len(t, bans, 1)
+		assert.Equal(t, "mod", bans[0].BannedBy)
+		assert.JSONEq(t, `["spam@gmail.com"]`, string(api.kv[bannedEmailsKey]))
+
+		unbanned, err := p.unbanEmail("spam@gmail.com", "mod")
+		require.NoError(t, err)
+		assert.True(t, unbanned)
+		assert.NoError(t, p.checkBannedEmail(&model.User{Email: "spam+2@googlemail.com"}))
+		assert.JSONEq(t, `[]`, string(api.kv[bannedEmailsKey]))
+	})
+
+	t.Run("sanitized users are banned until restored", func(t *testing.T) {
+		p, api := newBanningPlugin(t)
+		p.configuration.BanSanitizedEmails = true
+		user := &model.User{Id: model.NewId(), Username: "spammer", Email: "spammer+1@example.com"}
+		api.users[user.Id] = user
+
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+		assert.Error(t, p.checkBannedEmail(&model.User{Email: "spammer+2@example.com"}))
+
+		_, err := p.restoreUser("mod", user.Id)
+		require.NoError(t, err)
+		assert.NoError(t, p.checkBannedEmail(&model.User{Email: "spammer+2@example.com"}))
+	})
+
+	t.Run("sanitized users are only banned if enabled", func(t *testing.T) {
+		p, api := newBanningPlugin(t)
+		user := &model.User{Id: model.NewId(), Username: "spammer", Email: "spammer+1@example.com"}
+		api.users[user.Id] = user
+
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+		assert.NotZero(t, api.users[user.Id].DeleteAt)
+		assert.NoError(t, p.checkBannedEmail(&model.User{Email: "spammer+2@example.com"}))
+	})
+
+	t.Run("domain rules match normalized addresses", func(t *testing.T) {
+		p, _ := newBanningPlugin(t)
+		require.NoError(t, p.createStoredRule(ruleKindDomains, &Rule{ID: "known-spammer", Pattern: "spam@gmail.com"}))
+
+		assert.Error(t, p.checkBadEmail(&model.User{Email: "s.p.a.m+offers@gmail.com"}))
+	})
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// FilterAction is the decision a PostFilter reaches about a post. Actions are ordered by
+// severity so that the strongest decision of several checks can be picked with a comparison.
+type FilterAction int
+
+const (
+	// FilterActionAllow lets the post through unchanged.
+	FilterActionAllow FilterAction = iota
+	// FilterActionCensor lets the post through with its message rewritten.
+	FilterActionCensor
+	// FilterActionHold withholds the post so that a moderator can review it.
+	FilterActionHold
+	// FilterActionReject refuses the post and warns its author.
+	FilterActionReject
+)
+
+func (a FilterAction) String() string {
+	switch a {
+	case FilterActionCensor:
+		return "censor"
+	case FilterActionHold:
+		return "hold"
+	case FilterActionReject:
+		return "reject"
+	default:
+		return "allow"
+	}
+}
+
+// FilterResult describes what a single PostFilter decided about a post.
+type FilterResult struct {
+	Action FilterAction
+
+	// Post is the rewritten copy of the post when Action is FilterActionCensor.
+	Post *model.Post
+
+	// Reason is handed back to the server when the post is rejected or held.
+	Reason string
+
+	// Warning, if set, is sent to the author of the post as an ephemeral message.
+	Warning string
+
+	// RejectWarning, if set, replaces Warning when a held post cannot be stored for review and
+	// is rejected instead.
+	RejectWarning string
+
+	// Matches lists the text that triggered the decision.
+	Matches []string
+
+	// Rules lists the rules that matched, if the filter is rule based, starting with the rule
+	// that decided the action.
+	Rules []*Rule
+}
+
+// allowPost is the result of a filter that has nothing to say about a post.
+func allowPost() *FilterResult {
+	return &FilterResult{Action: FilterActionAllow}
+}
+
+// PostFilter is a single check in the post filtering pipeline. Every enabled filter runs on
+// every post, in the order configured by the PostFilters setting, and sees the post as
+// rewritten by the filters before it.
+type PostFilter interface {
+	// Name identifies the filter in the PostFilters setting.
+	Name() string
+
+	// Filter inspects the post and decides what should happen to it. Filters must not modify
+	// the post themselves; censoring is applied by the pipeline from the returned result.
+	Filter(configuration *configuration, post *model.Post) *FilterResult
+}
+
+// The names of the built-in post filters.
+const (
+	directMessagesFilterName = "direct_messages"
+	badWordsFilterName       = "bad_words"
+)
+
+// The pipeline used when PostFilters is left empty.
+const defaultPostFilters = directMessagesFilterName + "," + badWordsFilterName
+
+// postFilters returns every filter the plugin knows about, keyed by name.
+func (p *Plugin) postFilters() map[string]PostFilter {
+	filters := map[string]PostFilter{}
+	for _, filter := range []PostFilter{
+		&directMessageFilter{p: p},
+		&badWordsFilter{p: p},
+	} {
+		filters[filter.Name()] = filter
+	}
+	return filters
+}
+
+// postFilterPipeline resolves the PostFilters setting into the ordered list of filters to run.
+// Unknown names are skipped here; they are reported when the configuration is loaded.
+func (p *Plugin) postFilterPipeline(configuration *configuration) []PostFilter {
+	available := p.postFilters()
+
+	var pipeline []PostFilter
+	for _, name := range splitFilterNames(configuration.PostFilters) {
+		if filter, ok := available[name]; ok {
+			pipeline = append(pipeline, filter)
+		}
+	}
+	return pipeline
+}
+
+// validatePostFilters checks that every name in the PostFilters setting refers to a known filter.
+func (p *Plugin) validatePostFilters(configuration *configuration) error {
+	available := p.postFilters()
+	for _, name := range splitFilterNames(configuration.PostFilters) {
+		if _, ok := available[name]; !ok {
+			return fmt.Errorf("unknown post filter: %q", name)
+		}
+	}
+	return nil
+}
+
+func splitFilterNames(list string) []string {
+	if strings.TrimSpace(list) == "" {
+		list = defaultPostFilters
+	}
+
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// enforceFilterResult carries out a filter decision, audits it and reports it to the moderators, unless
+// the feature runs in shadow mode. In shadow mode, the decision is only recorded and the post
+// passes unchanged.
+func (p *Plugin) enforceFilterResult(configuration *configuration, feature string, post *model.Post, result *FilterResult) (*model.Post, string) {
+	if result.Action != FilterActionAllow && isShadowed(configuration, feature) {
+		p.recordShadowFilterResult(feature, post, result)
+		p.auditFilterResult(feature, post, result, true)
+		p.notifyFilterResult(feature, post, result, true)
+		return post, ""
+	}
+
+	filtered, reason := p.applyFilterResult(post, result)
+	p.auditFilterResult(feature, post, result, false)
+	p.notifyFilterResult(feature, post, result, false)
+	return filtered, reason
+}
+
+// applyFilterResult carries out a filter decision on the post. It returns the post to pass on
+// and, if the post must not be published, a nil post and the reason.
+func (p *Plugin) applyFilterResult(post *model.Post, result *FilterResult) (*model.Post, string) {
+	switch result.Action {
+	case FilterActionAllow:
+		if len(result.Rules) > 0 {
+			p.API.LogWarn("Post matched alert rules",
+				"user_id", post.UserId,
+				"channel_id", post.ChannelId,
+				"rules", ruleIDs(result.Rules),
+				"matches", strings.Join(result.Matches, ", "),
+			)
+		}
+	case FilterActionCensor:
+		post = result.Post
+	case FilterActionHold, FilterActionReject:
+		if result.Action == FilterActionHold {
+			if err := p.holdPost(post, result); err != nil {
+				p.API.LogWarn("Failed to hold post for review, the post is rejected instead", "err", err.Error())
+				// Do not tell the author that a moderator will review the post.
+				result.Action = FilterActionReject
+				if result.RejectWarning != "" {
+					result.Warning = result.RejectWarning
+				}
+			}
+		}
+		if result.Warning != "" {
+			p.sendUserWarningForPost(post, result.Warning)
+		}
+		return nil, result.Reason
+	}
+	return post, ""
+}
+
+// directMessageFilter keeps new users from sending direct messages for a while.
+type directMessageFilter struct {
+	p *Plugin
+}
+
+func (f *directMessageFilter) Name() string {
+	return directMessagesFilterName
+}
+
+func (f *directMessageFilter) Filter(configuration *configuration, post *model.Post) *FilterResult {
+	if !configuration.BlockNewUserPM {
+		return allowPost()
+	}
+	// Fail open: a post is not worth losing to a transient API error.
+	isDirect, err := f.p.isDirectMessage(post.ChannelId)
+	if err != nil {
+		f.p.API.LogWarn("Failed to check whether post is a direct message", "channel_id", post.ChannelId, "err", err.Error())
+		return allowPost()
+	}
+	if !isDirect {
+		return allowPost()
+	}
+	return f.p.checkDirectMessage(configuration, post)
+}
+
+// badWordsFilter censors or rejects posts containing words from the bad words list.
+type badWordsFilter struct {
+	p *Plugin
+}
+
+func (f *badWordsFilter) Name() string {
+	return badWordsFilterName
+}
+
+func (f *badWordsFilter) Filter(configuration *configuration, post *model.Post) *FilterResult {
+	return f.p.checkPostBadWords(configuration, post)
+}
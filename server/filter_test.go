@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterPostPipeline(t *testing.T) {
+	newPipelinePlugin := func(t *testing.T, postFilters string, createAt int64) (*Plugin, *MembershipMockAPI) {
+		p, api := newTestPlugin(t, &configuration{
+			BadWordsList:       "abc",
+			BlockNewUserPM:     true,
+			BlockNewUserPMTime: "24h",
+			CensorCharacter:    "*",
+			PostFilters:        postFilters,
+		})
+		api.GetUserFunc = func(userID string) (*model.User, *model.AppError) {
+			return &model.User{Id: userID, CreateAt: createAt}, nil
+		}
+		return p, api
+	}
+	twoDaysAgo := model.GetMillis() - (48 * 60 * 60 * 1000)
+
+	t.Run("direct messages from established users are still checked for bad words", func(t *testing.T) {
+		p, _ := newPipelinePlugin(t, "", twoDaysAgo)
+
+		post := &model.Post{UserId: "user", ChannelId: "dm-channel", Message: "hello abc"}
+		rpost, reason := p.FilterPost(post)
+
+		assert.Empty(t, reason)
+		assert.Equal(t, "hello ***", rpost.Message)
+	})
+
+	t.Run("direct messages from new users are rejected before other filters", func(t *testing.T) {
+		p, _ := newPipelinePlugin(t, "", model.GetMillis())
+
+		post := &model.Post{UserId: "user", ChannelId: "dm-channel", Message: "hello abc"}
+		rpost, reason := p.FilterPost(post)
+
+		assert.Nil(t, rpost)
+		assert.Contains(t, reason, "New user not allowed to send DM")
+	})
+
+	t.Run("direct messages are allowed when the channel cannot be read", func(t *testing.T) {
+		p, api := newPipelinePlugin(t, "direct_messages", model.GetMillis())
+		api.GetChannelFunc = func(channelID string) (*model.Channel, *model.AppError) {
+			return nil, model.NewAppError("GetChannel", "unavailable", nil, "", http.StatusServiceUnavailable)
+		}
+
+		post := &model.Post{UserId: "user", ChannelId: "dm-channel", Message: "hello"}
+		rpost, reason := p.FilterPost(post)
+
+		assert.Empty(t, reason)
+		assert.Equal(t, post, rpost)
+		assert.Len(t, api.warnings, 1)
+	})
+
+	t.Run("only configured filters run", func(t *testing.T) {
+		p, _ := newPipelinePlugin(t, "bad_words", model.GetMillis())
+
+		post := &model.Post{UserId: "user", ChannelId: "dm-channel", Message: "hello abc"}
+		rpost, reason := p.FilterPost(post)
+
+		assert.Empty(t, reason)
+		assert.Equal(t, "hello ***", rpost.Message)
+	})
+
+	t.Run("filters run in the configured order", func(t *testing.T) {
+		p, _ := newPipelinePlugin(t, "bad_words, direct_messages", model.GetMillis())
+		names := []string{}
+		for _, filter := range p.postFilterPipeline(p.getConfiguration()) {
+			names = append(names, filter.Name())
+		}
+
+		assert.Equal(t, []string{"bad_words", "direct_messages"}, names)
+	})
+}
+
+func TestValidatePostFilters(t *testing.T) {
+	p := &Plugin{}
+
+	assert.NoError(t, p.validatePostFilters(&configuration{}))
+	assert.NoError(t, p.validatePostFilters(&configuration{PostFilters: "bad_words,direct_messages"}))
+
+	err := p.validatePostFilters(&configuration{PostFilters: "bad_words,nope"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "nope")
+}
+
+func TestWarningDelivery(t *testing.T) {
+	newWarningPlugin := func(t *testing.T, delivery string) (*Plugin, *MembershipMockAPI, *[]*model.Post) {
+		p, api := newTestPlugin(t, &configuration{
+			BadWordsList:    "abc",
+			RejectPosts:     true,
+			WarningMessage:  "Not allowed: %s",
+			PostFilters:     "bad_words",
+			WarningDelivery: delivery,
+		})
+
+		var ephemeral []*model.Post
+		api.GetChannelFunc = func(channelID string) (*model.Channel, *model.AppError) {
+			return &model.Channel{Id: channelID, DisplayName: "Town Square", Type: model.ChannelTypeOpen}, nil
+		}
+		api.SendEphemeralPostFunc = func(userID string, post *model.Post) *model.Post {
+			ephemeral = append(ephemeral, post)
+			return post
+		}
+		return p, api, &ephemeral
+	}
+
+	t.Run("ephemeral warnings are sent as the bot", func(t *testing.T) {
+		p, api, ephemeral := newWarningPlugin(t, warningDeliveryEphemeral)
+
+		rpost, _ := p.FilterPost(&model.Post{UserId: "author", ChannelId: "channel", Message: "abc"})
+		assert.Nil(t, rpost)
+		assert.Len(t, *ephemeral, 1)
+		assert.Equal(t, "bot", (*ephemeral)[0].UserId)
+		assert.Equal(t, "Not allowed: abc", (*ephemeral)[0].Message)
+		assert.Empty(t, api.posts)
+	})
+
+	t.Run("direct message warnings quote the post", func(t *testing.T) {
+		p, api, ephemeral := newWarningPlugin(t, warningDeliveryDirectMessage)
+
+		rpost, _ := p.FilterPost(&model.Post{UserId: "author", ChannelId: "channel", Message: "abc"})
+		assert.Nil(t, rpost)
+		assert.Empty(t, *ephemeral)
+
+		assert.Len(t, api.posts, 1)
+		for _, warning := range api.posts {
+			assert.Equal(t, "bot", warning.UserId)
+			assert.Equal(t, "dm_author_bot", warning.ChannelId)
+			assert.Equal(t, "Not allowed: abc", warning.Message)
+			assert.Equal(t, "abc", warning.Attachments()[0].Text)
+			assert.Equal(t, "Posted in Town Square", warning.Attachments()[0].Footer)
+		}
+	})
+
+	t.Run("falls back to ephemeral messages without a bot", func(t *testing.T) {
+		p, _, ephemeral := newWarningPlugin(t, warningDeliveryDirectMessage)
+		p.botUserID = ""
+
+		p.FilterPost(&model.Post{UserId: "author", ChannelId: "channel", Message: "abc"})
+		assert.Len(t, *ephemeral, 1)
+	})
+}
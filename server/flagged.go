@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// flaggedUsersKey is the KV store key of the flagged users, keyed by user id. Keeping
+	// them under one key lists them without going through every key of the plugin.
+	flaggedUsersKey = "flagged_users"
+
+	// featureFlaggedUsers is the audit log feature of flagging users.
+	featureFlaggedUsers = "flagged_users"
+)
+
+// FlaggedUser is a user that moderators keep an eye on. A flag is an annotation for the
+// moderators: it does not restrict the user, who can be quarantined or deactivated for that.
+type FlaggedUser struct {
+	UserID    string `json:"user_id"`
+	Reason    string `json:"reason,omitempty"`
+	FlaggedBy string `json:"flagged_by"`
+	FlaggedAt int64  `json:"flagged_at"`
+}
+
+// flagUser flags a user, or updates the reason of a user that is already flagged.
+func (p *Plugin) flagUser(userID, reason, actor string) (*FlaggedUser, error) {
+	flagged := &FlaggedUser{
+		UserID:    userID,
+		Reason:    reason,
+		FlaggedBy: actor,
+		FlaggedAt: model.GetMillis(),
+	}
+	if err := p.updateFlaggedUsers(func(flaggedUsers map[string]*FlaggedUser) bool {
+		flaggedUsers[userID] = flagged
+		return true
+	}); err != nil {
+		return nil, err
+	}
+
+	p.audit(&AuditRecord{
+		Actor:   actor,
+		UserID:  userID,
+		Feature: featureFlaggedUsers,
+		Action:  "flag",
+		Excerpt: excerpt(reason),
+	})
+	return flagged, nil
+}
+
+// unflagUser removes the flag of a user. It returns false if the user was not flagged.
+func (p *Plugin) unflagUser(userID, actor string) (bool, error) {
+	unflagged := false
+	if err := p.updateFlaggedUsers(func(flaggedUsers map[string]*FlaggedUser) bool {
+		_, unflagged = flaggedUsers[userID]
+		delete(flaggedUsers, userID)
+		return unflagged
+	}); err != nil || !unflagged {
+		return false, err
+	}
+
+	p.audit(&AuditRecord{
+		Actor:   actor,
+		UserID:  userID,
+		Feature: featureFlaggedUsers,
+		Action:  "unflag",
+	})
+	return true, nil
+}
+
+// getFlaggedUser returns the flag of a user, or nil if the user is not flagged.
+func (p *Plugin) getFlaggedUser(userID string) (*FlaggedUser, error) {
+	flaggedUsers, _, err := p.loadFlaggedUsers()
+	if err != nil {
+		return nil, err
+	}
+	return flaggedUsers[userID], nil
+}
+
+// listFlaggedUsers returns every flagged user, most recently flagged first.
+func (p *Plugin) listFlaggedUsers() ([]*FlaggedUser, error) {
+	flaggedUsers, _, err := p.loadFlaggedUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*FlaggedUser, 0, len(flaggedUsers))
+	for _, flagged := range flaggedUsers {
+		list = append(list, flagged)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].FlaggedAt > list[j].FlaggedAt
+	})
+	return list, nil
+}
+
+// loadFlaggedUsers returns the flagged users, keyed by user id, and their stored value.
+func (p *Plugin) loadFlaggedUsers() (map[string]*FlaggedUser, []byte, error) {
+	data, appErr := p.API.KVGet(flaggedUsersKey)
+	if appErr != nil {
+		return nil, nil, errors.Wrap(appErr, "failed to load flagged users")
+	}
+	flaggedUsers := map[string]*FlaggedUser{}
+	if data == nil {
+		return flaggedUsers, nil, nil
+	}
+	if err := json.Unmarshal(data, &flaggedUsers); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to decode flagged users")
+	}
+	return flaggedUsers, data, nil
+}
+
+// updateFlaggedUsers applies an update to the flagged users, retrying when they are changed
+// concurrently. The update returns false when it leaves them unchanged.
+func (p *Plugin) updateFlaggedUsers(update func(map[string]*FlaggedUser) bool) error {
+	_, _, err := updateKVJSON(p.API, flaggedUsersKey, "flagged users", p.loadFlaggedUsers,
+		func(flaggedUsers map[string]*FlaggedUser) (map[string]*FlaggedUser, bool, error) {
+			return flaggedUsers, update(flaggedUsers), nil
+		})
+	return err
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPlugin returns a plugin set up from the configuration the way the server sets it up,
+// on top of the in-memory MembershipMockAPI. Tests add users, rules and fakes to the API.
+func newTestPlugin(t *testing.T, config *configuration) (*Plugin, *MembershipMockAPI) {
+	api := NewMembershipMockAPI()
+	api.configuration = config
+	p := &Plugin{botUserID: "bot"}
+	p.SetAPI(api)
+	require.NoError(t, p.loadStoredRules())
+	require.NoError(t, p.OnConfigurationChange())
+	require.NoError(t, p.loadDomainLists())
+	return p, api
+}
+
+// LogMockAPI records the warnings and infos that are logged.
+type LogMockAPI struct {
+	ExtendedMockAPI
+	warnings []string
+	infos    []map[string]any
+}
+
+func (m *LogMockAPI) LogInfo(msg string, keyValuePairs ...any) {
+	fields := map[string]any{"msg": msg}
+	for i := 0; i+1 < len(keyValuePairs); i += 2 {
+		fields[keyValuePairs[i].(string)] = keyValuePairs[i+1]
+	}
+	m.infos = append(m.infos, fields)
+}
+
+func (m *LogMockAPI) LogWarn(msg string, keyValuePairs ...any) {
+	m.warnings = append(m.warnings, msg)
+}
+
+// KVMockAPI keeps the KV store and the created posts in memory.
+type KVMockAPI struct {
+	LogMockAPI
+	kv             map[string][]byte
+	posts          map[string]*model.Post
+	admins         map[string]bool
+	CreatePostFunc func(post *model.Post) (*model.Post, *model.AppError)
+	// KVCompareAndSetFunc, if set, replaces KVCompareAndSet, e.g. to change a key concurrently.
+	KVCompareAndSetFunc func(key string, oldValue, newValue []byte) (bool, *model.AppError)
+	// expiries records the expiry of the keys set with options, in seconds.
+	expiries map[string]int64
+}
+
+func NewKVMockAPI() *KVMockAPI {
+	return &KVMockAPI{
+		kv:       map[string][]byte{},
+		posts:    map[string]*model.Post{},
+		admins:   map[string]bool{},
+		expiries: map[string]int64{},
+	}
+}
+
+func (m *KVMockAPI) KVSet(key string, value []byte) *model.AppError {
+	m.kv[key] = value
+	return nil
+}
+
+func (m *KVMockAPI) KVGet(key string) ([]byte, *model.AppError) {
+	return m.kv[key], nil
+}
+
+func (m *KVMockAPI) KVDelete(key string) *model.AppError {
+	delete(m.kv, key)
+	return nil
+}
+
+func (m *KVMockAPI) KVCompareAndDelete(key string, oldValue []byte) (bool, *model.AppError) {
+	if current, ok := m.kv[key]; !ok || !bytes.Equal(current, oldValue) {
+		return false, nil
+	}
+	delete(m.kv, key)
+	return true, nil
+}
+
+func (m *KVMockAPI) KVCompareAndSet(key string, oldValue, newValue []byte) (bool, *model.AppError) {
+	if m.KVCompareAndSetFunc != nil {
+		return m.KVCompareAndSetFunc(key, oldValue, newValue)
+	}
+	if current, ok := m.kv[key]; ok != (oldValue != nil) || !bytes.Equal(current, oldValue) {
+		return false, nil
+	}
+	m.kv[key] = newValue
+	return true, nil
+}
+
+func (m *KVMockAPI) KVSetWithOptions(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+	if options.Atomic {
+		if current, ok := m.kv[key]; ok != (options.OldValue != nil) || !bytes.Equal(current, options.OldValue) {
+			return false, nil
+		}
+	}
+	m.kv[key] = value
+	m.expiries[key] = options.ExpireInSeconds
+	return true, nil
+}
+
+func (m *KVMockAPI) KVList(page, perPage int) ([]string, *model.AppError) {
+	keys := make([]string, 0, len(m.kv))
+	for key := range m.kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	start, end := page*perPage, (page+1)*perPage
+	if start > len(keys) {
+		return []string{}, nil
+	}
+	if end > len(keys) {
+		end = len(keys)
+	}
+	return keys[start:end], nil
+}
+
+func (m *KVMockAPI) CreatePost(post *model.Post) (*model.Post, *model.AppError) {
+	if m.CreatePostFunc != nil {
+		return m.CreatePostFunc(post)
+	}
+	created := post.Clone()
+	created.Id = model.NewId()
+	m.posts[created.Id] = created
+	return created, nil
+}
+
+func (m *KVMockAPI) GetPost(postID string) (*model.Post, *model.AppError) {
+	if post, ok := m.posts[postID]; ok {
+		return post.Clone(), nil
+	}
+	return nil, model.NewAppError("GetPost", "not_found", nil, "", http.StatusNotFound)
+}
+
+func (m *KVMockAPI) UpdatePost(post *model.Post) (*model.Post, *model.AppError) {
+	if _, ok := m.posts[post.Id]; !ok {
+		return nil, model.NewAppError("UpdatePost", "not_found", nil, "", http.StatusNotFound)
+	}
+	m.posts[post.Id] = post.Clone()
+	return post, nil
+}
+
+func (m *KVMockAPI) DeletePost(postID string) *model.AppError {
+	delete(m.posts, postID)
+	return nil
+}
+
+func (m *KVMockAPI) HasPermissionTo(userID string, permission *model.Permission) bool {
+	return m.admins[userID]
+}
+
+func (m *KVMockAPI) LogError(msg string, keyValuePairs ...any) {
+	m.warnings = append(m.warnings, msg)
+}
+
+func (m *KVMockAPI) PublishPluginClusterEvent(ev model.PluginClusterEvent, opts model.PluginClusterEventSendOptions) error {
+	return nil
+}
+
+func (m *KVMockAPI) GetTeam(teamID string) (*model.Team, *model.AppError) {
+	return &model.Team{Id: teamID, Name: "community"}, nil
+}
+
+func (m *KVMockAPI) GetConfig() *model.Config {
+	config := &model.Config{}
+	config.SetDefaults()
+	config.ServiceSettings.SiteURL = model.NewString("https://chat.example.com/")
+	return config
+}
+
+func (m *KVMockAPI) GetDirectChannel(userID1, userID2 string) (*model.Channel, *model.AppError) {
+	return &model.Channel{Id: "dm_" + userID1 + "_" + userID2, Type: model.ChannelTypeDirect}, nil
+}
+
+// MembershipMockAPI keeps users and their team and channel memberships in memory.
+type MembershipMockAPI struct {
+	*KVMockAPI
+	// configuration is loaded by LoadPluginConfiguration.
+	configuration *configuration
+	users         map[string]*model.User
+	teamRoles     map[string]string
+	channelRoles  map[string]string
+	// channelTeams maps the channels to the teams they belong to.
+	channelTeams map[string]string
+	// failChannels lists the channels that cannot be rejoined.
+	failChannels map[string]bool
+	// removedBy lists the users team members were removed by.
+	removedBy []string
+	// failUserUpdates is the number of user updates left to fail.
+	failUserUpdates int
+	// failActivations is the number of user activations and deactivations left to fail.
+	failActivations int
+}
+
+func NewMembershipMockAPI() *MembershipMockAPI {
+	api := &MembershipMockAPI{
+		KVMockAPI:    NewKVMockAPI(),
+		users:        map[string]*model.User{},
+		teamRoles:    map[string]string{},
+		channelRoles: map[string]string{},
+		channelTeams: map[string]string{},
+		failChannels: map[string]bool{},
+	}
+	api.GetUserFunc = func(userID string) (*model.User, *model.AppError) {
+		if user, ok := api.users[userID]; ok {
+			clone := *user
+			return &clone, nil
+		}
+		return nil, model.NewAppError("GetUser", "not_found", nil, "", http.StatusNotFound)
+	}
+	return api
+}
+
+func (m *MembershipMockAPI) LoadPluginConfiguration(dest any) error {
+	if m.configuration != nil {
+		*dest.(*configuration) = *m.configuration
+	}
+	return nil
+}
+
+func (m *MembershipMockAPI) UpdateUser(user *model.User) (*model.User, *model.AppError) {
+	if m.failUserUpdates > 0 {
+		m.failUserUpdates--
+		return nil, model.NewAppError("UpdateUser", "unavailable", nil, "", http.StatusServiceUnavailable)
+	}
+	clone := *user
+	m.users[user.Id] = &clone
+	return user, nil
+}
+
+func (m *MembershipMockAPI) UpdateUserActive(userID string, active bool) *model.AppError {
+	if m.failActivations > 0 {
+		m.failActivations--
+		return model.NewAppError("UpdateUserActive", "unavailable", nil, "", http.StatusServiceUnavailable)
+	}
+	m.users[userID].DeleteAt = 0
+	if !active {
+		m.users[userID].DeleteAt = model.GetMillis()
+	}
+	return nil
+}
+
+func (m *MembershipMockAPI) DeleteUser(userID string) *model.AppError {
+	return m.UpdateUserActive(userID, false)
+}
+
+func (m *MembershipMockAPI) GetTeamsForUser(userID string) ([]*model.Team, *model.AppError) {
+	var teams []*model.Team
+	for teamID := range m.teamRoles {
+		teams = append(teams, &model.Team{Id: teamID})
+	}
+	return teams, nil
+}
+
+func (m *MembershipMockAPI) GetTeamMember(teamID, userID string) (*model.TeamMember, *model.AppError) {
+	roles, ok := m.teamRoles[teamID]
+	if !ok {
+		return nil, model.NewAppError("GetTeamMember", "not_found", nil, "", http.StatusNotFound)
+	}
+	return &model.TeamMember{TeamId: teamID, UserId: userID, Roles: roles}, nil
+}
+
+func (m *MembershipMockAPI) CreateTeamMember(teamID, userID string) (*model.TeamMember, *model.AppError) {
+	m.teamRoles[teamID] = model.TeamUserRoleId
+	return &model.TeamMember{TeamId: teamID, UserId: userID}, nil
+}
+
+func (m *MembershipMockAPI) UpdateTeamMemberRoles(teamID, userID, newRoles string) (*model.TeamMember, *model.AppError) {
+	m.teamRoles[teamID] = newRoles
+	return &model.TeamMember{TeamId: teamID, UserId: userID, Roles: newRoles}, nil
+}
+
+func (m *MembershipMockAPI) GetUserByUsername(username string) (*model.User, *model.AppError) {
+	for _, user := range m.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return nil, model.NewAppError("GetUserByUsername", "not_found", nil, "", http.StatusNotFound)
+}
+
+func (m *MembershipMockAPI) DeleteTeamMember(teamID, userID, requestorID string) *model.AppError {
+	m.removedBy = append(m.removedBy, requestorID)
+	delete(m.teamRoles, teamID)
+	for channelID, channelTeamID := range m.channelTeams {
+		if channelTeamID == teamID {
+			delete(m.channelRoles, channelID)
+		}
+	}
+	return nil
+}
+
+func (m *MembershipMockAPI) GetChannelsForTeamForUser(teamID, userID string, includeDeleted bool) ([]*model.Channel, *model.AppError) {
+	var channels []*model.Channel
+	for channelID := range m.channelRoles {
+		if m.channelTeams[channelID] == teamID {
+			channels = append(channels, &model.Channel{Id: channelID, TeamId: teamID, Type: model.ChannelTypeOpen})
+		}
+	}
+	return channels, nil
+}
+
+func (m *MembershipMockAPI) GetChannelMember(channelID, userID string) (*model.ChannelMember, *model.AppError) {
+	roles, ok := m.channelRoles[channelID]
+	if !ok {
+		return nil, model.NewAppError("GetChannelMember", "not_found", nil, "", http.StatusNotFound)
+	}
+	return &model.ChannelMember{ChannelId: channelID, UserId: userID, Roles: roles}, nil
+}
+
+func (m *MembershipMockAPI) DeleteChannelMember(channelID, userID string) *model.AppError {
+	delete(m.channelRoles, channelID)
+	return nil
+}
+
+func (m *MembershipMockAPI) AddChannelMember(channelID, userID string) (*model.ChannelMember, *model.AppError) {
+	if m.failChannels[channelID] {
+		return nil, model.NewAppError("AddChannelMember", "archived", nil, "", http.StatusBadRequest)
+	}
+	m.channelRoles[channelID] = model.ChannelUserRoleId
+	return &model.ChannelMember{ChannelId: channelID, UserId: userID}, nil
+}
+
+func (m *MembershipMockAPI) UpdateChannelMemberRoles(channelID, userID, newRoles string) (*model.ChannelMember, *model.AppError) {
+	m.channelRoles[channelID] = newRoles
+	return &model.ChannelMember{ChannelId: channelID, UserId: userID, Roles: newRoles}, nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// heldPostKeyPrefix prefixes the KV store keys of posts waiting for review.
+	heldPostKeyPrefix = "held_post_"
+
+	// approvalProp marks a held post that is republished after approval. Its value is a
+	// one-time token, so that users cannot skip the filters by setting the prop themselves.
+	approvalProp = "community_toolkit_approval"
+
+	holdDecisionApprove = "approve"
+	holdDecisionDeny    = "deny"
+)
+
+// HeldPost is a post withheld by the filters until a moderator approves or denies it.
+type HeldPost struct {
+	ID           string      `json:"id"`
+	Post         *model.Post `json:"post"`
+	Reason       string      `json:"reason"`
+	Rules        []string    `json:"rules,omitempty"`
+	Matches      []string    `json:"matches,omitempty"`
+	HeldAt       int64       `json:"held_at"`
+	ReviewPostID string      `json:"review_post_id"`
+	// EditedPostID is the id of the published post, if the held post is an edit of it.
+	EditedPostID string `json:"edited_post_id,omitempty"`
+}
+
+func heldPostKey(id string) string {
+	return heldPostKeyPrefix + id
+}
+
+// validateHoldActions checks that held posts can be reviewed: every setting and rule that
+// holds posts needs the moderation channel.
+func (p *Plugin) validateHoldActions(configuration *configuration, wordRules []*compiledRule) error {
+	if configuration.ModerationChannelID != "" {
+		return nil
+	}
+
+	var holding []string
+	for _, rule := range append(p.getStoredRules(ruleKindWords), wordRules...) {
+		if rule.Action == RuleActionHold {
+			holding = append(holding, "rule "+rule.ID)
+		}
+	}
+	// Invalid regions are reported before the hold actions are checked.
+	policies, _ := parseMarkdownRegions(configuration.SkipMarkdownRegions)
+	for _, region := range markdownRegionKinds {
+		if policies[region] == regionPolicyHold {
+			holding = append(holding, "markdown region "+region)
+		}
+	}
+	if configuration.QuarantinePosts == quarantinePostsHold {
+		holding = append(holding, "quarantine posts")
+	}
+
+	if len(holding) > 0 {
+		return fmt.Errorf("a moderation channel is needed to review the posts held by %s", strings.Join(holding, ", "))
+	}
+	return nil
+}
+
+// holdPost stores the post for review and asks the moderators to review it in the moderation
+// channel.
+func (p *Plugin) holdPost(post *model.Post, result *FilterResult) error {
+	channelID := p.getConfiguration().ModerationChannelID
+	if channelID == "" {
+		return errors.New("no moderation channel is configured to review held posts")
+	}
+
+	held := &HeldPost{
+		ID:      model.NewId(),
+		Post:    post.Clone(),
+		Reason:  result.Reason,
+		Matches: result.Matches,
+		HeldAt:  model.GetMillis(),
+	}
+	for _, rule := range result.Rules {
+		held.Rules = append(held.Rules, rule.ID)
+	}
+	// Edits carry the id of the post they change, new posts get theirs when they are saved.
+	if post.Id != "" {
+		if _, appErr := p.API.GetPost(post.Id); appErr == nil {
+			held.EditedPostID = post.Id
+		}
+	}
+
+	reviewPost, appErr := p.API.CreatePost(p.reviewPost(channelID, held))
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to post the review request")
+	}
+	held.ReviewPostID = reviewPost.Id
+
+	if err := p.storeHeldPost(held); err != nil {
+		_ = p.API.DeletePost(reviewPost.Id)
+		return err
+	}
+	return nil
+}
+
+// reviewPost builds the post that asks the moderators to approve or deny a held post.
+func (p *Plugin) reviewPost(channelID string, held *HeldPost) *model.Post {
+	fields := []*model.SlackAttachmentField{
+		{Title: "Reason", Value: held.Reason},
+	}
+	if len(held.Rules) > 0 {
+		fields = append(fields, &model.SlackAttachmentField{Title: "Rules", Value: strings.Join(held.Rules, ", "), Short: true})
+	}
+
+	// Show every text the filters read, not only the message.
+	for _, text := range postTexts(held.Post.Clone())[1:] {
+		fields = append(fields, &model.SlackAttachmentField{Title: text.field, Value: text.text})
+	}
+
+	what := "A post"
+	if held.EditedPostID != "" {
+		what = "An edit of a post"
+	}
+	reviewPost := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channelID,
+		Message: fmt.Sprintf("%s by %s in %s has been held for review.",
+			what, p.userMention(held.Post.UserId), p.channelName(held.Post.ChannelId)),
+	}
+	model.ParseSlackAttachment(reviewPost, []*model.SlackAttachment{{
+		Text:   held.Post.Message,
+		Fields: fields,
+		Actions: []*model.PostAction{
+			decisionAction("held/"+held.ID, holdDecisionApprove, "Approve", "good"),
+			decisionAction("held/"+held.ID, holdDecisionDeny, "Deny", "danger"),
+		},
+	}})
+	return reviewPost
+}
+
+// decisionAction builds a button of a review post, which posts to /api/v1/{path}/{decision}.
+func decisionAction(path, decision, name, style string) *model.PostAction {
+	return &model.PostAction{
+		Id:    decision,
+		Type:  model.PostActionTypeButton,
+		Name:  name,
+		Style: style,
+		Integration: &model.PostActionIntegration{
+			URL: fmt.Sprintf("/plugins/%s/api/v1/%s/%s", manifest.Id, path, decision),
+		},
+	}
+}
+
+func (p *Plugin) storeHeldPost(held *HeldPost) error {
+	data, err := json.Marshal(held)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode held post")
+	}
+	if appErr := p.API.KVSet(heldPostKey(held.ID), data); appErr != nil {
+		return errors.Wrap(appErr, "failed to store held post")
+	}
+	return nil
+}
+
+// takeHeldPost removes a held post from the store and returns it. It returns nil if the post
+// does not exist, for instance because another moderator already reviewed it.
+func (p *Plugin) takeHeldPost(id string) (*HeldPost, error) {
+	data, appErr := p.API.KVGet(heldPostKey(id))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to load held post")
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	var held HeldPost
+	if err := json.Unmarshal(data, &held); err != nil {
+		return nil, errors.Wrap(err, "failed to decode held post")
+	}
+
+	deleted, appErr := p.API.KVCompareAndDelete(heldPostKey(id), data)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to remove held post")
+	}
+	if !deleted {
+		return nil, nil
+	}
+	return &held, nil
+}
+
+// reviewHeldPost carries out a moderator's decision about a held post. Approved posts are
+// published as their author in their original channel and thread, and approved edits are
+// applied to the post they change. It returns the updated review post, or nil if the post was
+// already reviewed.
+func (p *Plugin) reviewHeldPost(id, decision, moderatorID string) (*model.Post, error) {
+	held, err := p.takeHeldPost(id)
+	if err != nil || held == nil {
+		return nil, err
+	}
+
+	outcome := "Denied"
+	if decision == holdDecisionApprove {
+		outcome = "Approved"
+		if err := p.publishApprovedPost(held); err != nil {
+			// Put the post back so that it can be reviewed again.
+			_ = p.storeHeldPost(held)
+			return nil, err
+		}
+	}
+
+	p.audit(&AuditRecord{
+		Actor:     moderatorID,
+		UserID:    held.Post.UserId,
+		ChannelID: held.Post.ChannelId,
+		Feature:   "hold_queue",
+		Action:    decision,
+		Rules:     held.Rules,
+		Excerpt:   excerpt(held.Post.Message),
+	})
+
+	return p.decidedReviewPost(held.ReviewPostID, outcome, moderatorID)
+}
+
+// decidedReviewPost returns the review post with its buttons replaced by the decision.
+func (p *Plugin) decidedReviewPost(reviewPostID, outcome, moderatorID string) (*model.Post, error) {
+	reviewPost, appErr := p.API.GetPost(reviewPostID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to load review post")
+	}
+
+	attachments := copyAttachments(reviewPost.Attachments())
+	for _, attachment := range attachments {
+		attachment.Actions = nil
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+			Title: "Decision",
+			Value: fmt.Sprintf("%s by %s", outcome, p.userMention(moderatorID)),
+		})
+	}
+	model.ParseSlackAttachment(reviewPost, attachments)
+	return reviewPost, nil
+}
+
+// publishApprovedPost publishes a held post as its original author, or applies a held edit to
+// the post it changes. The post carries a one-time approval token that lets it through the
+// filters.
+func (p *Plugin) publishApprovedPost(held *HeldPost) error {
+	token := model.NewId()
+	p.approvals.Store(token, true)
+	defer p.approvals.Delete(token)
+
+	post := held.Post
+	if held.EditedPostID != "" {
+		edited, appErr := p.API.GetPost(held.EditedPostID)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to load edited post")
+		}
+		edited.Message = post.Message
+		edited.FileIds = post.FileIds
+		edited.SetProps(post.GetProps())
+		edited.AddProp(approvalProp, token)
+
+		if _, appErr := p.API.UpdatePost(edited); appErr != nil {
+			return errors.Wrap(appErr, "failed to apply approved edit")
+		}
+		return nil
+	}
+
+	approved := &model.Post{
+		UserId:    post.UserId,
+		ChannelId: post.ChannelId,
+		RootId:    post.RootId,
+		Message:   post.Message,
+		FileIds:   post.FileIds,
+	}
+	approved.SetProps(post.GetProps())
+	approved.AddProp(approvalProp, token)
+
+	if _, appErr := p.API.CreatePost(approved); appErr != nil {
+		return errors.Wrap(appErr, "failed to publish approved post")
+	}
+	return nil
+}
+
+// isApprovedPost reports whether the post is a held post that a moderator approved, and
+// removes the approval token from it.
+func (p *Plugin) isApprovedPost(post *model.Post) bool {
+	token, ok := post.GetProp(approvalProp).(string)
+	if !ok {
+		return false
+	}
+	post.DelProp(approvalProp)
+	_, approved := p.approvals.LoadAndDelete(token)
+	return approved
+}
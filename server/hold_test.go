@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHoldQueue(t *testing.T) {
+	newHoldPlugin := func(t *testing.T) (*Plugin, *MembershipMockAPI) {
+		p, api := newTestPlugin(t, &configuration{
+			CensorCharacter:     "*",
+			PostFilters:         "bad_words",
+			ModerationChannelID: "moderation",
+			Moderators:          "mod",
+			WordRules:           `[{"id": "spam", "pattern": "buy now", "action": "hold"}]`,
+		})
+		api.GetUserFunc = func(userID string) (*model.User, *model.AppError) {
+			return &model.User{Id: userID, Username: userID}, nil
+		}
+		api.GetChannelFunc = func(channelID string) (*model.Channel, *model.AppError) {
+			return &model.Channel{Id: channelID, DisplayName: "Town Square", Type: model.ChannelTypeOpen}, nil
+		}
+		return p, api
+	}
+
+	// holdPost posts a message that is held and returns the id of the held post.
+	holdPost := func(t *testing.T, p *Plugin, api *MembershipMockAPI) (string, *model.Post) {
+		rpost, reason := p.MessageWillBePosted(&plugin.Context{}, &model.Post{
+			UserId:    "author",
+			ChannelId: "channel",
+			RootId:    "thread",
+			Message:   "buy now!",
+		})
+		require.Nil(t, rpost)
+		require.Contains(t, reason, "held for review")
+
+		require.Len(t, api.kv, 1)
+		require.Len(t, api.posts, 1)
+		var reviewPost *model.Post
+		for _, post := range api.posts {
+			reviewPost = post
+		}
+		for key := range api.kv {
+			return strings.TrimPrefix(key, heldPostKeyPrefix), reviewPost
+		}
+		return "", nil
+	}
+
+	decide := func(p *Plugin, heldPostID, decision, userID string) *model.PostActionIntegrationResponse {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/held/"+heldPostID+"/"+decision, strings.NewReader("{}"))
+		r.Header.Set("Mattermost-User-Id", userID)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(&plugin.Context{}, w, r)
+
+		var response model.PostActionIntegrationResponse
+		_ = json.NewDecoder(w.Body).Decode(&response)
+		return &response
+	}
+
+	t.Run("held posts are stored and sent to the moderation channel", func(t *testing.T) {
+		p, api := newHoldPlugin(t)
+		_, reviewPost := holdPost(t, p, api)
+
+		assert.Equal(t, "moderation", reviewPost.ChannelId)
+		assert.Equal(t, "bot", reviewPost.UserId)
+		assert.Contains(t, reviewPost.Message, "@author")
+		require.Len(t, reviewPost.Attachments(), 1)
+		assert.Equal(t, "buy now!", reviewPost.Attachments()[0].Text)
+		assert.Len(t, reviewPost.Attachments()[0].Actions, 2)
+	})
+
+	t.Run("review posts show every filtered text", func(t *testing.T) {
+		p, api := newHoldPlugin(t)
+
+		in := &model.Post{UserId: "author", ChannelId: "channel", Message: "see below"}
+		in.AddProp("attachments", []*model.SlackAttachment{{Title: "buy now!"}})
+		rpost, _ := p.MessageWillBePosted(&plugin.Context{}, in)
+		require.Nil(t, rpost)
+
+		require.Len(t, api.posts, 1)
+		for _, reviewPost := range api.posts {
+			attachment := reviewPost.Attachments()[0]
+			assert.Equal(t, "see below", attachment.Text)
+			assert.Equal(t, "attachments[0].title", attachment.Fields[len(attachment.Fields)-1].Title)
+			assert.Equal(t, "buy now!", attachment.Fields[len(attachment.Fields)-1].Value)
+		}
+	})
+
+	t.Run("approved edits update the original post", func(t *testing.T) {
+		p, api := newHoldPlugin(t)
+		original := &model.Post{Id: model.NewId(), UserId: "author", ChannelId: "channel", Message: "hello"}
+		api.posts[original.Id] = original
+
+		edit := original.Clone()
+		edit.Message = "buy now!"
+		rpost, reason := p.MessageWillBeUpdated(&plugin.Context{}, edit, original)
+		require.Nil(t, rpost)
+		require.Contains(t, reason, "held for review")
+		require.Len(t, api.kv, 1)
+		var heldPostID string
+		for key := range api.kv {
+			heldPostID = strings.TrimPrefix(key, heldPostKeyPrefix)
+		}
+
+		decide(p, heldPostID, holdDecisionApprove, "mod")
+		require.Len(t, api.posts, 2, "no new post is created")
+		assert.Equal(t, "buy now!", api.posts[original.Id].Message)
+	})
+
+	t.Run("posts that cannot be held are rejected with the reject warning", func(t *testing.T) {
+		p, api := newHoldPlugin(t)
+		api.CreatePostFunc = func(post *model.Post) (*model.Post, *model.AppError) {
+			return nil, model.NewAppError("CreatePost", "unavailable", nil, "", http.StatusServiceUnavailable)
+		}
+		var warning string
+		api.SendEphemeralPostFunc = func(userID string, post *model.Post) *model.Post {
+			warning = post.Message
+			return post
+		}
+
+		rpost, _ := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "author", ChannelId: "channel", Message: "buy now!"})
+		assert.Nil(t, rpost)
+		assert.NotEmpty(t, warning)
+		assert.NotContains(t, warning, "review")
+	})
+
+	t.Run("approved posts are republished as their author", func(t *testing.T) {
+		p, api := newHoldPlugin(t)
+		heldPostID, reviewPost := holdPost(t, p, api)
+
+		var republished *model.Post
+		api.CreatePostFunc = func(post *model.Post) (*model.Post, *model.AppError) {
+			// The server runs the hooks on the republished post too.
+			republished, _ = p.MessageWillBePosted(&plugin.Context{}, post)
+			return republished, nil
+		}
+
+		response := decide(p, heldPostID, holdDecisionApprove, "mod")
+		require.NotNil(t, republished)
+		assert.Equal(t, "author", republished.UserId)
+		assert.Equal(t, "channel", republished.ChannelId)
+		assert.Equal(t, "thread", republished.RootId)
+		assert.Equal(t, "buy now!", republished.Message)
+		assert.Nil(t, republished.GetProp(approvalProp))
+
+		require.NotNil(t, response.Update)
+		assert.Equal(t, reviewPost.Id, response.Update.Id)
+		attachment := response.Update.Attachments()[0]
+		assert.Empty(t, attachment.Actions)
+		assert.Equal(t, "Approved by @mod", attachment.Fields[len(attachment.Fields)-1].Value)
+		assert.Empty(t, api.kv)
+
+		assert.Contains(t, decide(p, heldPostID, holdDecisionApprove, "mod").EphemeralText, "already been reviewed")
+	})
+
+	t.Run("denied posts are dropped", func(t *testing.T) {
+		p, api := newHoldPlugin(t)
+		heldPostID, _ := holdPost(t, p, api)
+		api.admins["admin"] = true
+
+		response := decide(p, heldPostID, holdDecisionDeny, "admin")
+		require.NotNil(t, response.Update)
+		fields := response.Update.Attachments()[0].Fields
+		assert.Equal(t, "Denied by @admin", fields[len(fields)-1].Value)
+		assert.Empty(t, api.kv)
+		assert.Len(t, api.posts, 1)
+	})
+
+	t.Run("only moderators can review", func(t *testing.T) {
+		p, api := newHoldPlugin(t)
+		heldPostID, _ := holdPost(t, p, api)
+
+		response := decide(p, heldPostID, holdDecisionApprove, "author")
+		assert.Nil(t, response.Update)
+		assert.Contains(t, response.EphemeralText, "Only moderators")
+		assert.Len(t, api.kv, 1)
+	})
+
+	t.Run("approval props set by users are ignored", func(t *testing.T) {
+		p, _ := newHoldPlugin(t)
+
+		in := &model.Post{Message: "buy now!"}
+		in.AddProp(approvalProp, "forged")
+		rpost, _ := p.MessageWillBePosted(&plugin.Context{}, in)
+		assert.Nil(t, rpost)
+	})
+}
+
+func TestIsModerator(t *testing.T) {
+	p, api := newTestPlugin(t, &configuration{Moderators: "alice, @Bob, carol"})
+	api.admins["admin"] = true
+	for _, username := range []string{"admin", "alice", "bob", "carol", "mallory"} {
+		api.users[username] = &model.User{Id: username, Username: username}
+	}
+	api.users["carol"].DeleteAt = model.GetMillis()
+
+	assert.True(t, p.isModerator("admin"))
+	assert.True(t, p.isModerator("alice"))
+	assert.True(t, p.isModerator("bob"))
+	assert.False(t, p.isModerator("carol"), "deactivated moderators")
+	assert.False(t, p.isModerator("mallory"))
+	assert.False(t, p.isModerator(""))
+	assert.False(t, p.isModerator("unknown"))
+
+	// Renames take effect at once, even though the user was looked up before.
+	api.users["alice"].Username = "alice2"
+	assert.False(t, p.isModerator("alice"))
+}
+
+func TestValidateHoldActions(t *testing.T) {
+	p := &Plugin{}
+	holdRules, err := loadWordRules(`[{"id": "spam", "pattern": "buy now", "action": "hold"}]`)
+	require.NoError(t, err)
+
+	assert.NoError(t, p.validateHoldActions(&configuration{ModerationChannelID: "moderation", QuarantinePosts: quarantinePostsHold}, holdRules))
+	assert.NoError(t, p.validateHoldActions(&configuration{}, nil))
+	assert.EqualError(t, p.validateHoldActions(&configuration{}, holdRules),
+		"a moderation channel is needed to review the posts held by rule spam")
+	assert.EqualError(t, p.validateHoldActions(&configuration{SkipMarkdownRegions: "inline_code:hold", QuarantinePosts: quarantinePostsHold}, nil),
+		"a moderation channel is needed to review the posts held by markdown region inline_code, quarantine posts")
+}
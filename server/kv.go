@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+)
+
+// kvUpdateAttempts bounds the retries of concurrent updates of the same KV store value.
+const kvUpdateAttempts = 10
+
+// updateKVJSON applies an update to a value stored as JSON under a key, retrying when the value
+// is changed concurrently. load returns the value and its stored data, nil if there is none;
+// update returns the new value, or false to leave the store untouched. what names the value in
+// errors. It returns the stored value and whether it was changed.
+func updateKVJSON[T any](
+	api plugin.API,
+	key, what string,
+	load func() (T, []byte, error),
+	update func(T) (T, bool, error),
+) (T, bool, error) {
+	var zero T
+	for attempt := 0; attempt < kvUpdateAttempts; attempt++ {
+		value, oldData, err := load()
+		if err != nil {
+			return zero, false, err
+		}
+		value, changed, err := update(value)
+		if err != nil || !changed {
+			return zero, false, err
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return zero, false, errors.Wrapf(err, "failed to encode %s", what)
+		}
+		updated, appErr := api.KVCompareAndSet(key, oldData, data)
+		if appErr != nil {
+			return zero, false, errors.Wrapf(appErr, "failed to store %s", what)
+		}
+		if updated {
+			return value, true, nil
+		}
+	}
+	return zero, false, fmt.Errorf("gave up updating %s after %d attempts", what, kvUpdateAttempts)
+}
+
+// loadKVStrings returns the strings stored as a JSON array under a key, and their stored data.
+func loadKVStrings(api plugin.API, key, what string) ([]string, []byte, error) {
+	data, appErr := api.KVGet(key)
+	if appErr != nil {
+		return nil, nil, errors.Wrapf(appErr, "failed to load %s", what)
+	}
+	if data == nil {
+		return []string{}, nil, nil
+	}
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to decode %s", what)
+	}
+	return values, data, nil
+}
+
+// updateKVStrings adds a string to the JSON array stored under a key, or removes it from it.
+// Such arrays list the keys of a kind, so that they are found without going through every key
+// of the plugin.
+func updateKVStrings(api plugin.API, key, what, value string, listed bool) error {
+	_, _, err := updateKVJSON(api, key, what, func() ([]string, []byte, error) {
+		return loadKVStrings(api, key, what)
+	}, func(values []string) ([]string, bool, error) {
+		if containsString(values, value) == listed {
+			return values, false, nil
+		}
+		if listed {
+			return append(values, value), true, nil
+		}
+		kept := make([]string, 0, len(values))
+		for _, v := range values {
+			if v != value {
+				kept = append(kept, v)
+			}
+		}
+		return kept, true, nil
+	})
+	return err
+}
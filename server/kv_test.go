@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateKVJSON(t *testing.T) {
+	load := func(api *KVMockAPI) func() (map[string]int, []byte, error) {
+		return func() (map[string]int, []byte, error) {
+			counts := map[string]int{}
+			data := api.kv["counts"]
+			if data != nil {
+				require.NoError(t, json.Unmarshal(data, &counts))
+			}
+			return counts, data, nil
+		}
+	}
+	increment := func(name string) func(map[string]int) (map[string]int, bool, error) {
+		return func(counts map[string]int) (map[string]int, bool, error) {
+			counts[name]++
+			return counts, true, nil
+		}
+	}
+
+	t.Run("updates are retried when the value changes concurrently", func(t *testing.T) {
+		api := NewKVMockAPI()
+		api.KVCompareAndSetFunc = func(key string, oldValue, newValue []byte) (bool, *model.AppError) {
+			api.KVCompareAndSetFunc = nil
+			_, _, err := updateKVJSON(api, "counts", "counts", load(api), increment("b"))
+			require.NoError(t, err)
+			return api.KVCompareAndSet(key, oldValue, newValue)
+		}
+
+		counts, updated, err := updateKVJSON(api, "counts", "counts", load(api), increment("a"))
+		require.NoError(t, err)
+		assert.True(t, updated)
+		assert.Equal(t, map[string]int{"a": 1, "b": 1}, counts)
+		assert.JSONEq(t, `{"a": 1, "b": 1}`, string(api.kv["counts"]))
+	})
+
+	t.Run("unchanged values are not stored", func(t *testing.T) {
+		api := NewKVMockAPI()
+		_, updated, err := updateKVJSON(api, "counts", "counts", load(api), func(counts map[string]int) (map[string]int, bool, error) {
+			return counts, false, nil
+		})
+		require.NoError(t, err)
+		assert.False(t, updated)
+		assert.NotContains(t, api.kv, "counts")
+	})
+
+	t.Run("gives up when the value keeps changing", func(t *testing.T) {
+		api := NewKVMockAPI()
+		attempts := 0
+		api.KVCompareAndSetFunc = func(key string, oldValue, newValue []byte) (bool, *model.AppError) {
+			attempts++
+			return false, nil
+		}
+
+		_, _, err := updateKVJSON(api, "counts", "counts", load(api), increment("a"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "gave up updating counts")
+		assert.Equal(t, kvUpdateAttempts, attempts)
+	})
+}
+
+func TestUpdateKVStrings(t *testing.T) {
+	api := NewKVMockAPI()
+
+	require.NoError(t, updateKVStrings(api, "names", "names", "a", true))
+	require.NoError(t, updateKVStrings(api, "names", "names", "b", true))
+	require.NoError(t, updateKVStrings(api, "names", "names", "a", true))
+	names, _, err := loadKVStrings(api, "names", "names")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, names)
+
+	require.NoError(t, updateKVStrings(api, "names", "names", "a", false))
+	require.NoError(t, updateKVStrings(api, "names", "names", "c", false))
+	names, _, err = loadKVStrings(api, "names", "names")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, names)
+}
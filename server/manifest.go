@@ -42,6 +42,78 @@ const manifestStr = `
         "default": null,
         "hosting": ""
       },
+      {
+        "key": "PostFilters",
+        "display_name": "Post Filters:",
+        "type": "text",
+        "help_text": "The checks to run on every post, in order, separated by commas. Available filters: ` + "`" + `direct_messages` + "`" + ` (block new users from sending PMs), ` + "`" + `bad_words` + "`" + ` (censor or reject words from the bad words list). Leave empty to run all of them.",
+        "placeholder": "",
+        "default": "direct_messages,bad_words",
+        "hosting": ""
+      },
+      {
+        "key": "ShadowMode",
+        "display_name": "Shadow Mode:",
+        "type": "text",
+        "help_text": "Comma separated list of features that only log what they would have done, without censoring, rejecting or deactivating anyone. Use it to try out changes to the lists before enforcing them. Available features: ` + "`" + `bad_words` + "`" + `, ` + "`" + `direct_messages` + "`" + ` and ` + "`" + `new_users` + "`" + `, or ` + "`" + `all` + "`" + ` for every feature. Leave empty to enforce everything.",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
+      },
+      {
+        "key": "EnableAuditLog",
+        "display_name": "Enable Audit Log:",
+        "type": "bool",
+        "help_text": "Keep a log of every decision of the plugin (censored, rejected and held posts, blocked direct messages, sanitized users and reviews of held posts) in the plugin's key-value store. Records are written in the background, so that posting never waits for them. Moderators can query it with the plugin's ` + "`" + `/api/v1/audit` + "`" + ` endpoint.",
+        "placeholder": "",
+        "default": false,
+        "hosting": ""
+      },
+      {
+        "key": "AuditRetentionDays",
+        "display_name": "Audit Retention Days:",
+        "type": "text",
+        "help_text": "Number of days the records of the audit log are kept. Leave empty or set to 0 to keep them forever.",
+        "placeholder": "",
+        "default": "90",
+        "hosting": ""
+      },
+      {
+        "key": "ModerationChannelID",
+        "display_name": "Moderation Channel ID:",
+        "type": "text",
+        "help_text": "ID of the channel where moderators review held posts and are notified of moderation actions. The Community Toolkit bot must be a member of the channel. Posts matching a ` + "`" + `hold` + "`" + ` word rule are rejected when no channel is set.",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
+      },
+      {
+        "key": "ModerationNotifications",
+        "display_name": "Moderation Notifications:",
+        "type": "text",
+        "help_text": "Comma separated list of the moderation actions posted to the moderation channel. Available actions: ` + "`" + `user_sanitized` + "`" + `, ` + "`" + `user_flagged` + "`" + ` (new users flagged or quarantined, and users removed from a team they may not join), ` + "`" + `cleanup_failed` + "`" + ` (users the plugin failed to sanitize or deactivate), ` + "`" + `post_rejected` + "`" + `, ` + "`" + `post_censored` + "`" + `, ` + "`" + `post_flagged` + "`" + ` (alert rules), ` + "`" + `direct_message_blocked` + "`" + ` and ` + "`" + `shadow` + "`" + ` (decisions of features in shadow mode).",
+        "placeholder": "",
+        "default": "user_sanitized,user_flagged,cleanup_failed,post_rejected,post_censored,post_flagged,direct_message_blocked,shadow",
+        "hosting": ""
+      },
+      {
+        "key": "Moderators",
+        "display_name": "Moderators:",
+        "type": "text",
+        "help_text": "Comma separated list of usernames that may review held posts, in addition to system admins. E.g., ` + "`" + `alice,bob` + "`" + `",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
+      },
+      {
+        "key": "ActingUsername",
+        "display_name": "Acting User:",
+        "type": "text",
+        "help_text": "Username of a system admin the plugin removes flagged users from their teams as. Leave empty to act as the Community Toolkit bot.",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
+      },
       {
         "key": "RejectPosts",
         "display_name": "Reject Posts:",
@@ -55,11 +127,48 @@ const manifestStr = `
         "key": "WarningMessage",
         "display_name": "Warning Message:",
         "type": "text",
-        "help_text": "If **Reject Posts** is enabled, this warning message will be sent to the user. Place ` + "`" + `%s` + "`" + ` where you want to include the forbidden word in the message. Markdown formatted.",
+        "help_text": "If **Reject Posts** is enabled, this warning message will be sent to the user. Place ` + "`" + `%s` + "`" + ` where you want to include the forbidden word in the message, or use a template like the ones of **Message Templates**. Left at its default, the ` + "`" + `post_rejected` + "`" + ` message of **Message Templates** or its bundled translations are sent instead. Markdown formatted.",
         "placeholder": "E.g., Your post has been rejected by the Profanity Filter, because the following word is not allowed: ` + "`" + `%s` + "`" + `.",
         "default": "Your post has been rejected by the Profanity Filter, because the following word is not allowed: ` + "`" + `%s` + "`" + `.",
         "hosting": ""
       },
+      {
+        "key": "WarningDelivery",
+        "display_name": "Warning Delivery:",
+        "type": "dropdown",
+        "help_text": "How the Community Toolkit bot tells users why their post was rejected, held or blocked. Ephemeral messages disappear when the page is reloaded; direct messages stay, so users can look up why a post was not published.",
+        "placeholder": "",
+        "default": "ephemeral",
+        "options": [
+          {
+            "display_name": "Ephemeral message in the channel",
+            "value": "ephemeral"
+          },
+          {
+            "display_name": "Direct message from the bot",
+            "value": "direct_message"
+          }
+        ],
+        "hosting": ""
+      },
+      {
+        "key": "MessageTemplates",
+        "display_name": "Message Templates:",
+        "type": "longtext",
+        "help_text": "Translations of the messages sent to users, as a JSON object of Go templates keyed by user locale and message. Messages: ` + "`" + `post_rejected` + "`" + `, ` + "`" + `post_held` + "`" + `, ` + "`" + `direct_message_blocked` + "`" + `, ` + "`" + `direct_message_error` + "`" + ` and ` + "`" + `user_quarantined` + "`" + `. Templates can use ` + "`" + `{{.Words}}` + "`" + `, ` + "`" + `{{.Categories}}` + "`" + `, ` + "`" + `{{.Channel}}` + "`" + `, ` + "`" + `{{.BlockTime}}` + "`" + `, ` + "`" + `{{.RemainingTime}}` + "`" + ` and ` + "`" + `{{.CodeOfConductURL}}` + "`" + `. English is used for locales without a translation. E.g., ` + "`" + `{\"de\": {\"post_held\": \"Dein Beitrag wird von einem Moderator geprüft.\"}}` + "`" + `",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
+      },
+      {
+        "key": "CodeOfConductURL",
+        "display_name": "Code of Conduct URL:",
+        "type": "text",
+        "help_text": "Link to the community's code of conduct, included in the messages sent to users.",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
+      },
       {
         "key": "CensorCharacter",
         "display_name": "Censor Character:",
@@ -105,6 +214,51 @@ const manifestStr = `
         "default": "4r5e,5h1t,5hit,a55,anal,anus,ar5e,arrse,arse,ass(es)?,ass[-]?fucker,assfukka,assholes?,asswhole,a_s_s,b!tch,b17ch,b1tch,ballbag,ballsack,bastard,beastial,beastiality,bellend,bestial,bestiality,bi+ch,biatch,bitch,bitcher,bitchers,bitches,bitchin,bitching,bloody,blow[ ]?jobs?,boiolas,bollock,bollok,boner,b[o0][o0]+bs?,breasts,buceta,bugger,bum,bunny fucker,butt,butt[ ]?hole,buttmuch,buttplug,c[0o]cks?,c0cksucker,carpet muncher,cawk,chink,cipa,cl[i1]t,clitoris,clits,cnut,cock-sucker,cockface,cockhead,cockmunch,cockmuncher,cocksucks?,cocksucked,cocksucker,cocksucking,cocksuka,cocksukka,cok,cokmuncher,coksucka,coon,cox,crap,cums?,cummer,cumming,cumshot?,cunilingus,cunillingus,cunnilingus,cunt,cuntlick,cuntlicker,cuntlicking,cunts,cyalis,cyberfuc,cyberfuck,cyberfucked,cyberfucker,cyberfuckers,cyberfucking,d1ck,damn,dick,dickhead,dildo,dildos,dink,dinks,dirsa,dlck,dog-fucker,doggin,dogging,donkeyribber,doosh,duche,dyke,ejaculate,ejaculated,ejaculates,ejaculating,ejaculatings,ejaculation,ejakulate,f[[:space:]]*u[[:space:]]*c[[:space:]]*k,f[[:space:]]*u[[:space:]]*c[[:space:]]*k[[:space:]]*e[[:space:]]*r,f4nny,fag,fagging,faggitt,faggot,faggs,fagot,fagots,fags,fanny,fannyflaps,fannyfucker,fanyy,fatass,fcuk,fcuker,fcuking,feck,fecker,felching,fellate,fellatio,fingerfuck,fingerfucked,fingerfucker,fingerfuckers,fingerfucking,fingerfucks,fistfuck,fistfucked,fistfucker,fistfuckers,fistfucking,fistfuckings,fistfucks,flange,fook,fooker,fuck,fucka,fucked,fucker,fuckers,fuckhead,fuckheads,fuckin,fucking,fuckings,fuckingshitmother[[:space:]]*fucker,fuckme,fucks,fuckwhit,fuckwit,fudge packer,fudgepacker,fuk,fuker,fukker,fukkin,fuks,fukwhit,fukwit,fux,fux0r,f_u_c_k,gangbang,gangbanged,gangbangs,gaylord,gaysex,goatse,God,god-dam,god-damned,goddamn,goddamned,hardcoresex,hell,heshe,hoar,hoare,hoer,homo,hore,horniest,horny,hotsex,jack-off,jackoff,jap,jerk-off,jism,jiz,jizm,jizz,kawk,knob,knobead,knobed,knobend,knobhead,knobjocky,knobjokey,kock,kondum,kondums,kum,kummer,kumming,kums,kunilingus,l3i\\+ch,l3itch,labia,lust,lusting,m0f0,m0fo,m[a4][s5]terb(at[3e]|8),ma5terbate,masochist,master-bate,masterbations?,mo-fo,mof[o0],motha[[:space:]]*fuck,motha[[:space:]]*fuckas?,motha[[:space:]]*fuckaz,motha[[:space:]]*fucked,motha[[:space:]]*fuckers?,motha[[:space:]]*fuckin,motha[[:space:]]*fucking,motha[[:space:]]*fuckings,motha[[:space:]]*fucks,mother[[:space:]]*fuck,mother[[:space:]]*fucked,mother fucker,mother fuckers,mother fuckin,mother fucking,mother fuckings,mother fuckka,mother fucks,mother[[:space:]]*fucker,mother[[:space:]]*fuckers,mother[[:space:]]*fuckin,mother[[:space:]]*fucking,mother[[:space:]]*fuckings,mother[[:space:]]*fuckka,mother[[:space:]]*fucks,muff,mutha,muthafecker,muthafuckker,muther,mutherfucker,n[i1]gg[ea3]r?s?,niggaz,nob,nob jokey,nobhead,nobjocky,nobjokey,numbnuts,nutsack,orgasims?,orgasms?,p[o0]rno?s?,pawn,pecker,penis,penisfucker,phonesex,phuck,phuk,phuked,phuking,phukked,phukking,phuks,phuq,pigfucker,pimpis,piss,pissed,pisser,pissers,pisses,pissflaps,pissin,pissing,pissoff,poop,pornography,prick,pricks,pron,pube,pusse,puss[iy]e?s?,rectum,retard,rimjaw,rimming,s[[:space:]]*h[[:space:]]*i[[:space:]]*t,s\\.o\\.b\\.,sadist,schlong,screwing,scroat,scrote,scrotum,semen,sex,shag,shagger,shaggin,shagging,shemale,sh[i1!][t+]s?,shitdick,shite,shited,shitey,shitfuck,shitfull,shithead,shiting,shitings,shitted,shitter,shitters,shitting,shittings,shitty,skank,sluts?,smegma,smut,snatch,son-of-a-bitch,spac,spunk,t1tt1e5,t1tties,teets,teez,testical,testicle,tits?,titfuck,titt,tittie5,tittiefucker,titties?,tittyfuck,tittywank,titwank,tosser,turd,tw[4a]t,twathead,twatty,twunt,twunter,v14gra,v1gra,vagina,viagra,vulva,w00se,wang,wank,wanker,wanky,whoar,whores?,willies,willy,xrated,x[[:space:]]*x[[:space:]]*x.",
         "hosting": ""
       },
+      {
+        "key": "AllowedWordsList",
+        "display_name": "Allowed Words List:",
+        "type": "longtext",
+        "help_text": "Comma separated list of words and phrases (as regular expressions) that are never filtered, even when a word rule or the Bad Words List matches inside them. E.g., ` + "`" + `Cox Communications,pawn,shell` + "`" + `",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
+      },
+      {
+        "key": "WordRules",
+        "display_name": "Word Rules:",
+        "type": "longtext",
+        "help_text": "Structured word rules as a JSON array, applied in addition to the Bad Words List. Each rule has an ` + "`" + `id` + "`" + `, a ` + "`" + `pattern` + "`" + ` (regular expression), an optional ` + "`" + `category` + "`" + ` and ` + "`" + `severity` + "`" + `, and an ` + "`" + `action` + "`" + `: ` + "`" + `censor` + "`" + `, ` + "`" + `reject` + "`" + `, ` + "`" + `hold` + "`" + ` (withhold the post for moderator review) or ` + "`" + `alert` + "`" + ` (let the post through and only report it). When several rules match, the most severe action wins. E.g., ` + "`" + `[{\"id\": \"slurs\", \"pattern\": \"badword\", \"category\": \"slur\", \"severity\": 3, \"action\": \"reject\"}]` + "`" + `",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
+      },
+      {
+        "key": "LogWordMatches",
+        "display_name": "Log Word Matches:",
+        "type": "bool",
+        "help_text": "Log every word rule match with the rule ID, the Bad Words List entry and the matched text, to help tune the lists.",
+        "placeholder": "",
+        "default": false,
+        "hosting": ""
+      },
+      {
+        "key": "TextNormalization",
+        "display_name": "Text Normalization:",
+        "type": "text",
+        "help_text": "Normalization applied to posts and usernames before matching them against the lists, separated by commas, so that evasions are caught without listing every spelling. ` + "`" + `invisible` + "`" + ` removes zero-width characters, ` + "`" + `confusables` + "`" + ` folds lookalike letters of other scripts (e.g. Cyrillic \"а\") and fullwidth letters, ` + "`" + `leetspeak` + "`" + ` reads substitutions like \"4\" or \"$\" as letters, ` + "`" + `separators` + "`" + ` joins spaced-out letters (\"f.o.o\"), and ` + "`" + `repeats` + "`" + ` collapses repeated letters. Leave empty to only ignore accents.",
+        "placeholder": "",
+        "default": "invisible,confusables,leetspeak,separators,repeats",
+        "hosting": ""
+      },
+      {
+        "key": "SkipMarkdownRegions",
+        "display_name": "Skip Markdown Regions:",
+        "type": "text",
+        "help_text": "Comma separated list of markdown regions where censoring could corrupt pasted logs, commands and links. Available regions: ` + "`" + `code_blocks` + "`" + `, ` + "`" + `inline_code` + "`" + `, ` + "`" + `link_urls` + "`" + ` and ` + "`" + `quotes` + "`" + `. Words are always detected in the whole post, and reject and hold rules apply everywhere; a region only decides what happens to words that would be censored. List a region by name to leave such words untouched, or as ` + "`" + `region:policy` + "`" + ` with the policy ` + "`" + `keep` + "`" + `, ` + "`" + `censor` + "`" + `, ` + "`" + `hold` + "`" + ` or ` + "`" + `reject` + "`" + `, e.g. ` + "`" + `inline_code:hold` + "`" + `.",
+        "placeholder": "",
+        "default": "code_blocks,inline_code,link_urls",
+        "hosting": ""
+      },
       {
         "key": "BuiltinBadDomains",
         "display_name": "Use Built-in Bad-Domains list: ",
@@ -122,6 +276,97 @@ const manifestStr = `
         "placeholder": "",
         "default": "",
         "hosting": ""
+      },
+      {
+        "key": "AllowedDomains",
+        "display_name": "Allowed Domains:",
+        "type": "longtext",
+        "help_text": "Only email domains on this list may sign up, comma separated. Entries also allow their subdomains; entries written ` + "`" + `*.example.com` + "`" + ` only allow subdomains. Everyone else fails the ` + "`" + `allowed_domains` + "`" + ` check. Leave empty to let any domain sign up.",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
+      },
+      {
+        "key": "TeamAllowedDomains",
+        "display_name": "Team Allowed Domains:",
+        "type": "longtext",
+        "help_text": "Comma separated ` + "`" + `team=domain` + "`" + ` pairs limiting who may join a team, e.g. ` + "`" + `staff=example.com,staff=example.org` + "`" + `. Teams are given by name or id. Users who join a listed team without an allowed email domain are removed from that team only; their account and other teams are kept. Moderators may join any team.",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
+      },
+      {
+        "key": "MaxEmailDigitRatio",
+        "display_name": "Max Email Digit Ratio:",
+        "type": "text",
+        "help_text": "Share of digits, between 0 and 1, above which the local part of a new user's email address looks generated, e.g. ` + "`" + `0.5` + "`" + ` for ` + "`" + `js83920175@example.com` + "`" + `. Such users fail the ` + "`" + `suspicious_emails` + "`" + ` check. Local parts shorter than 8 characters are not scored. Leave empty to turn off.",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
+      },
+      {
+        "key": "MaxEmailEntropy",
+        "display_name": "Max Email Entropy:",
+        "type": "text",
+        "help_text": "Entropy, in bits per character, above which the local part of a new user's email address looks random, e.g. ` + "`" + `3.6` + "`" + `. Such users fail the ` + "`" + `suspicious_emails` + "`" + ` check. Local parts shorter than 8 characters are not scored. Leave empty to turn off.",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
+      },
+      {
+        "key": "BanSanitizedEmails",
+        "display_name": "Ban Emails of Sanitized Users:",
+        "type": "bool",
+        "help_text": "Ban the normalized email address of users who are sanitized and deactivated, so that they cannot sign up again with a variant of it. Restoring the user lifts the ban.",
+        "placeholder": "",
+        "default": false,
+        "hosting": ""
+      },
+      {
+        "key": "UserResponses",
+        "display_name": "New User Responses:",
+        "type": "text",
+        "help_text": "Comma separated ` + "`" + `check=response` + "`" + ` pairs choosing what happens to a new user who fails a check. Checks: ` + "`" + `bad_usernames` + "`" + `, ` + "`" + `bad_domains` + "`" + `, ` + "`" + `allowed_domains` + "`" + `, ` + "`" + `banned_emails` + "`" + ` and ` + "`" + `suspicious_emails` + "`" + `. Responses, from the mildest: ` + "`" + `flag` + "`" + ` (flag and notify the moderators), ` + "`" + `sanitize_username` + "`" + ` (rename the user and keep the account), ` + "`" + `quarantine` + "`" + ` (keep the user from posting until released), ` + "`" + `approval` + "`" + ` (deactivate until a moderator approves them, needs the Moderation Channel ID) and ` + "`" + `deactivate` + "`" + ` (sanitize, remove from teams and deactivate). Checks not listed deactivate the user. Username and domain rules can choose a response of their own.",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
+      },
+      {
+        "key": "QuarantinePosts",
+        "display_name": "Quarantined User Posts:",
+        "type": "dropdown",
+        "help_text": "What happens to the posts of quarantined users outside the holding channel. Their direct and group messages are always rejected.",
+        "placeholder": "",
+        "default": "reject",
+        "options": [
+          {
+            "display_name": "Reject them",
+            "value": "reject"
+          },
+          {
+            "display_name": "Hold them for moderator review",
+            "value": "hold"
+          }
+        ],
+        "hosting": ""
+      },
+      {
+        "key": "QuarantineTeamID",
+        "display_name": "Quarantine Holding Team ID:",
+        "type": "text",
+        "help_text": "ID of a team quarantined users are added to. Leave empty to leave their teams alone.",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
+      },
+      {
+        "key": "QuarantineChannelID",
+        "display_name": "Quarantine Holding Channel ID:",
+        "type": "text",
+        "help_text": "ID of a channel quarantined users are added to, along with its team, and where they can talk to moderators. Leave empty to have no holding channel.",
+        "placeholder": "",
+        "default": "",
+        "hosting": ""
       }
     ]
   }
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The markdown regions whose content the SkipMarkdownRegions setting protects from censoring.
+const (
+	markdownCodeBlocks = "code_blocks"
+	markdownInlineCode = "inline_code"
+	markdownLinkURLs   = "link_urls"
+	markdownQuotes     = "quotes"
+)
+
+var markdownRegionKinds = []string{
+	markdownCodeBlocks,
+	markdownInlineCode,
+	markdownLinkURLs,
+	markdownQuotes,
+}
+
+// The policies for the matches of censoring rules within a markdown region. Rules with any
+// other action apply everywhere, regions or not.
+const (
+	// regionPolicyKeep leaves the match untouched, so that code and links are not corrupted.
+	regionPolicyKeep = "keep"
+	// regionPolicyCensor censors the match like anywhere else in the post.
+	regionPolicyCensor = "censor"
+	// regionPolicyHold holds the post for review, since the match cannot be censored.
+	regionPolicyHold = "hold"
+	// regionPolicyReject rejects the post, since the match cannot be censored.
+	regionPolicyReject = "reject"
+)
+
+// regionPolicyRanks orders the policies from the most lenient, for matches that lie in more
+// than one region: censoring inside inline code within a quote would still corrupt the code.
+var regionPolicyRanks = map[string]int{
+	regionPolicyCensor: 0,
+	regionPolicyKeep:   1,
+	regionPolicyHold:   2,
+	regionPolicyReject: 3,
+}
+
+var (
+	// markdownLinkURL matches the destination of an inline link or image: [text](url "title").
+	markdownLinkURL = regexp.MustCompile(`\]\(\s*(<[^>\n]*>|[^\s)]+)(?:\s+(?:"[^"\n]*"|'[^'\n]*'))?\s*\)`)
+	// markdownAutolink matches autolinks such as <https://rockylinux.org>.
+	markdownAutolink = regexp.MustCompile(`<[a-zA-Z][a-zA-Z0-9+.-]{1,31}:[^\s<>]*>`)
+	// bareURL matches URLs that Mattermost turns into links without any markup.
+	bareURL = regexp.MustCompile(`(?i)\b(?:https?://|ftp://|www\.)[^\s<>]+[^\s<>.,:;!?'")\]]`)
+)
+
+// parseMarkdownRegions validates the SkipMarkdownRegions setting and returns the policy of each
+// listed region. A region is listed by name, to keep the matches of censoring rules within it
+// untouched, or as name:policy.
+func parseMarkdownRegions(setting string) (map[string]string, error) {
+	policies := map[string]string{}
+	for _, entry := range strings.Split(setting, ",") {
+		name, policy, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		known := false
+		for _, kind := range markdownRegionKinds {
+			known = known || kind == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown markdown region: %q", name)
+		}
+
+		policy = strings.TrimSpace(policy)
+		if !found {
+			policy = regionPolicyKeep
+		}
+		if _, ok := regionPolicyRanks[policy]; !ok {
+			return nil, fmt.Errorf("unknown policy for markdown region %s: %q", name, policy)
+		}
+		policies[name] = policy
+	}
+	return policies, nil
+}
+
+// markdownRegion is a span of a text that belongs to a markdown region, along with the policy
+// for the matches of censoring rules within it.
+type markdownRegion struct {
+	span   textSpan
+	policy string
+}
+
+// markdownRegions returns the spans of the message that belong to the markdown regions listed
+// by the configuration.
+func markdownRegions(configuration *configuration, message string) []markdownRegion {
+	// Invalid regions are reported when the configuration is loaded.
+	policies, _ := parseMarkdownRegions(configuration.SkipMarkdownRegions)
+	if len(policies) == 0 {
+		return nil
+	}
+
+	var regions []markdownRegion
+	add := func(kind string, spans []textSpan) {
+		if policy, listed := policies[kind]; listed {
+			for _, span := range spans {
+				regions = append(regions, markdownRegion{span: span, policy: policy})
+			}
+		}
+	}
+
+	blocks := fencedCodeBlocks(message)
+	add(markdownCodeBlocks, blocks)
+	// Inside code blocks, backticks, quotes and links have no meaning.
+	outside := maskSpans(message, blocks)
+	inlineCode := inlineCodeSpans(outside)
+	add(markdownInlineCode, inlineCode)
+	add(markdownLinkURLs, linkURLSpans(maskSpans(outside, inlineCode)))
+	add(markdownQuotes, blockQuoteSpans(outside))
+	return regions
+}
+
+// regionPolicy returns the policy for a match of a censoring rule: the strictest policy of
+// the regions it overlaps, or censor outside of any region.
+func regionPolicy(regions []markdownRegion, span textSpan) string {
+	policy := regionPolicyCensor
+	for _, region := range regions {
+		if region.span.start < span.end && span.start < region.span.end &&
+			regionPolicyRanks[region.policy] > regionPolicyRanks[policy] {
+			policy = region.policy
+		}
+	}
+	return policy
+}
+
+// applyRegionPolicies applies the policies of the markdown regions to the matches of a
+// censoring rule. Matches in regions that are kept are dropped. A match in a region that
+// escalates cannot be censored without corrupting the region, so the rule is reported with
+// the action of the region instead.
+func applyRegionPolicies(rule *Rule, regions []markdownRegion, matches []ruleMatch) (*Rule, []ruleMatch) {
+	if len(regions) == 0 {
+		return rule, matches
+	}
+
+	action := rule.Action
+	kept := matches[:0:0]
+	for _, match := range matches {
+		switch regionPolicy(regions, match.span) {
+		case regionPolicyKeep:
+			continue
+		case regionPolicyHold:
+			if action != RuleActionReject {
+				action = RuleActionHold
+			}
+		case regionPolicyReject:
+			action = RuleActionReject
+		}
+		kept = append(kept, match)
+	}
+
+	if action != rule.Action {
+		escalated := *rule
+		escalated.Action = action
+		rule = &escalated
+	}
+	return rule, kept
+}
+
+// maskSpans blanks out the spans of a text with spaces. The masked text has the same length
+// as the original, so byte offsets found in it are valid in the original too.
+func maskSpans(s string, spans []textSpan) string {
+	if len(spans) == 0 {
+		return s
+	}
+
+	masked := []byte(s)
+	for _, span := range spans {
+		for i := span.start; i < span.end && i < len(masked); i++ {
+			if masked[i] != '\n' {
+				masked[i] = ' '
+			}
+		}
+	}
+	return string(masked)
+}
+
+// lineSpans returns the byte range of every line of the text, without the line break.
+func lineSpans(s string) []textSpan {
+	var lines []textSpan
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\n' {
+			lines = append(lines, textSpan{start, i})
+			start = i + 1
+		}
+	}
+	return append(lines, textSpan{start, len(s)})
+}
+
+// fencedCodeBlocks returns the spans of the code blocks fenced with ``` or ~~~, fences
+// included. A block that is never closed runs to the end of the message.
+func fencedCodeBlocks(s string) []textSpan {
+	var blocks []textSpan
+	var open *textSpan
+	var fence string
+
+	for _, line := range lineSpans(s) {
+		text := strings.TrimLeft(s[line.start:line.end], " ")
+		if len(s[line.start:line.end])-len(text) > 3 {
+			continue
+		}
+
+		if open == nil {
+			marker := fenceMarker(text)
+			if marker == "" || (marker[0] == '`' && strings.Contains(text[len(marker):], "`")) {
+				continue
+			}
+			open = &textSpan{line.start, line.end}
+			fence = marker
+			continue
+		}
+
+		if marker := fenceMarker(text); marker != "" && marker[0] == fence[0] && len(marker) >= len(fence) &&
+			strings.TrimSpace(text[len(marker):]) == "" {
+			blocks = append(blocks, textSpan{open.start, line.end})
+			open = nil
+		}
+	}
+
+	if open != nil {
+		blocks = append(blocks, textSpan{open.start, len(s)})
+	}
+	return blocks
+}
+
+// fenceMarker returns the run of at least three backticks or tildes a line starts with.
+func fenceMarker(line string) string {
+	if line == "" || (line[0] != '`' && line[0] != '~') {
+		return ""
+	}
+	end := 0
+	for end < len(line) && line[end] == line[0] {
+		end++
+	}
+	if end < 3 {
+		return ""
+	}
+	return line[:end]
+}
+
+// inlineCodeSpans returns the spans of inline code, delimited by backtick runs of equal length.
+// A backtick run without a matching closing run is literal text.
+func inlineCodeSpans(s string) []textSpan {
+	var spans []textSpan
+	for i := 0; i < len(s); {
+		if s[i] != '`' {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(s) && s[i] == '`' {
+			i++
+		}
+		// Without a closing run of the same length, the whole run is literal text.
+		opening := i - start
+		for j := i; j < len(s); {
+			if s[j] != '`' {
+				j++
+				continue
+			}
+			runStart := j
+			for j < len(s) && s[j] == '`' {
+				j++
+			}
+			if j-runStart == opening {
+				spans = append(spans, textSpan{start, j})
+				i = j
+				break
+			}
+		}
+	}
+	return spans
+}
+
+// linkURLSpans returns the spans of link destinations, autolinks and bare URLs. The text of
+// a link stays in scope, only its target is skipped.
+func linkURLSpans(s string) []textSpan {
+	var spans []textSpan
+	for _, location := range markdownLinkURL.FindAllStringSubmatchIndex(s, -1) {
+		spans = append(spans, textSpan{location[2], location[3]})
+	}
+	for _, location := range markdownAutolink.FindAllStringIndex(s, -1) {
+		spans = append(spans, textSpan{location[0], location[1]})
+	}
+	// Bare URLs are only looked for outside of the links found so far.
+	for _, location := range bareURL.FindAllStringIndex(maskSpans(s, spans), -1) {
+		spans = append(spans, textSpan{location[0], location[1]})
+	}
+	return spans
+}
+
+// blockQuoteSpans returns the spans of the lines that are part of a block quote.
+func blockQuoteSpans(s string) []textSpan {
+	var spans []textSpan
+	for _, line := range lineSpans(s) {
+		text := s[line.start:line.end]
+		trimmed := strings.TrimLeft(text, " ")
+		if len(text)-len(trimmed) <= 3 && strings.HasPrefix(trimmed, ">") {
+			spans = append(spans, line)
+		}
+	}
+	return spans
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkdownRegions(t *testing.T) {
+	skipped := func(regions, message string) []string {
+		var out []string
+		for _, region := range markdownRegions(&configuration{SkipMarkdownRegions: regions}, message) {
+			out = append(out, message[region.span.start:region.span.end])
+		}
+		return out
+	}
+
+	for name, tc := range map[string]struct {
+		regions, in string
+		out         []string
+	}{
+		"fenced code block":        {markdownCodeBlocks, "look:\n```sh\nrm -rf /\n```\ndone", []string{"```sh\nrm -rf /\n```"}},
+		"tilde fence":              {markdownCodeBlocks, "~~~\ncode\n~~~", []string{"~~~\ncode\n~~~"}},
+		"unclosed fence":           {markdownCodeBlocks, "```\ncode\nmore", []string{"```\ncode\nmore"}},
+		"inline code":              {markdownInlineCode, "run `ls -la` now", []string{"`ls -la`"}},
+		"double backticks":         {markdownInlineCode, "a ``x ` y`` b", []string{"``x ` y``"}},
+		"unmatched backtick":       {markdownInlineCode, "a ` b", nil},
+		"link destination":         {markdownLinkURLs, "see [the docs](https://example.com/a_b)", []string{"https://example.com/a_b"}},
+		"autolink":                 {markdownLinkURLs, "at <https://example.com>", []string{"<https://example.com>"}},
+		"bare url":                 {markdownLinkURLs, "go to https://example.com/x.", []string{"https://example.com/x"}},
+		"block quote":              {markdownQuotes, "> quoted\nnot quoted", []string{"> quoted"}},
+		"nothing skipped":          {"", "`code`", nil},
+		"backticks in code blocks": {markdownInlineCode, "```\na `b` c\n```", nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.out, skipped(tc.regions, tc.in))
+		})
+	}
+
+	t.Run("parses policies", func(t *testing.T) {
+		policies, err := parseMarkdownRegions("code_blocks, inline_code:reject,quotes:censor")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			markdownCodeBlocks: regionPolicyKeep,
+			markdownInlineCode: regionPolicyReject,
+			markdownQuotes:     regionPolicyCensor,
+		}, policies)
+	})
+
+	t.Run("rejects unknown regions", func(t *testing.T) {
+		_, err := parseMarkdownRegions("code_blocks,tables")
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects unknown policies", func(t *testing.T) {
+		_, err := parseMarkdownRegions("code_blocks:skip")
+		assert.Error(t, err)
+	})
+}
+
+func TestMarkdownAwareFiltering(t *testing.T) {
+	p := Plugin{
+		configuration: &configuration{
+			CensorCharacter:     "*",
+			BadWordsList:        "ass,darn",
+			SkipMarkdownRegions: "code_blocks,inline_code,link_urls",
+		},
+	}
+	p.badWordsRegex = regexp.MustCompile(wordListToRegex(p.getConfiguration().BadWordsList, wordRegexTemplate))
+
+	for name, tc := range map[string]struct{ in, out string }{
+		"code blocks are untouched": {"darn\n```\nexport ass=1\n```", "****\n```\nexport ass=1\n```"},
+		"inline code is untouched":  {"set `ass` and darn", "set `ass` and ****"},
+		"link text is filtered":     {"[darn](https://example.com/darn)", "[****](https://example.com/darn)"},
+		"quotes are filtered":       {"> darn", "> ****"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rpost, reason := p.MessageWillBePosted(&plugin.Context{}, &model.Post{Message: tc.in})
+			assert.Empty(t, reason)
+			assert.Equal(t, tc.out, rpost.Message)
+		})
+	}
+
+	t.Run("regions do not hide words from reject rules", func(t *testing.T) {
+		rules, err := loadWordRules(`[{"id": "slurs", "pattern": "slur", "action": "reject"}]`)
+		assert.NoError(t, err)
+		p.structuredWordRules = rules
+		defer func() { p.structuredWordRules = nil }()
+		p.SetAPI(&ExtendedMockAPI{})
+
+		for _, in := range []string{"`slur`", "```\nslur", "[x](https://example.com/slur)"} {
+			result := p.checkPostBadWords(p.getConfiguration(), &model.Post{Message: in})
+			assert.Equal(t, FilterActionReject, result.Action, in)
+		}
+	})
+
+	t.Run("regions can escalate instead of censoring", func(t *testing.T) {
+		escalating := Plugin{configuration: &configuration{
+			CensorCharacter:     "*",
+			BadWordsList:        "darn",
+			SkipMarkdownRegions: "inline_code:hold,quotes:censor",
+		}}
+		escalating.badWordsRegex = p.badWordsRegex
+		escalating.SetAPI(&ExtendedMockAPI{})
+
+		result := escalating.checkPostBadWords(escalating.getConfiguration(), &model.Post{Message: "> darn `darn`"})
+		assert.Equal(t, FilterActionHold, result.Action)
+		assert.Equal(t, RuleActionHold, result.Rules[0].Action)
+
+		result = escalating.checkPostBadWords(escalating.getConfiguration(), &model.Post{Message: "> darn"})
+		assert.Equal(t, FilterActionCensor, result.Action)
+		assert.Equal(t, "> ****", result.Post.Message)
+	})
+}
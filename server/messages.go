@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// The messages the plugin sends to users. Each of them is a text/template rendered with
+// messageData, and can be translated per locale with the MessageTemplates setting.
+const (
+	messagePostRejected         = "post_rejected"
+	messagePostHeld             = "post_held"
+	messageDirectMessageBlocked = "direct_message_blocked"
+	messageDirectMessageError   = "direct_message_error"
+	messageUserQuarantined      = "user_quarantined"
+)
+
+const defaultLocale = "en"
+
+// defaultWarningMessage is the default of the WarningMessage setting. The setting predates
+// message templates, so it only overrides the post_rejected template once it is changed.
+const defaultWarningMessage = "Your post has been rejected by the Profanity Filter, because the following word is not allowed: `%s`."
+
+// builtinMessageFiles holds the bundled translations, one JSON object of templates keyed by
+// message per locale, e.g. messages/en.json.
+//
+//go:embed messages/*.json
+var builtinMessageFiles embed.FS
+
+// messageTemplates are parsed message templates, keyed by locale and then by message.
+type messageTemplates map[string]map[string]*template.Template
+
+var builtinMessages = mustLoadBuiltinMessages()
+
+// messageData is what message templates can refer to.
+type messageData struct {
+	// Words are the matched words, separated by commas.
+	Words string
+	// Categories are the categories of the matching rules, separated by commas.
+	Categories string
+	// Channel is the name of the channel the post was sent to.
+	Channel string
+	// BlockTime is how long new users are kept from sending direct messages.
+	BlockTime string
+	// RemainingTime is how long until the user may send direct messages.
+	RemainingTime string
+	// CodeOfConductURL is the CodeOfConductURL setting.
+	CodeOfConductURL string
+}
+
+func mustLoadBuiltinMessages() messageTemplates {
+	templates := messageTemplates{}
+	files, err := builtinMessageFiles.ReadDir("messages")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		data, err := builtinMessageFiles.ReadFile(path.Join("messages", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+		locale := strings.TrimSuffix(file.Name(), ".json")
+		parsed, err := parseMessageTemplates(fmt.Sprintf(`{%q: %s}`, locale, data))
+		if err != nil {
+			panic(err)
+		}
+		templates[locale] = parsed[locale]
+	}
+	return templates
+}
+
+// parseMessageTemplates parses the MessageTemplates setting: a JSON object of templates keyed
+// by locale and then by message, e.g. {"de": {"post_held": "..."}}.
+func parseMessageTemplates(templatesJSON string) (messageTemplates, error) {
+	if strings.TrimSpace(templatesJSON) == "" {
+		return messageTemplates{}, nil
+	}
+
+	var sources map[string]map[string]string
+	if err := json.Unmarshal([]byte(templatesJSON), &sources); err != nil {
+		return nil, errors.Wrap(err, "message templates must be a JSON object of templates keyed by locale")
+	}
+
+	templates := messageTemplates{}
+	for locale, messages := range sources {
+		templates[locale] = map[string]*template.Template{}
+		for message, source := range messages {
+			if !isKnownMessage(message) {
+				return nil, fmt.Errorf("unknown message %q for locale %s", message, locale)
+			}
+			parsed, err := template.New(message).Parse(source)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template for message %s in locale %s: %w", message, locale, err)
+			}
+			templates[locale][message] = parsed
+		}
+	}
+	return templates, nil
+}
+
+func isKnownMessage(message string) bool {
+	switch message {
+	case messagePostRejected, messagePostHeld, messageDirectMessageBlocked, messageDirectMessageError,
+		messageUserQuarantined:
+		return true
+	}
+	return false
+}
+
+// localeCandidates returns the locales to look for a translation in, most specific first:
+// "pt-BR" is tried as "pt-BR", then as "pt".
+func localeCandidates(locale string) []string {
+	var candidates []string
+	if locale != "" {
+		candidates = append(candidates, locale)
+		if base, _, found := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-"); found {
+			candidates = append(candidates, base)
+		}
+	}
+	return candidates
+}
+
+// renderMessage renders a message for a user in their locale. Templates from the
+// MessageTemplates setting take precedence over a changed WarningMessage, which takes
+// precedence over the bundled templates. English is used when there is no translation for the
+// locale.
+func (p *Plugin) renderMessage(configuration *configuration, userID, message string, data messageData) string {
+	data.CodeOfConductURL = configuration.CodeOfConductURL
+	locales := localeCandidates(p.userLocale(userID))
+
+	render := func(t *template.Template) (string, bool) {
+		if t == nil {
+			return "", false
+		}
+		var rendered bytes.Buffer
+		if err := t.Execute(&rendered, data); err != nil {
+			return "", false
+		}
+		return rendered.String(), true
+	}
+
+	for _, locale := range append(locales, defaultLocale) {
+		if rendered, ok := render(p.customMessages[locale][message]); ok {
+			return rendered
+		}
+	}
+	if message == messagePostRejected && configuration.WarningMessage != "" && configuration.WarningMessage != defaultWarningMessage {
+		if strings.Contains(configuration.WarningMessage, "%s") {
+			// WarningMessage predates templates and is a format string for the matched words.
+			return fmt.Sprintf(configuration.WarningMessage, data.Words)
+		}
+		if warning, err := template.New(message).Parse(configuration.WarningMessage); err == nil {
+			if rendered, ok := render(warning); ok {
+				return rendered
+			}
+		}
+	}
+	for _, locale := range append(locales, defaultLocale) {
+		if rendered, ok := render(builtinMessages[locale][message]); ok {
+			return rendered
+		}
+	}
+	return ""
+}
+
+// userLocale returns the locale of the user, or an empty string if it is unknown.
+func (p *Plugin) userLocale(userID string) string {
+	if userID == "" {
+		return ""
+	}
+	user, err := p.GetUserByID(userID)
+	if err != nil {
+		return ""
+	}
+	return user.Locale
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMessageTemplates(t *testing.T) {
+	t.Run("parses templates per locale", func(t *testing.T) {
+		templates, err := parseMessageTemplates(`{"de": {"post_held": "Zur Prüfung: {{.Words}}"}}`)
+		require.NoError(t, err)
+		assert.NotNil(t, templates["de"][messagePostHeld])
+	})
+
+	for name, templatesJSON := range map[string]string{
+		"not an object":    `["de"]`,
+		"unknown message":  `{"de": {"post_liked": "Danke"}}`,
+		"invalid template": `{"de": {"post_held": "{{.Words"}}`,
+	} {
+		t.Run("rejects "+name, func(t *testing.T) {
+			_, err := parseMessageTemplates(templatesJSON)
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("bundles English for every message", func(t *testing.T) {
+		for _, message := range []string{messagePostRejected, messagePostHeld, messageDirectMessageBlocked, messageDirectMessageError, messageUserQuarantined} {
+			assert.NotNil(t, builtinMessages[defaultLocale][message], message)
+		}
+	})
+}
+
+func TestRenderMessage(t *testing.T) {
+	newMessagePlugin := func(t *testing.T, templatesJSON string, locales map[string]string) *Plugin {
+		p, api := newTestPlugin(t, &configuration{MessageTemplates: templatesJSON})
+		api.GetUserFunc = func(userID string) (*model.User, *model.AppError) {
+			return &model.User{Id: userID, Locale: locales[userID]}, nil
+		}
+		return p
+	}
+
+	templatesJSON := `{
+		"de": {"post_held": "Dein Beitrag wird geprüft: {{.Words}}"},
+		"pt": {"post_held": "Sua mensagem está em revisão: {{.Words}}"}
+	}`
+	locales := map[string]string{"german": "de", "brazilian": "pt-BR", "french": "fr"}
+
+	for name, tc := range map[string]struct{ userID, out string }{
+		"translated":          {"german", "Dein Beitrag wird geprüft: abc"},
+		"regional locale":     {"brazilian", "Sua mensagem está em revisão: abc"},
+		"missing translation": {"french", "Your post has been held for review by a moderator."},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := newMessagePlugin(t, templatesJSON, locales)
+			assert.Equal(t, tc.out, p.renderMessage(p.getConfiguration(), tc.userID, messagePostHeld, messageData{Words: "abc"}))
+		})
+	}
+
+	t.Run("adds the code of conduct to the defaults", func(t *testing.T) {
+		p := newMessagePlugin(t, "", locales)
+		p.configuration.CodeOfConductURL = "https://example.com/coc"
+
+		assert.Equal(t,
+			"Configuration settings limit new users from sending private messages. Please read our [code of conduct](https://example.com/coc).",
+			p.renderMessage(p.getConfiguration(), "french", messageDirectMessageBlocked, messageData{}))
+	})
+
+	t.Run("warning message can be a format string or a template", func(t *testing.T) {
+		p := newMessagePlugin(t, templatesJSON, locales)
+
+		p.configuration.WarningMessage = "Not allowed: %s"
+		assert.Equal(t, "Not allowed: abc", p.renderMessage(p.getConfiguration(), "french", messagePostRejected, messageData{Words: "abc"}))
+
+		p.configuration.WarningMessage = "Not allowed in {{.Channel}}: {{.Words}}"
+		assert.Equal(t, "Not allowed in Town Square: abc",
+			p.renderMessage(p.getConfiguration(), "french", messagePostRejected, messageData{Words: "abc", Channel: "Town Square"}))
+	})
+
+	t.Run("the default warning message does not override the templates", func(t *testing.T) {
+		p := newMessagePlugin(t, `{"de": {"post_rejected": "Nicht erlaubt: {{.Words}}"}}`, locales)
+		p.configuration.WarningMessage = defaultWarningMessage
+		p.configuration.CodeOfConductURL = "https://example.com/coc"
+
+		assert.Equal(t,
+			"Your post has been rejected by the Profanity Filter, because the following word is not allowed: `abc`. Please read our [code of conduct](https://example.com/coc).",
+			p.renderMessage(p.getConfiguration(), "french", messagePostRejected, messageData{Words: "abc"}))
+
+		p.configuration.WarningMessage = "Not allowed: %s"
+		assert.Equal(t, "Nicht erlaubt: abc", p.renderMessage(p.getConfiguration(), "german", messagePostRejected, messageData{Words: "abc"}))
+	})
+
+	t.Run("the default warning message is the default of the setting", func(t *testing.T) {
+		for _, setting := range manifest.SettingsSchema.Settings {
+			if setting.Key == "WarningMessage" {
+				assert.Equal(t, defaultWarningMessage, setting.Default)
+			}
+		}
+	})
+}
+
+func TestLocalizedWarnings(t *testing.T) {
+	var warning string
+	p, api := newTestPlugin(t, &configuration{
+		BadWordsList:       "abc",
+		RejectPosts:        true,
+		BlockNewUserPM:     true,
+		BlockNewUserPMTime: "24h",
+		PostFilters:        "direct_messages",
+		MessageTemplates:   `{"de": {"direct_message_blocked": "Neue Nutzer dürfen erst in {{.RemainingTime}} private Nachrichten senden."}}`,
+	})
+
+	createdAt := time.Now().Add(-4 * time.Hour)
+	api.GetUserFunc = func(userID string) (*model.User, *model.AppError) {
+		return &model.User{Id: userID, Locale: "de", CreateAt: createdAt.UnixMilli()}, nil
+	}
+	api.SendEphemeralPostFunc = func(userID string, post *model.Post) *model.Post {
+		warning = post.Message
+		return post
+	}
+
+	rpost, _ := p.FilterPost(&model.Post{UserId: "user", ChannelId: "dm", Message: "hello"})
+	assert.Nil(t, rpost)
+	assert.Equal(t, "Neue Nutzer dürfen erst in 20h0m0s private Nachrichten senden.", warning)
+}
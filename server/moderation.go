@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	botUsername    = "community-toolkit"
+	botDisplayName = "Community Toolkit"
+	botDescription = "Posts moderation requests and reports of the Community Toolkit plugin."
+)
+
+// ensureBot creates the bot the plugin posts as, or updates it if it already exists.
+func (p *Plugin) ensureBot() error {
+	botUserID, err := p.API.EnsureBotUser(&model.Bot{
+		Username:    botUsername,
+		DisplayName: botDisplayName,
+		Description: botDescription,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to ensure bot user")
+	}
+	p.botUserID = botUserID
+	return nil
+}
+
+// resolveActingUser looks up the user of the ActingUsername setting, who must be an active
+// system admin.
+func (p *Plugin) resolveActingUser(configuration *configuration) error {
+	username := strings.TrimPrefix(strings.TrimSpace(configuration.ActingUsername), "@")
+	if username == "" {
+		return nil
+	}
+
+	user, appErr := p.API.GetUserByUsername(username)
+	if appErr != nil {
+		return errors.Wrapf(appErr, "user %s not found", username)
+	}
+	if user.DeleteAt != 0 {
+		return fmt.Errorf("user %s is deactivated", username)
+	}
+	if !p.API.HasPermissionTo(user.Id, model.PermissionManageSystem) {
+		return fmt.Errorf("user %s is not a system admin", username)
+	}
+	configuration.actingUserID = user.Id
+	return nil
+}
+
+// actingUserID returns the user the plugin acts as when it changes other users: the user of
+// the ActingUsername setting, or the plugin's bot.
+func (p *Plugin) actingUserID() string {
+	if actingUserID := p.getConfiguration().actingUserID; actingUserID != "" {
+		return actingUserID
+	}
+	return p.botUserID
+}
+
+// isModerator reports whether the user may review held posts: active system admins and the
+// active users listed in the Moderators setting. The user is fetched afresh rather than from
+// the cache, so that deactivations and renames take effect at once.
+func (p *Plugin) isModerator(userID string) bool {
+	if userID == "" {
+		return false
+	}
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil || user.DeleteAt != 0 {
+		return false
+	}
+	if p.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		return true
+	}
+
+	for _, username := range strings.Split(p.getConfiguration().Moderators, ",") {
+		username = strings.TrimPrefix(strings.TrimSpace(username), "@")
+		if username != "" && strings.EqualFold(username, user.Username) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The normalization stages that can be enabled with the TextNormalization setting. They run in
+// this order, whatever the order of the setting.
+const (
+	normalizeInvisible   = "invisible"
+	normalizeConfusables = "confusables"
+	normalizeLeetspeak   = "leetspeak"
+	normalizeSeparators  = "separators"
+	normalizeRepeats     = "repeats"
+)
+
+var normalizationStages = []string{
+	normalizeInvisible,
+	normalizeConfusables,
+	normalizeLeetspeak,
+	normalizeSeparators,
+	normalizeRepeats,
+}
+
+// confusables maps letters of other scripts that look like Latin letters to those letters.
+// Fullwidth and mathematical letters are handled by NFKC instead.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'з': '3', 'і': 'i', 'ї': 'i', 'ј': 'j', 'к': 'k',
+	'м': 'm', 'н': 'h', 'о': 'o', 'п': 'n', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x',
+	'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ү': 'y', 'һ': 'h',
+	'А': 'A', 'В': 'B', 'Е': 'E', 'Ё': 'E', 'І': 'I', 'Ј': 'J', 'К': 'K', 'М': 'M', 'Н': 'H',
+	'О': 'O', 'Р': 'P', 'С': 'C', 'Т': 'T', 'У': 'Y', 'Х': 'X', 'Ѕ': 'S', 'Ү': 'Y', 'Һ': 'H',
+	// Greek
+	'α': 'a', 'β': 'b', 'γ': 'y', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K', 'Μ': 'M', 'Ν': 'N',
+	'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+	// Latin lookalikes
+	'ı': 'i', 'ɡ': 'g', 'ɑ': 'a', 'ʟ': 'l', 'ꞵ': 'b',
+}
+
+// leetspeak maps the usual character substitutions back to the letters they stand for.
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's', '!': 'i', '|': 'l', '+': 't', '€': 'e',
+}
+
+// parseNormalizationStages validates the TextNormalization setting and returns the enabled stages.
+func parseNormalizationStages(setting string) (map[string]bool, error) {
+	enabled := map[string]bool{}
+	for _, name := range strings.Split(setting, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		known := false
+		for _, stage := range normalizationStages {
+			known = known || stage == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown normalization stage: %q", name)
+		}
+		enabled[name] = true
+	}
+	return enabled, nil
+}
+
+// normalizeText returns the variants of a text that filters should match against, each mapped
+// back to the original text. The first variant only has its accents stripped, so patterns
+// written for the literal text keep matching; the others have the configured normalization
+// stages applied on top, so that evasions of the plain word list are caught too.
+func normalizeText(configuration *configuration, s string) []*mappedText {
+	base := withoutAccents(s)
+	variants := []*mappedText{base}
+
+	// Invalid stage names are reported when the configuration is loaded.
+	stages, _ := parseNormalizationStages(configuration.TextNormalization)
+	if len(stages) == 0 {
+		return variants
+	}
+
+	normalized := base
+	if stages[normalizeInvisible] {
+		normalized = normalized.mapRunes(removeInvisible)
+	}
+	if stages[normalizeConfusables] {
+		normalized = normalized.mapRunes(foldConfusable)
+	}
+	if stages[normalizeLeetspeak] {
+		normalized = foldLeetspeak(normalized)
+	}
+	if stages[normalizeSeparators] {
+		normalized = joinSeparatedLetters(normalized)
+	}
+
+	candidates := []*mappedText{normalized}
+	if stages[normalizeRepeats] {
+		// Collapsing every repeated letter catches "fuuuck" but also turns "ass" into "as",
+		// so a second variant only shortens runs of three or more letters to two.
+		candidates = []*mappedText{collapseRepeats(normalized, 2, 1), collapseRepeats(normalized, 3, 2)}
+	}
+
+	for _, candidate := range candidates {
+		duplicate := false
+		for _, variant := range variants {
+			duplicate = duplicate || variant.text == candidate.text
+		}
+		if !duplicate {
+			variants = append(variants, candidate)
+		}
+	}
+	return variants
+}
+
+// removeInvisible drops zero-width and other formatting characters that do not render.
+func removeInvisible(r rune) string {
+	if unicode.Is(unicode.Cf, r) || unicode.Is(unicode.Variation_Selector, r) {
+		return ""
+	}
+	return string(r)
+}
+
+// foldConfusable replaces a character that looks like a Latin letter with that letter.
+func foldConfusable(r rune) string {
+	if folded, ok := confusables[r]; ok {
+		return string(folded)
+	}
+	if r < utf8.RuneSelf {
+		return string(r)
+	}
+	return norm.NFKC.String(string(r))
+}
+
+// foldLeetspeak replaces leetspeak substitutions in words that contain at least one letter, so
+// that numbers are left alone. Exclamation marks ending a word are punctuation, not an "i".
+func foldLeetspeak(m *mappedText) *mappedText {
+	b := m.builder()
+	runes := []rune(m.text)
+	offsets := runeOffsets(m.text)
+
+	for i := 0; i < len(runes); {
+		if !isLeetWordCharacter(runes[i]) {
+			b.write(string(runes[i]), offsets[i], offsets[i+1])
+			i++
+			continue
+		}
+
+		end := i
+		hasLetter := false
+		for end < len(runes) && isLeetWordCharacter(runes[end]) {
+			hasLetter = hasLetter || unicode.IsLetter(runes[end])
+			end++
+		}
+		wordEnd := end
+		for wordEnd > i && runes[wordEnd-1] == '!' {
+			wordEnd--
+		}
+
+		for j := i; j < end; j++ {
+			replacement := runes[j]
+			if folded, ok := leetspeak[runes[j]]; ok && hasLetter && j < wordEnd {
+				replacement = folded
+			}
+			b.write(string(replacement), offsets[j], offsets[j+1])
+		}
+		i = end
+	}
+	return b.build()
+}
+
+func isLeetWordCharacter(r rune) bool {
+	_, leet := leetspeak[r]
+	return leet || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// joinSeparatedLetters removes the separators from runs of at least three single letters, so
+// that "f u c k" and "f.u.c.k" read as "fuck".
+func joinSeparatedLetters(m *mappedText) *mappedText {
+	const minimumLetters = 3
+
+	b := m.builder()
+	runes := []rune(m.text)
+	offsets := runeOffsets(m.text)
+
+	// isSingleLetter reports whether runes[i] is a letter standing on its own.
+	isSingleLetter := func(i int) bool {
+		return unicode.IsLetter(runes[i]) &&
+			(i == 0 || !unicode.IsLetter(runes[i-1])) &&
+			(i+1 == len(runes) || !unicode.IsLetter(runes[i+1]))
+	}
+	isSeparator := func(r rune) bool {
+		return r != '\n' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+
+	for i := 0; i < len(runes); {
+		if !isSingleLetter(i) {
+			b.write(string(runes[i]), offsets[i], offsets[i+1])
+			i++
+			continue
+		}
+
+		letters := []int{i}
+		next := i + 1
+		for {
+			j := next
+			for j < len(runes) && j-next < 3 && isSeparator(runes[j]) {
+				j++
+			}
+			if j == next || j >= len(runes) || !isSingleLetter(j) {
+				break
+			}
+			letters = append(letters, j)
+			next = j + 1
+		}
+
+		if len(letters) < minimumLetters {
+			b.write(string(runes[i]), offsets[i], offsets[i+1])
+			i++
+			continue
+		}
+		for _, letter := range letters {
+			b.write(string(runes[letter]), offsets[letter], offsets[letter+1])
+		}
+		i = next
+	}
+	return b.build()
+}
+
+// collapseRepeats shortens every run of at least minimum identical letters to length letters.
+func collapseRepeats(m *mappedText, minimum, length int) *mappedText {
+	b := m.builder()
+	runes := []rune(m.text)
+	offsets := runeOffsets(m.text)
+
+	for i := 0; i < len(runes); {
+		end := i + 1
+		for end < len(runes) && unicode.IsLetter(runes[i]) && unicode.ToLower(runes[end]) == unicode.ToLower(runes[i]) {
+			end++
+		}
+		keep := end - i
+		if keep >= minimum {
+			keep = length
+		}
+		for j := i; j < i+keep; j++ {
+			b.write(string(runes[j]), offsets[j], offsets[j+1])
+		}
+		if keep < end-i {
+			// The dropped letters still belong to the run, so a match covers all of them.
+			b.ends[len(b.ends)-1] = m.ends[offsets[end]-1]
+		}
+		i = end
+	}
+	return b.build()
+}
+
+// runeOffsets returns the byte offset of every rune of s, followed by len(s).
+func runeOffsets(s string) []int {
+	offsets := make([]int, 0, len(s)+1)
+	for i := range s {
+		offsets = append(offsets, i)
+	}
+	return append(offsets, len(s))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+)
+
+const allNormalizationStages = "invisible,confusables,leetspeak,separators,repeats"
+
+func TestNormalizeText(t *testing.T) {
+	texts := func(variants []*mappedText) []string {
+		var out []string
+		for _, variant := range variants {
+			out = append(out, variant.text)
+		}
+		return out
+	}
+
+	t.Run("only strips accents without normalization stages", func(t *testing.T) {
+		assert.Equal(t, []string{"dArn"}, texts(normalizeText(&configuration{}, "dÀrn")))
+	})
+
+	for name, tc := range map[string]struct{ stages, in, out string }{
+		"zero-width characters":  {"invisible", "d\u200ba\u200drn", "darn"},
+		"soft hyphens":           {"invisible", "da\u00adrn", "darn"},
+		"cyrillic lookalikes":    {"confusables", "d\u0430rn", "darn"},
+		"greek lookalikes":       {"confusables", "dαrn", "darn"},
+		"fullwidth letters":      {"confusables", "ｄａｒｎ", "darn"},
+		"leetspeak":              {"leetspeak", "d4rn h3ll0", "darn hello"},
+		"leetspeak symbols":      {"leetspeak", "b@d$", "bads"},
+		"numbers are left alone": {"leetspeak", "since 2024", "since 2024"},
+		"trailing exclamations":  {"leetspeak", "wow!! sh!t", "wow!! shit"},
+		"spaced letters":         {"separators", "say d a r n now", "say darn now"},
+		"dotted letters":         {"separators", "d.a.r.n", "darn"},
+		"short runs are kept":    {"separators", "a b", "a b"},
+		"all stages combined":    {allNormalizationStages, "d \u200b\u0430 . r n", "darn"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			variants := normalizeText(&configuration{TextNormalization: tc.stages}, tc.in)
+			assert.Equal(t, tc.out, variants[len(variants)-1].text)
+		})
+	}
+
+	t.Run("repeated letters produce a collapsed and a shortened variant", func(t *testing.T) {
+		variants := normalizeText(&configuration{TextNormalization: "repeats"}, "daaarn bosssss")
+		assert.Equal(t, []string{"daaarn bosssss", "darn bos", "daarn boss"}, texts(variants))
+	})
+
+	t.Run("rejects unknown stages", func(t *testing.T) {
+		_, err := parseNormalizationStages("leetspeak,rot13")
+		assert.Error(t, err)
+	})
+}
+
+func TestNormalizedMatching(t *testing.T) {
+	p := Plugin{
+		configuration: &configuration{
+			CensorCharacter:   "*",
+			BadWordsList:      "darn,boss",
+			TextNormalization: allNormalizationStages,
+		},
+	}
+	p.badWordsRegex = regexp.MustCompile(wordListToRegex(p.getConfiguration().BadWordsList, wordRegexTemplate))
+
+	for name, tc := range map[string]struct{ in, out string }{
+		"plain":          {"oh darn it", "oh **** it"},
+		"cyrillic":       {"oh d\u0430rn it", "oh **** it"},
+		"zero-width":     {"oh da\u200brn it", "oh **** it"},
+		"leetspeak":      {"oh d4rn it", "oh **** it"},
+		"spaced letters": {"oh d a r n it", "oh ******* it"},
+		"repeats":        {"oh daaaarn it", "oh ******* it"},
+		"double letters": {"the bosssss", "the *******"},
+		"clean text":     {"a darning needle", "a darning needle"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rpost, reason := p.MessageWillBePosted(&plugin.Context{}, &model.Post{Message: tc.in})
+			assert.Empty(t, reason)
+			assert.Equal(t, tc.out, rpost.Message)
+		})
+	}
+
+	t.Run("usernames are normalized too", func(t *testing.T) {
+		p.badUsernamesRegex = regexp.MustCompile(wordListToRegex("baduser", `(?mi)(%s)`))
+
+		assert.Error(t, p.checkBadUsername(&model.User{Username: "b4duser"}))
+		assert.Error(t, p.checkBadUsername(&model.User{Username: "ok", Nickname: "b\u0430dus\u0435r"}))
+		assert.NoError(t, p.checkBadUsername(&model.User{Username: "gooduser"}))
+	})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// The moderation events reported to the moderation channel. The ModerationNotifications setting
+// selects which of them are posted.
+const (
+	eventUserSanitized        = "user_sanitized"
+	eventUserFlagged          = "user_flagged"
+	eventCleanupFailed        = "cleanup_failed"
+	eventPostRejected         = "post_rejected"
+	eventPostCensored         = "post_censored"
+	eventPostFlagged          = "post_flagged"
+	eventDirectMessageBlocked = "direct_message_blocked"
+	eventShadowDecision       = "shadow"
+)
+
+var moderationEvents = []string{
+	eventUserSanitized,
+	eventUserFlagged,
+	eventCleanupFailed,
+	eventPostRejected,
+	eventPostCensored,
+	eventPostFlagged,
+	eventDirectMessageBlocked,
+	eventShadowDecision,
+}
+
+var moderationEventTitles = map[string]string{
+	eventUserSanitized:        "User sanitized and deactivated",
+	eventUserFlagged:          "New user flagged",
+	eventCleanupFailed:        "User cleanup failed",
+	eventPostRejected:         "Post rejected",
+	eventPostCensored:         "Post censored",
+	eventPostFlagged:          "Post flagged by an alert rule",
+	eventDirectMessageBlocked: "Direct message blocked",
+}
+
+var moderationEventColors = map[string]string{
+	eventUserSanitized:        "#d24b4e",
+	eventUserFlagged:          "#ffbc1f",
+	eventCleanupFailed:        "#d24b4e",
+	eventPostRejected:         "#d24b4e",
+	eventPostCensored:         "#ffbc1f",
+	eventPostFlagged:          "#ffbc1f",
+	eventDirectMessageBlocked: "#8b8b8b",
+	eventShadowDecision:       "#8b8b8b",
+}
+
+// moderationNotification is a single moderation action reported to the moderation channel.
+type moderationNotification struct {
+	event string
+	// title overrides the title of the event.
+	title string
+
+	userID    string
+	channelID string
+	// postID is set when the post exists, so that the notification can link to it.
+	postID string
+
+	fields []*model.SlackAttachmentField
+	// actions are buttons moderators can act on the notification with.
+	actions []*model.PostAction
+}
+
+// parseModerationEvents validates the ModerationNotifications setting and returns the events
+// to post.
+func parseModerationEvents(setting string) (map[string]bool, error) {
+	enabled := map[string]bool{}
+	for _, name := range strings.Split(setting, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		known := false
+		for _, event := range moderationEvents {
+			known = known || event == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown moderation event: %q", name)
+		}
+		enabled[name] = true
+	}
+	return enabled, nil
+}
+
+// notifyModerators posts a moderation action to the moderation channel, if one is configured
+// and the event is enabled.
+func (p *Plugin) notifyModerators(notification *moderationNotification) {
+	configuration := p.getConfiguration()
+	if configuration.ModerationChannelID == "" {
+		return
+	}
+	// Invalid event names are reported when the configuration is loaded.
+	if events, _ := parseModerationEvents(configuration.ModerationNotifications); !events[notification.event] {
+		return
+	}
+
+	title := notification.title
+	if title == "" {
+		title = moderationEventTitles[notification.event]
+	}
+
+	var fields []*model.SlackAttachmentField
+	if notification.userID != "" {
+		fields = append(fields, &model.SlackAttachmentField{Title: "User", Value: p.userMention(notification.userID), Short: true})
+	}
+	if notification.channelID != "" {
+		fields = append(fields, &model.SlackAttachmentField{Title: "Channel", Value: p.channelLink(notification.channelID), Short: true})
+	}
+	for _, field := range notification.fields {
+		if value, ok := field.Value.(string); !ok || value != "" {
+			fields = append(fields, field)
+		}
+	}
+	if notification.postID != "" {
+		if permalink := p.permalink(notification.channelID, notification.postID); permalink != "" {
+			fields = append(fields, &model.SlackAttachmentField{Title: "Post", Value: permalink})
+		}
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: configuration.ModerationChannelID,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		Fallback: title,
+		Color:    moderationEventColors[notification.event],
+		Title:    title,
+		Fields:   fields,
+		Actions:  notification.actions,
+	}})
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		p.API.LogWarn("Failed to post moderation notification", "event", notification.event, "err", appErr.Error())
+	}
+}
+
+// notifyFilterResult reports the decision of a post filter to the moderators.
+func (p *Plugin) notifyFilterResult(feature string, post *model.Post, result *FilterResult, shadow bool) {
+	notification := &moderationNotification{
+		userID:    post.UserId,
+		channelID: post.ChannelId,
+		postID:    post.Id,
+		fields: []*model.SlackAttachmentField{
+			{Title: "Rules", Value: ruleIDs(result.Rules), Short: true},
+			{Title: "Matches", Value: strings.Join(result.Matches, ", "), Short: true},
+			{Title: "Reason", Value: result.Reason},
+		},
+	}
+
+	switch {
+	case shadow:
+		notification.event = eventShadowDecision
+		notification.title = fmt.Sprintf("Shadow mode: %s would %s a post", feature, result.Action)
+	case result.Action == FilterActionReject && feature == directMessagesFilterName:
+		notification.event = eventDirectMessageBlocked
+	case result.Action == FilterActionReject:
+		notification.event = eventPostRejected
+	case result.Action == FilterActionCensor:
+		notification.event = eventPostCensored
+	case result.Action == FilterActionAllow && len(result.Rules) > 0:
+		notification.event = eventPostFlagged
+	default:
+		// Held posts have a review request of their own.
+		return
+	}
+
+	p.notifyModerators(notification)
+}
+
+// userMention returns an @mention of the user, or the user ID if the user cannot be found.
+func (p *Plugin) userMention(userID string) string {
+	if user, err := p.GetUserByID(userID); err == nil && user.Username != "" {
+		return "@" + user.Username
+	}
+	return userID
+}
+
+// channelName returns the display name of the channel, for messages that cannot link to it.
+func (p *Plugin) channelName(channelID string) string {
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return channelID
+	}
+	if channel.Type == model.ChannelTypeDirect || channel.Type == model.ChannelTypeGroup {
+		return "a direct message"
+	}
+	return channel.DisplayName
+}
+
+// channelLink returns a link to the channel, or its name if it cannot be linked to.
+func (p *Plugin) channelLink(channelID string) string {
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return channelID
+	}
+	if channel.Type == model.ChannelTypeDirect || channel.Type == model.ChannelTypeGroup {
+		return "Direct message"
+	}
+
+	team, appErr := p.API.GetTeam(channel.TeamId)
+	if appErr != nil {
+		return channel.DisplayName
+	}
+	return fmt.Sprintf("[%s](%s/%s/channels/%s)", channel.DisplayName, p.siteURL(), team.Name, channel.Name)
+}
+
+// permalink returns the link to a post, or an empty string if it cannot be linked to.
+func (p *Plugin) permalink(channelID, postID string) string {
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return ""
+	}
+
+	if channel.TeamId == "" {
+		// Direct messages are private to their members.
+		return ""
+	}
+	team, appErr := p.API.GetTeam(channel.TeamId)
+	if appErr != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/pl/%s", p.siteURL(), team.Name, postID)
+}
+
+func (p *Plugin) siteURL() string {
+	if config := p.API.GetConfig(); config != nil && config.ServiceSettings.SiteURL != nil {
+		return strings.TrimSuffix(*config.ServiceSettings.SiteURL, "/")
+	}
+	return ""
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModerationNotifications(t *testing.T) {
+	newNotifyingPlugin := func(t *testing.T, events string) (*Plugin, *MembershipMockAPI) {
+		p, api := newTestPlugin(t, &configuration{
+			CensorCharacter:         "*",
+			BadWordsList:            "darn",
+			BadUsernamesList:        "baduser",
+			WarningMessage:          "Not allowed: %s",
+			ModerationChannelID:     "moderation",
+			ModerationNotifications: events,
+		})
+		api.GetUserFunc = func(userID string) (*model.User, *model.AppError) {
+			return &model.User{Id: userID, Username: userID}, nil
+		}
+		api.GetChannelFunc = func(channelID string) (*model.Channel, *model.AppError) {
+			return &model.Channel{Id: channelID, TeamId: "team", Name: "town-square", DisplayName: "Town Square", Type: model.ChannelTypeOpen}, nil
+		}
+		return p, api
+	}
+
+	notifications := func(api *MembershipMockAPI) []*model.SlackAttachment {
+		var attachments []*model.SlackAttachment
+		for _, post := range api.posts {
+			if post.ChannelId == "moderation" {
+				attachments = append(attachments, post.Attachments()...)
+			}
+		}
+		return attachments
+	}
+	field := func(attachment *model.SlackAttachment, title string) any {
+		for _, field := range attachment.Fields {
+			if field.Title == title {
+				return field.Value
+			}
+		}
+		return nil
+	}
+
+	t.Run("censored edits link to the post", func(t *testing.T) {
+		p, api := newNotifyingPlugin(t, eventPostCensored)
+
+		post := &model.Post{Id: "post", UserId: "author", ChannelId: "channel", Message: "oh darn"}
+		rpost, _ := p.MessageWillBeUpdated(&plugin.Context{}, post, post)
+		assert.Equal(t, "oh ****", rpost.Message)
+
+		sent := notifications(api)
+		require.Len(t, sent, 1)
+		assert.Equal(t, "Post censored", sent[0].Title)
+		assert.Equal(t, "@author", field(sent[0], "User"))
+		assert.Equal(t, "[Town Square](https://chat.example.com/community/channels/town-square)", field(sent[0], "Channel"))
+		assert.Equal(t, badWordsListRuleID, field(sent[0], "Rules"))
+		assert.Equal(t, "darn", field(sent[0], "Matches"))
+		assert.Equal(t, "https://chat.example.com/community/pl/post", field(sent[0], "Post"))
+	})
+
+	t.Run("rejected posts are reported", func(t *testing.T) {
+		p, api := newNotifyingPlugin(t, eventPostRejected)
+		p.configuration.RejectPosts = true
+
+		rpost, _ := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "author", ChannelId: "channel", Message: "oh darn"})
+		assert.Nil(t, rpost)
+
+		sent := notifications(api)
+		require.Len(t, sent, 1)
+		assert.Equal(t, "Post rejected", sent[0].Title)
+		assert.Contains(t, field(sent[0], "Reason"), "darn")
+		assert.Nil(t, field(sent[0], "Post"))
+	})
+
+	t.Run("only enabled events are posted", func(t *testing.T) {
+		p, api := newNotifyingPlugin(t, eventPostRejected)
+
+		rpost, _ := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "author", ChannelId: "channel", Message: "oh darn"})
+		assert.Equal(t, "oh ****", rpost.Message)
+		assert.Empty(t, notifications(api))
+	})
+
+	t.Run("sanitized users are reported", func(t *testing.T) {
+		p, api := newNotifyingPlugin(t, eventUserSanitized)
+
+		p.UserHasBeenCreated(&plugin.Context{}, &model.User{Id: "user", Username: "baduser", Email: "bad@example.com"})
+
+		sent := notifications(api)
+		require.Len(t, sent, 1)
+		assert.Equal(t, "User sanitized and deactivated", sent[0].Title)
+		assert.Equal(t, "baduser", field(sent[0], "Username"))
+		assert.Contains(t, field(sent[0], "Reasons"), "username matches moderation list")
+	})
+
+	t.Run("the bot's own posts are not filtered", func(t *testing.T) {
+		p, _ := newNotifyingPlugin(t, "")
+
+		rpost, reason := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "bot", Message: "matched: darn"})
+		assert.Empty(t, reason)
+		assert.Equal(t, "matched: darn", rpost.Message)
+	})
+
+	t.Run("rejects unknown events", func(t *testing.T) {
+		_, err := parseModerationEvents("post_rejected,post_liked")
+		assert.Error(t, err)
+	})
+}
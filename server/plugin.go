@@ -24,10 +24,112 @@ type Plugin struct {
 	badWordsRegex     *regexp.Regexp
 	badDomainsRegex   *regexp.Regexp
 	badUsernamesRegex *regexp.Regexp
-
-	badDomainsList *[]string
+	allowedWordsRegex *regexp.Regexp
+
+	// badWordsEntries are the entries of the BadWordsList setting, compiled one by one to report
+	// which entry matched.
+	badWordsEntries []listEntry
+
+	// structuredWordRules are the compiled rules of the WordRules setting.
+	structuredWordRules []*compiledRule
+
+	// storedRules are the compiled rules managed through the API, keyed by kind. Consult
+	// getStoredRules and loadStoredRules for usage.
+	storedRulesLock sync.RWMutex
+	storedRules     map[string][]*compiledRule
+
+	// quarantined are the users kept from posting, keyed by user id. Consult isQuarantined and
+	// loadQuarantine for usage.
+	quarantineLock sync.RWMutex
+	quarantined    map[string]*QuarantinedUser
+
+	// customMessages are the parsed templates of the MessageTemplates setting.
+	customMessages messageTemplates
+
+	// badDomains are the built-in domain lists, if enabled, and uploadedDomains the domain lists
+	// managed through the API. checkedDomains combines them whenever either changes. Consult
+	// updateDomainLists and checkedDomainLists for usage.
+	domainListsLock sync.RWMutex
+	badDomains      domainLists
+	uploadedDomains domainLists
+	checkedDomains  domainLists
+
+	// allowedDomains are the only domains new users may sign up with, if set.
+	allowedDomains *domainSet
+	// teamAllowedDomains are the only domains the members of a team may have, keyed by team
+	// name or id.
+	teamAllowedDomains map[string]*domainSet
 
 	cache *LRUCache
+
+	// botUserID is the user the plugin posts as.
+	botUserID string
+
+	// approvals holds the one-time tokens of held posts that are being republished.
+	approvals sync.Map
+
+	// stopCleanupRetries stops the background retries of failed cleanups.
+	stopCleanupRetries func()
+
+	// auditQueue holds the audit records waiting for the background writer, which
+	// stopAuditWriter stops. It is nil while the writer is not running.
+	auditLock       sync.RWMutex
+	auditQueue      chan *AuditRecord
+	stopAuditWriter func()
+}
+
+// Plugin Callback: OnActivate
+func (p *Plugin) OnActivate() error {
+	if err := p.ensureBot(); err != nil {
+		return err
+	}
+	if err := p.registerCommand(); err != nil {
+		return err
+	}
+	if err := p.loadStoredRules(); err != nil {
+		return err
+	}
+	if err := p.loadQuarantine(); err != nil {
+		return err
+	}
+	if err := p.loadDomainLists(); err != nil {
+		return err
+	}
+
+	p.startCleanupRetries()
+	p.startAuditWriter()
+	return nil
+}
+
+// Plugin Callback: OnDeactivate
+func (p *Plugin) OnDeactivate() error {
+	if p.stopCleanupRetries != nil {
+		p.stopCleanupRetries()
+	}
+	if p.stopAuditWriter != nil {
+		p.stopAuditWriter()
+	}
+	return nil
+}
+
+// Plugin Callback: OnPluginClusterEvent
+// Reloads the rules and domain lists managed through the API and the quarantined users when
+// they were changed on another server.
+func (p *Plugin) OnPluginClusterEvent(_ *plugin.Context, ev model.PluginClusterEvent) {
+	switch ev.Id {
+	case storedRulesChangedEvent:
+		if err := p.loadStoredRules(); err != nil {
+			p.API.LogError("Failed to reload rules", "err", err.Error())
+		}
+	case quarantineChangedEvent:
+		if err := p.loadQuarantine(); err != nil {
+			p.API.LogError("Failed to reload quarantined users", "err", err.Error())
+		}
+	case domainListsChangedEvent:
+		if err := p.loadDomainLists(); err != nil {
+			p.API.LogError("Failed to reload domain lists", "err", err.Error())
+		}
+	}
 }
 
 // Plugin Callback: MessageWillBePosted
@@ -40,6 +142,9 @@ func (p *Plugin) MessageWillBeUpdated(_ *plugin.Context, newPost *model.Post, _
 	return p.FilterPost(newPost)
 }
 
+// FilterPost runs the post through every filter of the configured pipeline. Censoring filters
+// rewrite the post for the filters after them; the first filter that rejects or holds the post
+// stops the pipeline.
 func (p *Plugin) FilterPost(post *model.Post) (*model.Post, string) {
 	configuration := p.getConfiguration()
 	_, fromBot := post.GetProps()["from_bot"]
@@ -48,11 +153,30 @@ func (p *Plugin) FilterPost(post *model.Post) (*model.Post, string) {
 		return post, ""
 	}
 
-	if configuration.BlockNewUserPM && p.isDirectMessage(post.ChannelId) {
-		return p.FilterDirectMessage(configuration, post)
+	// The plugin's own moderation posts quote the content they report.
+	if p.botUserID != "" && post.UserId == p.botUserID {
+		return post, ""
+	}
+
+	if p.isApprovedPost(post) {
+		return post, ""
+	}
+
+	if p.isQuarantined(post.UserId) {
+		if result := p.checkQuarantine(configuration, post); result.Action != FilterActionAllow {
+			return p.enforceFilterResult(configuration, quarantineFeature, post, result)
+		}
+	}
+
+	for _, filter := range p.postFilterPipeline(configuration) {
+		filtered, reason := p.enforceFilterResult(configuration, filter.Name(), post, filter.Filter(configuration, post))
+		if filtered == nil {
+			return nil, reason
+		}
+		post = filtered
 	}
 
-	return p.FilterPostBadWords(configuration, post)
+	return post, ""
 }
 
 func (p *Plugin) GetUserByID(userID string) (*model.User, error) {
@@ -70,10 +194,18 @@ func (p *Plugin) GetUserByID(userID string) (*model.User, error) {
 }
 
 func (p *Plugin) FilterDirectMessage(configuration *configuration, post *model.Post) (*model.Post, string) {
+	return p.enforceFilterResult(configuration, directMessagesFilterName, post, p.checkDirectMessage(configuration, post))
+}
+
+// checkDirectMessage decides whether the author of a direct message is old enough to send it.
+func (p *Plugin) checkDirectMessage(configuration *configuration, post *model.Post) *FilterResult {
 	user, err := p.GetUserByID(post.UserId)
 	if err != nil {
-		p.sendUserEphemeralMessageForPost(post, "Something went wrong when sending your message. Contact an administrator.")
-		return nil, "Failed to get user"
+		return &FilterResult{
+			Action:  FilterActionReject,
+			Reason:  "Failed to get user",
+			Warning: p.renderMessage(configuration, post.UserId, messageDirectMessageError, messageData{}),
+		}
 	}
 
 	userCreateSeconds := user.CreateAt / 1000
@@ -82,74 +214,265 @@ func (p *Plugin) FilterDirectMessage(configuration *configuration, post *model.P
 	duration, parseErr := time.ParseDuration(blockDuration)
 
 	if parseErr != nil {
-		p.sendUserEphemeralMessageForPost(post, "Something went wrong when sending your message. Contact an administrator.")
-		return nil, "failed to parse duration"
+		return &FilterResult{
+			Action:  FilterActionReject,
+			Reason:  "failed to parse duration",
+			Warning: p.renderMessage(configuration, post.UserId, messageDirectMessageError, messageData{}),
+		}
 	}
 
-	if time.Since(createdAt) < duration {
-		p.sendUserEphemeralMessageForPost(post, "Configuration settings limit new users from sending private messages.")
-		return nil, fmt.Sprintf("New user not allowed to send DM for %s.", duration)
+	if remaining := duration - time.Since(createdAt); remaining > 0 {
+		return &FilterResult{
+			Action: FilterActionReject,
+			Reason: fmt.Sprintf("New user not allowed to send DM for %s.", duration),
+			Warning: p.renderMessage(configuration, post.UserId, messageDirectMessageBlocked, messageData{
+				BlockTime:     duration.String(),
+				RemainingTime: remaining.Round(time.Minute).String(),
+			}),
+		}
 	}
-	return post, ""
+	return allowPost()
 }
 
 func (p *Plugin) FilterPostBadWords(configuration *configuration, post *model.Post) (*model.Post, string) {
-	postMessageWithoutAccents := removeAccents(post.Message)
+	return p.enforceFilterResult(configuration, badWordsFilterName, post, p.checkPostBadWords(configuration, post))
+}
 
-	if !p.badWordsRegex.MatchString(postMessageWithoutAccents) {
-		return post, ""
+// checkPostBadWords matches every text of the post against the bad words list and the
+// structured word rules. The most severe action of all matching rules decides the outcome, and
+// the rules are reported from the most severe, as ranked by their action and severity;
+// only the matches of censoring rules are censored. Every rule is matched against the whole
+// text; the markdown regions listed by the configuration, such as code blocks, only decide
+// whether the matches of censoring rules within them are kept, censored or escalated.
+func (p *Plugin) checkPostBadWords(configuration *configuration, post *model.Post) *FilterResult {
+	result := allowPost()
+	censoredPost := post.Clone()
+	// A rule that fires in several texts of the post is reported once.
+	fired := map[string]bool{}
+
+	for _, text := range postTexts(censoredPost) {
+		matches := p.matchWordRules(configuration, post, text)
+
+		result.Matches = append(result.Matches, matches.matches...)
+		for _, rule := range matches.rules {
+			if key := rule.ID + "\x00" + rule.Pattern; !fired[key] {
+				fired[key] = true
+				result.Rules = append(result.Rules, rule)
+			}
+		}
+		if matches.action > result.Action {
+			result.Action = matches.action
+		}
+		if len(matches.censored) > 0 {
+			text.set(censorSpans(text.text, matches.censored, configuration.CensorCharacter))
+		}
 	}
 
-	detectedBadWords := p.badWordsRegex.FindAllString(postMessageWithoutAccents, -1)
-
-	if configuration.RejectPosts {
-		p.API.SendEphemeralPost(post.UserId, &model.Post{
-			ChannelId: post.ChannelId,
-			Message:   fmt.Sprintf(configuration.WarningMessage, strings.Join(detectedBadWords, ", ")),
-			RootId:    post.RootId,
-		})
+	sortRulesBySeverity(result.Rules)
+	detectedBadWords := strings.Join(result.Matches, ", ")
 
-		return nil, fmt.Sprintf("Profane word not allowed: %s", strings.Join(detectedBadWords, ", "))
+	var categories []string
+	for _, rule := range result.Rules {
+		if rule.Category != "" && !containsString(categories, rule.Category) {
+			categories = append(categories, rule.Category)
+		}
+	}
+	data := messageData{
+		Words:      detectedBadWords,
+		Categories: strings.Join(categories, ", "),
 	}
 
-	for _, word := range detectedBadWords {
-		post.Message = strings.ReplaceAll(
-			post.Message,
-			word,
-			strings.Repeat(p.getConfiguration().CensorCharacter, len(word)),
-		)
+	switch result.Action {
+	case FilterActionReject:
+		data.Channel = p.channelName(post.ChannelId)
+		result.Reason = fmt.Sprintf("Profane word not allowed: %s", detectedBadWords)
+		result.Warning = p.renderMessage(configuration, post.UserId, messagePostRejected, data)
+	case FilterActionHold:
+		data.Channel = p.channelName(post.ChannelId)
+		result.Reason = fmt.Sprintf("Post held for review: %s", detectedBadWords)
+		result.Warning = p.renderMessage(configuration, post.UserId, messagePostHeld, data)
+		result.RejectWarning = p.renderMessage(configuration, post.UserId, messagePostRejected, data)
+	case FilterActionCensor:
+		result.Post = censoredPost
 	}
 
-	return post, ""
+	return result
+}
+
+// wordMatches are the matches of the word rules in a single text of a post.
+type wordMatches struct {
+	// matches lists the matched words as they appear in the text.
+	matches []string
+	// rules lists the rules that matched.
+	rules []*Rule
+	// censored lists the spans of the text matched by censoring rules.
+	censored []textSpan
+	// action is the most severe action of the rules that matched.
+	action FilterAction
+}
+
+// matchWordRules matches a single text of a post against every word rule.
+func (p *Plugin) matchWordRules(configuration *configuration, post *model.Post, text *postText) *wordMatches {
+	regions := markdownRegions(configuration, text.text)
+	variants := normalizeText(configuration, text.text)
+	allowed := protectedSpans(p.allowedWordsRegex, variants)
+
+	result := &wordMatches{}
+	for _, compiled := range p.wordRules(configuration) {
+		rule, matches := compiled.Rule, matchRule(compiled, variants, allowed)
+		if rule.Action == RuleActionCensor {
+			rule, matches = applyRegionPolicies(rule, regions, matches)
+		}
+		if len(matches) == 0 {
+			continue
+		}
+
+		for _, match := range matches {
+			result.matches = append(result.matches, text.text[match.span.start:match.span.end])
+			if rule.Action == RuleActionCensor {
+				result.censored = append(result.censored, match.span)
+			}
+		}
+
+		fired, firstMatches := []*Rule{rule}, matches[:1]
+		if rule.ID == badWordsListRuleID {
+			// Report the entries of the list that matched rather than the whole list.
+			fired, firstMatches = p.listEntryRules(rule, matches)
+		}
+		result.rules = append(result.rules, fired...)
+
+		if configuration.LogWordMatches {
+			for i, firedRule := range fired {
+				p.API.LogInfo("Word rule matched",
+					"rule_id", firedRule.ID,
+					"pattern", firedRule.Pattern,
+					"action", string(firedRule.Action),
+					"user_id", post.UserId,
+					"channel_id", post.ChannelId,
+					"field", text.field,
+					"match", text.text[firstMatches[i].span.start:firstMatches[i].span.end],
+				)
+			}
+		}
+
+		if action := rule.Action.filterAction(); action > result.action {
+			result.action = action
+		}
+	}
+	return result
 }
 
 // Plugin Callback: UserHasBeenCreated
 // Executed after a user has been created, no return expected
 func (p *Plugin) UserHasBeenCreated(_ *plugin.Context, user *model.User) {
-	validatorFunctions := []func(*model.User) error{
-		p.checkBadUsername,
-		p.checkBadEmail,
-	}
-
-	validationErrors := p.RequiresModeration(user, validatorFunctions...)
+	validationErrors := p.RequiresModeration(user, p.userValidators()...)
 	if len(validationErrors) == 0 {
 		return // User is OK
 	}
+	p.respondToViolations(user, validationErrors)
+}
 
-	// Copy the user so we can record the original attributes
-	original := *user
-
-	// Perform the cleanup operation
-	if !p.cleanupUser(user) {
-		fmt.Println("Something went wrong when cleaning up user: ", original)
+// Plugin Callback: UserHasJoinedTeam
+// Checks the user against the allowed domains of the team, if it has any, and removes them from
+// the team if they fail.
+func (p *Plugin) UserHasJoinedTeam(_ *plugin.Context, member *model.TeamMember, _ *model.User) {
+	if len(p.teamAllowedDomains) == 0 {
+		return
+	}
+	team, appErr := p.API.GetTeam(member.TeamId)
+	if appErr != nil {
+		p.API.LogWarn("Failed to get team to check allowed domains", "team_id", member.TeamId, "err", appErr.Error())
+		return
+	}
+	user, appErr := p.API.GetUser(member.UserId)
+	if appErr != nil {
+		p.API.LogWarn("Failed to get user to check allowed domains", "user_id", member.UserId, "err", appErr.Error())
+		return
 	}
+	if err := p.checkTeamAllowedDomain(user, team); err != nil {
+		p.removeFromTeam(user, team, err)
+	}
+}
+
+// respondToViolations responds to the failed checks of a user, and reports the response to the
+// moderators and in the audit log.
+func (p *Plugin) respondToViolations(user *model.User, validationErrors []error) {
+	configuration := p.getConfiguration()
 
-	// TODO: do something with the validation errors i.e. send them somewhere
+	reasons := make([]string, 0, len(validationErrors))
 	for _, err := range validationErrors {
-		fmt.Println(err)
+		reasons = append(reasons, err.Error())
+	}
+	response := userResponse(configuration, validationErrors)
+
+	notification := &moderationNotification{
+		event: eventUserSanitized,
+		title: userResponseTitles[response],
+		fields: []*model.SlackAttachmentField{
+			{Title: "Username", Value: user.Username, Short: true},
+			{Title: "Email", Value: user.Email, Short: true},
+			{Title: "Nickname", Value: user.Nickname, Short: true},
+			{Title: "User ID", Value: user.Id, Short: true},
+			{Title: "Reasons", Value: strings.Join(reasons, "\n")},
+		},
+	}
+	if response == UserResponseFlag || response == UserResponseQuarantine {
+		notification.event = eventUserFlagged
+	}
+
+	record := &AuditRecord{
+		UserID:  user.Id,
+		Feature: shadowNewUsers,
+		Action:  string(response),
+		Excerpt: excerpt(strings.Join(reasons, "; ")),
+	}
+
+	if isShadowed(configuration, shadowNewUsers) {
+		record.Shadow = true
+		p.audit(record)
+		p.recordShadowDecision(shadowNewUsers, string(response),
+			"user_id", user.Id,
+			"username", user.Username,
+			"email", user.Email,
+			"reasons", strings.Join(reasons, "; "),
+		)
+		notification.event = eventShadowDecision
+		notification.title = "Shadow mode: a new user would be " + userResponseDescriptions[response]
+		p.notifyModerators(notification)
+		return
+	}
+
+	err := p.respondToUser(response, user, reasons)
+	if err != nil {
+		p.API.LogError("Failed to respond to new user",
+			"user_id", user.Id,
+			"response", string(response),
+			"err", err.Error(),
+		)
+	}
+
+	if err == nil && response == UserResponseQuarantine {
+		notification.actions = []*model.PostAction{
+			decisionAction("quarantine/"+user.Id, quarantineRelease, "Release", "good"),
+		}
+	}
+
+	p.audit(record)
+	// The approval request is the notification of users waiting for approval.
+	if response != UserResponseApproval {
+		p.notifyModerators(notification)
 	}
+}
 
-	fmt.Printf("user info: %v\n", original)
+// userValidators returns the checks new users have to pass.
+func (p *Plugin) userValidators() []func(*model.User) error {
+	return []func(*model.User) error{
+		p.checkBadUsername,
+		p.checkBadEmail,
+		p.checkAllowedDomain,
+		p.checkBannedEmail,
+		p.checkSuspiciousEmail,
+	}
 }
 
 func (p *Plugin) RequiresModeration(user *model.User, validators ...func(*model.User) error) []error {
@@ -167,45 +490,42 @@ func (p *Plugin) RequiresModeration(user *model.User, validators ...func(*model.
 	return nil // Does not require moderation
 }
 
+// RemoveUserFromTeams removes the user from every team they are a member of, as the acting
+// user. It carries on with the other teams when a removal fails.
 func (p *Plugin) RemoveUserFromTeams(user *model.User) error {
 	teams, err := p.API.GetTeamsForUser(user.Id)
 	if err != nil {
 		return fmt.Errorf("unable to get any teams for user")
 	}
+	if len(teams) == 0 {
+		return nil
+	}
 
-	admin, err := p.API.GetUserByUsername("neil") // TODO should not just use me
-	if err != nil {
-		return fmt.Errorf("failed to get admin by username to perform removal")
+	actingUserID := p.actingUserID()
+	if actingUserID == "" {
+		return fmt.Errorf("no acting user to remove user %s from teams as", user.Id)
 	}
 
+	var failed []string
 	for _, team := range teams {
-		if err := p.API.DeleteTeamMember(team.Id, user.Id, admin.Id); err != nil {
-			return fmt.Errorf("failed to remove user from team: (%v, %v)", user, team)
+		if err := p.API.DeleteTeamMember(team.Id, user.Id, actingUserID); err != nil {
+			p.API.LogError("Failed to remove user from team",
+				"user_id", user.Id,
+				"team_id", team.Id,
+				"acting_user_id", actingUserID,
+				"err", err.Error(),
+			)
+			failed = append(failed, team.Id)
+			continue
 		}
+		p.API.LogInfo("Removed user from team",
+			"user_id", user.Id,
+			"team_id", team.Id,
+			"acting_user_id", actingUserID,
+		)
 	}
-	return nil
-}
-
-func (p *Plugin) cleanupUser(user *model.User) bool {
-	// Clean the user's attributes
-	user.Nickname = fmt.Sprintf("sanitized-%s", user.Id)
-	user.Username = fmt.Sprintf("sanitized-%s", user.Id)
-
-	fmt.Println(p.API)
-	user, err := p.API.UpdateUser(user)
-	if err != nil {
-		fmt.Printf("Unable to sanitize user")
-	}
-
-	// Remove user from teams
-	if err := p.RemoveUserFromTeams(user); err != nil {
-		fmt.Printf("Unable to remove user from teams: %v\n", err)
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to remove user %s from teams: %s", user.Id, strings.Join(failed, ", "))
 	}
-
-	// delete them - Perform a soft delete so the account _can_ be restored.
-	if err = p.API.DeleteUser(user.Id); err != nil {
-		fmt.Printf("unable to deactivate user: %v", err)
-	}
-
-	return true
+	return nil
 }
@@ -134,6 +134,18 @@ func (m *MockAPI) DeleteTeamMember(teamID string, userID string, adminID string)
 	return nil
 }
 
+func (m *MockAPI) KVSet(key string, value []byte) *model.AppError {
+	return nil
+}
+
+func (m *MockAPI) KVGet(key string) ([]byte, *model.AppError) {
+	return nil, nil
+}
+
+func (m *MockAPI) KVSetWithOptions(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+	return true, nil
+}
+
 func TestUserHasBeenCreated(t *testing.T) {
 	p := Plugin{
 		configuration: &configuration{
@@ -235,6 +247,38 @@ func TestUserHasBeenCreated(t *testing.T) {
 		assert.NotEqual(t, user.Username, original.Username)
 	})
 
+	t.Run("email in a subdomain of the default list banned", func(_ *testing.T) {
+		id := model.NewId()
+		user := &model.User{
+			Id:       id,
+			Email:    id + "@Mail.Hoo.com.",
+			Nickname: "Neil Is Alright",
+			Username: "alright-" + id,
+			Password: "passwd12345",
+		}
+		original := *user
+
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+
+		assert.NotEqual(t, user.Username, original.Username)
+	})
+
+	t.Run("email without a domain is not banned", func(_ *testing.T) {
+		id := model.NewId()
+		user := &model.User{
+			Id:       id,
+			Email:    id,
+			Nickname: "Neil Is Alright",
+			Username: "alright-" + id,
+			Password: "passwd12345",
+		}
+		original := *user
+
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+
+		assert.Equal(t, user.Username, original.Username)
+	})
+
 	t.Run("user matching email is banned", func(_ *testing.T) {
 		id := model.NewId()
 		user := &model.User{
@@ -698,3 +742,51 @@ func TestGetUserByID(t *testing.T) {
 		assert.True(t, found)
 	})
 }
+
+func TestActingUser(t *testing.T) {
+	admin := &model.User{Id: model.NewId(), Username: "admin"}
+	member := &model.User{Id: model.NewId(), Username: "member"}
+	retired := &model.User{Id: model.NewId(), Username: "retired", DeleteAt: 1}
+
+	newActingPlugin := func(t *testing.T) (*Plugin, *MembershipMockAPI) {
+		p, api := newTestPlugin(t, &configuration{})
+		for _, user := range []*model.User{admin, member, retired} {
+			api.users[user.Id] = user
+		}
+		api.admins[admin.Id] = true
+		api.admins[retired.Id] = true
+		api.teamRoles["team"] = "team_user"
+		return p, api
+	}
+
+	t.Run("removes users from teams as the bot by default", func(t *testing.T) {
+		p, api := newActingPlugin(t)
+
+		assert.NoError(t, p.RemoveUserFromTeams(&model.User{Id: model.NewId()}))
+		assert.Equal(t, []string{"bot"}, api.removedBy)
+		assert.Equal(t, "bot", api.infos[0]["acting_user_id"])
+	})
+
+	t.Run("removes users from teams as the configured user", func(t *testing.T) {
+		p, api := newActingPlugin(t)
+		p.configuration.ActingUsername = "@admin"
+		assert.NoError(t, p.resolveActingUser(p.configuration))
+
+		assert.NoError(t, p.RemoveUserFromTeams(&model.User{Id: model.NewId()}))
+		assert.Equal(t, []string{admin.Id}, api.removedBy)
+	})
+
+	for username, expected := range map[string]string{
+		"nobody":  "user nobody not found",
+		"member":  "user member is not a system admin",
+		"retired": "user retired is deactivated",
+	} {
+		t.Run("rejects "+username, func(t *testing.T) {
+			p, _ := newActingPlugin(t)
+			p.configuration.ActingUsername = username
+
+			err := p.resolveActingUser(p.configuration)
+			assert.ErrorContains(t, err, expected)
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// postText is a piece of text that a post shows to users, together with a way to replace it.
+type postText struct {
+	// field names where the text comes from, for logs, e.g. "attachments[0].title".
+	field string
+	text  string
+	set   func(text string)
+}
+
+// postTexts returns every piece of text the post shows: its message, the text of its message
+// attachments and its card. Integrations can put text in attachments and cards that would
+// otherwise bypass the filters.
+//
+// Quoted content needs no walking of its own. A reply only refers to its root post by id, and
+// quotes are block quotes in the message. Permalink previews of other posts are added to the
+// metadata of a post by the server when it is sent to clients, after the hooks have run, so a
+// post never carries them here; the quoted post went through the filters when it was posted.
+//
+// The setters write into the given post, which must be a copy owned by the caller. The
+// attachments are copied into it first, so that the post they were cloned from is never
+// modified.
+func postTexts(post *model.Post) []*postText {
+	texts := []*postText{{
+		field: "message",
+		text:  post.Message,
+		set:   func(text string) { post.Message = text },
+	}}
+
+	if attachments := copyAttachments(post.Attachments()); len(attachments) > 0 {
+		post.AddProp("attachments", attachments)
+		for i, attachment := range attachments {
+			texts = append(texts, attachmentTexts(fmt.Sprintf("attachments[%d]", i), attachment)...)
+		}
+	}
+
+	if card, ok := post.GetProp("card").(string); ok && card != "" {
+		texts = append(texts, &postText{
+			field: "card",
+			text:  card,
+			set:   func(text string) { post.AddProp("card", text) },
+		})
+	}
+
+	return texts
+}
+
+// attachmentTexts returns the texts of a single message attachment.
+func attachmentTexts(prefix string, attachment *model.SlackAttachment) []*postText {
+	var texts []*postText
+	add := func(field string, value *string) {
+		if *value != "" {
+			texts = append(texts, &postText{
+				field: prefix + "." + field,
+				text:  *value,
+				set:   func(text string) { *value = text },
+			})
+		}
+	}
+
+	add("fallback", &attachment.Fallback)
+	add("pretext", &attachment.Pretext)
+	add("author_name", &attachment.AuthorName)
+	add("title", &attachment.Title)
+	add("text", &attachment.Text)
+	for i, field := range attachment.Fields {
+		add(fmt.Sprintf("fields[%d].title", i), &field.Title)
+		if value, ok := field.Value.(string); ok && value != "" {
+			field := field
+			texts = append(texts, &postText{
+				field: fmt.Sprintf("%s.fields[%d].value", prefix, i),
+				text:  value,
+				set:   func(text string) { field.Value = text },
+			})
+		}
+	}
+	add("footer", &attachment.Footer)
+	for i, action := range attachment.Actions {
+		add(fmt.Sprintf("actions[%d].name", i), &action.Name)
+	}
+	return texts
+}
+
+// copyAttachments copies message attachments deep enough to rewrite their texts.
+func copyAttachments(attachments []*model.SlackAttachment) []*model.SlackAttachment {
+	copies := make([]*model.SlackAttachment, 0, len(attachments))
+	for _, attachment := range attachments {
+		if attachment == nil {
+			continue
+		}
+		attachmentCopy := *attachment
+
+		attachmentCopy.Fields = nil
+		for _, field := range attachment.Fields {
+			if field != nil {
+				fieldCopy := *field
+				attachmentCopy.Fields = append(attachmentCopy.Fields, &fieldCopy)
+			}
+		}
+
+		attachmentCopy.Actions = nil
+		for _, action := range attachment.Actions {
+			if action != nil {
+				actionCopy := *action
+				attachmentCopy.Actions = append(attachmentCopy.Actions, &actionCopy)
+			}
+		}
+
+		copies = append(copies, &attachmentCopy)
+	}
+	return copies
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostTexts(t *testing.T) {
+	post := &model.Post{Message: "message"}
+	post.AddProp("attachments", []any{
+		map[string]any{
+			"pretext": "pretext",
+			"title":   "title",
+			"text":    "text",
+			"fields": []any{
+				map[string]any{"title": "field", "value": "value"},
+				map[string]any{"title": "", "value": 42},
+			},
+			"actions": []any{map[string]any{"name": "button"}},
+		},
+	})
+	post.AddProp("card", "card")
+
+	var fields, texts []string
+	for _, text := range postTexts(post) {
+		fields = append(fields, text.field)
+		texts = append(texts, text.text)
+	}
+
+	assert.Equal(t, []string{
+		"message",
+		"attachments[0].pretext",
+		"attachments[0].title",
+		"attachments[0].text",
+		"attachments[0].fields[0].title",
+		"attachments[0].fields[0].value",
+		"attachments[0].actions[0].name",
+		"card",
+	}, fields)
+	assert.Equal(t, []string{"message", "pretext", "title", "text", "field", "value", "button", "card"}, texts)
+}
+
+func TestFilterPostAttachments(t *testing.T) {
+	newAttachmentPlugin := func(t *testing.T) *Plugin {
+		p, _ := newTestPlugin(t, &configuration{
+			CensorCharacter: "*",
+			BadWordsList:    "darn",
+			WarningMessage:  "Not allowed: %s",
+		})
+		return p
+	}
+
+	t.Run("censors attachments and cards without touching the original", func(t *testing.T) {
+		p := newAttachmentPlugin(t)
+
+		in := &model.Post{Message: "clean"}
+		in.AddProp("attachments", []*model.SlackAttachment{{
+			Title:  "darn title",
+			Fields: []*model.SlackAttachmentField{{Title: "ok", Value: "darn value"}},
+		}})
+		in.AddProp("card", "a darn card")
+
+		rpost, reason := p.MessageWillBePosted(&plugin.Context{}, in)
+		assert.Empty(t, reason)
+		require.NotNil(t, rpost)
+
+		attachments := rpost.Attachments()
+		require.Len(t, attachments, 1)
+		assert.Equal(t, "clean", rpost.Message)
+		assert.Equal(t, "**** title", attachments[0].Title)
+		assert.Equal(t, "**** value", attachments[0].Fields[0].Value)
+		assert.Equal(t, "a **** card", rpost.GetProp("card"))
+
+		assert.Equal(t, "darn title", in.Attachments()[0].Title)
+		assert.Equal(t, "a darn card", in.GetProp("card"))
+	})
+
+	t.Run("rejects posts with bad words in attachments", func(t *testing.T) {
+		p := newAttachmentPlugin(t)
+		p.configuration.RejectPosts = true
+
+		in := &model.Post{Message: "clean"}
+		in.AddProp("attachments", []any{map[string]any{"pretext": "oh darn"}})
+
+		rpost, reason := p.MessageWillBePosted(&plugin.Context{}, in)
+		assert.Nil(t, rpost)
+		assert.Contains(t, reason, "darn")
+	})
+
+	t.Run("censors quoted text in replies", func(t *testing.T) {
+		p := newAttachmentPlugin(t)
+
+		rpost, reason := p.MessageWillBePosted(&plugin.Context{}, &model.Post{RootId: model.NewId(), Message: "> oh darn\nagreed"})
+		assert.Empty(t, reason)
+		require.NotNil(t, rpost)
+		assert.Equal(t, "> oh ****\nagreed", rpost.Message)
+	})
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// quarantinedUsersKey holds every quarantined user, keyed by user id. There are few of
+	// them, so they are kept in memory to check every post without a trip to the KV store.
+	quarantinedUsersKey = "quarantined_users"
+
+	// quarantineChangedEvent tells the other servers of a cluster to reload the quarantine.
+	quarantineChangedEvent = "quarantine_changed"
+
+	// quarantineFeature names quarantine in the audit log and in moderation notifications.
+	quarantineFeature = "quarantine"
+
+	// quarantineRelease is the decision of the release button of a quarantine notification.
+	quarantineRelease = "release"
+)
+
+// The ways the posts of quarantined users are handled, as selected by the QuarantinePosts
+// setting.
+const (
+	quarantinePostsReject = "reject"
+	quarantinePostsHold   = "hold"
+)
+
+func validateQuarantinePosts(setting string) error {
+	switch setting {
+	case "", quarantinePostsReject, quarantinePostsHold:
+		return nil
+	}
+	return fmt.Errorf("unknown quarantine posts handling: %q", setting)
+}
+
+// QuarantinedUser is a user whose account is kept, but who may not post outside the holding
+// channel until a moderator releases them.
+type QuarantinedUser struct {
+	UserID        string `json:"user_id"`
+	Reason        string `json:"reason,omitempty"`
+	QuarantinedBy string `json:"quarantined_by"`
+	QuarantinedAt int64  `json:"quarantined_at"`
+
+	// JoinedTeamID and JoinedChannelID are the holding team and channel the user was added to
+	// when quarantined, so that they can be removed from them on release.
+	JoinedTeamID    string `json:"joined_team_id,omitempty"`
+	JoinedChannelID string `json:"joined_channel_id,omitempty"`
+}
+
+// isQuarantined reports whether the user is quarantined.
+func (p *Plugin) isQuarantined(userID string) bool {
+	p.quarantineLock.RLock()
+	defer p.quarantineLock.RUnlock()
+
+	_, ok := p.quarantined[userID]
+	return ok
+}
+
+// loadQuarantine loads the quarantined users from the KV store.
+func (p *Plugin) loadQuarantine() error {
+	quarantined, _, err := p.listQuarantinedUsers()
+	if err != nil {
+		return err
+	}
+
+	p.quarantineLock.Lock()
+	defer p.quarantineLock.Unlock()
+	p.quarantined = quarantined
+	return nil
+}
+
+// listQuarantinedUsers returns the quarantined users and their stored value.
+func (p *Plugin) listQuarantinedUsers() (map[string]*QuarantinedUser, []byte, error) {
+	data, appErr := p.API.KVGet(quarantinedUsersKey)
+	if appErr != nil {
+		return nil, nil, errors.Wrap(appErr, "failed to load quarantined users")
+	}
+	quarantined := map[string]*QuarantinedUser{}
+	if data == nil {
+		return quarantined, nil, nil
+	}
+	if err := json.Unmarshal(data, &quarantined); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to decode quarantined users")
+	}
+	return quarantined, data, nil
+}
+
+// updateQuarantine applies an update to the quarantined users, retrying when they are changed
+// concurrently, then reloads them on every server.
+func (p *Plugin) updateQuarantine(update func(map[string]*QuarantinedUser) bool) (bool, error) {
+	_, updated, err := updateKVJSON(p.API, quarantinedUsersKey, "quarantined users", p.listQuarantinedUsers,
+		func(quarantined map[string]*QuarantinedUser) (map[string]*QuarantinedUser, bool, error) {
+			return quarantined, update(quarantined), nil
+		})
+	if err != nil || !updated {
+		return false, err
+	}
+
+	if err := p.loadQuarantine(); err != nil {
+		return false, err
+	}
+	if err := p.API.PublishPluginClusterEvent(
+		model.PluginClusterEvent{Id: quarantineChangedEvent},
+		model.PluginClusterEventSendOptions{SendType: model.PluginClusterEventSendTypeReliable},
+	); err != nil {
+		p.API.LogWarn("Failed to tell the cluster to reload the quarantine", "err", err.Error())
+	}
+	return true, nil
+}
+
+// quarantineUser keeps the user from posting until a moderator releases them, and adds them
+// to the holding team and channel, if configured.
+func (p *Plugin) quarantineUser(userID, reason, actor string) error {
+	quarantined := &QuarantinedUser{
+		UserID:        userID,
+		Reason:        reason,
+		QuarantinedBy: actor,
+		QuarantinedAt: model.GetMillis(),
+	}
+	p.joinHoldingArea(p.getConfiguration(), quarantined)
+
+	_, err := p.updateQuarantine(func(users map[string]*QuarantinedUser) bool {
+		if existing, ok := users[userID]; ok {
+			// Keep track of memberships added by an earlier quarantine.
+			if quarantined.JoinedTeamID == "" {
+				quarantined.JoinedTeamID = existing.JoinedTeamID
+			}
+			if quarantined.JoinedChannelID == "" {
+				quarantined.JoinedChannelID = existing.JoinedChannelID
+			}
+		}
+		users[userID] = quarantined
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	p.audit(&AuditRecord{
+		Actor:   actor,
+		UserID:  userID,
+		Feature: quarantineFeature,
+		Action:  "quarantine",
+		Excerpt: excerpt(reason),
+	})
+	return nil
+}
+
+// releaseUser lifts the quarantine of a user, and removes them from the holding team and
+// channel they were added to. It returns false if the user was not quarantined.
+func (p *Plugin) releaseUser(userID, actor string) (bool, error) {
+	var released *QuarantinedUser
+	_, err := p.updateQuarantine(func(users map[string]*QuarantinedUser) bool {
+		released = users[userID]
+		if released == nil {
+			return false
+		}
+		delete(users, userID)
+		return true
+	})
+	if err != nil || released == nil {
+		return false, err
+	}
+
+	p.leaveHoldingArea(released)
+
+	p.audit(&AuditRecord{
+		Actor:   actor,
+		UserID:  userID,
+		Feature: quarantineFeature,
+		Action:  quarantineRelease,
+	})
+	return true, nil
+}
+
+// joinHoldingArea adds a quarantined user to the holding team and channel, and records the
+// memberships it created. Failures are logged, as the quarantine holds without them.
+func (p *Plugin) joinHoldingArea(configuration *configuration, quarantined *QuarantinedUser) {
+	teamID := configuration.QuarantineTeamID
+	if configuration.QuarantineChannelID != "" {
+		channel, appErr := p.API.GetChannel(configuration.QuarantineChannelID)
+		if appErr != nil {
+			p.API.LogError("Failed to get quarantine holding channel",
+				"channel_id", configuration.QuarantineChannelID,
+				"err", appErr.Error(),
+			)
+			return
+		}
+		teamID = channel.TeamId
+	}
+	if teamID == "" {
+		return
+	}
+
+	if _, appErr := p.API.GetTeamMember(teamID, quarantined.UserID); appErr != nil {
+		if _, appErr := p.API.CreateTeamMember(teamID, quarantined.UserID); appErr != nil {
+			p.API.LogError("Failed to add quarantined user to holding team",
+				"user_id", quarantined.UserID,
+				"team_id", teamID,
+				"err", appErr.Error(),
+			)
+			return
+		}
+		quarantined.JoinedTeamID = teamID
+	}
+
+	if configuration.QuarantineChannelID == "" {
+		return
+	}
+	if _, appErr := p.API.GetChannelMember(configuration.QuarantineChannelID, quarantined.UserID); appErr != nil {
+		if _, appErr := p.API.AddChannelMember(configuration.QuarantineChannelID, quarantined.UserID); appErr != nil {
+			p.API.LogError("Failed to add quarantined user to holding channel",
+				"user_id", quarantined.UserID,
+				"channel_id", configuration.QuarantineChannelID,
+				"err", appErr.Error(),
+			)
+			return
+		}
+		quarantined.JoinedChannelID = configuration.QuarantineChannelID
+	}
+}
+
+// leaveHoldingArea removes a released user from the holding team and channel, if they were
+// added to them by the quarantine.
+func (p *Plugin) leaveHoldingArea(released *QuarantinedUser) {
+	if released.JoinedChannelID != "" {
+		if appErr := p.API.DeleteChannelMember(released.JoinedChannelID, released.UserID); appErr != nil {
+			p.API.LogError("Failed to remove released user from holding channel",
+				"user_id", released.UserID,
+				"channel_id", released.JoinedChannelID,
+				"err", appErr.Error(),
+			)
+		}
+	}
+	if released.JoinedTeamID != "" {
+		if appErr := p.API.DeleteTeamMember(released.JoinedTeamID, released.UserID, p.actingUserID()); appErr != nil {
+			p.API.LogError("Failed to remove released user from holding team",
+				"user_id", released.UserID,
+				"team_id", released.JoinedTeamID,
+				"err", appErr.Error(),
+			)
+		}
+	}
+}
+
+// checkQuarantine decides what happens to a post of a quarantined user: posts in the holding
+// channel go through, direct and group messages are rejected, and other posts are rejected or
+// held according to the QuarantinePosts setting.
+func (p *Plugin) checkQuarantine(configuration *configuration, post *model.Post) *FilterResult {
+	if configuration.QuarantineChannelID != "" && post.ChannelId == configuration.QuarantineChannelID {
+		return allowPost()
+	}
+
+	result := &FilterResult{
+		Action:  FilterActionReject,
+		Reason:  "User is quarantined",
+		Warning: p.renderMessage(configuration, post.UserId, messageUserQuarantined, messageData{}),
+	}
+
+	channel, appErr := p.API.GetChannel(post.ChannelId)
+	if appErr != nil || channel.Type == model.ChannelTypeDirect || channel.Type == model.ChannelTypeGroup {
+		return result
+	}
+
+	if configuration.QuarantinePosts == quarantinePostsHold {
+		result.Action = FilterActionHold
+		result.Reason = "Post of quarantined user held for review"
+		result.RejectWarning = result.Warning
+		result.Warning = p.renderMessage(configuration, post.UserId, messagePostHeld, messageData{
+			Channel: channel.DisplayName,
+		})
+	}
+	return result
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuarantine(t *testing.T) {
+	newQuarantinePlugin := func(t *testing.T, quarantinePosts string) (*Plugin, *MembershipMockAPI, *model.User) {
+		p, api := newTestPlugin(t, &configuration{
+			EnableAuditLog:      true,
+			ModerationChannelID: "moderation",
+			Moderators:          "mod",
+			QuarantineChannelID: "holding",
+			QuarantinePosts:     quarantinePosts,
+		})
+		api.admins["mod"] = true
+		api.users["mod"] = &model.User{Id: "mod", Username: "mod"}
+		user := &model.User{Id: model.NewId(), Username: "newcomer"}
+		api.users[user.Id] = user
+		api.teamRoles["community"] = model.TeamUserRoleId
+		api.channelTeams["holding"] = "holding-team"
+		api.channelTeams["town-square"] = "community"
+		api.GetChannelFunc = func(channelID string) (*model.Channel, *model.AppError) {
+			if teamID, ok := api.channelTeams[channelID]; ok {
+				return &model.Channel{Id: channelID, TeamId: teamID, Type: model.ChannelTypeOpen, DisplayName: channelID}, nil
+			}
+			return &model.Channel{Id: channelID, Type: model.ChannelTypeDirect}, nil
+		}
+		return p, api, user
+	}
+
+	post := func(p *Plugin, user *model.User, channelID string) (*model.Post, string) {
+		return p.FilterPost(&model.Post{UserId: user.Id, ChannelId: channelID, Message: "hello"})
+	}
+
+	t.Run("quarantined users can only post in the holding channel", func(t *testing.T) {
+		p, api, user := newQuarantinePlugin(t, "")
+		require.NoError(t, p.quarantineUser(user.Id, "suspicious", "mod"))
+
+		assert.Equal(t, model.TeamUserRoleId, api.teamRoles["holding-team"])
+		assert.Equal(t, model.ChannelUserRoleId, api.channelRoles["holding"])
+
+		published, _ := post(p, user, "holding")
+		assert.NotNil(t, published)
+
+		published, reason := post(p, user, "town-square")
+		assert.Nil(t, published)
+		assert.Equal(t, "User is quarantined", reason)
+
+		published, _ = post(p, user, "direct")
+		assert.Nil(t, published)
+	})
+
+	t.Run("posts can be held for review instead", func(t *testing.T) {
+		p, api, user := newQuarantinePlugin(t, quarantinePostsHold)
+		require.NoError(t, p.quarantineUser(user.Id, "suspicious", "mod"))
+
+		published, reason := post(p, user, "town-square")
+		assert.Nil(t, published)
+		assert.Equal(t, "Post of quarantined user held for review", reason)
+		held := 0
+		for key := range api.kv {
+			if strings.HasPrefix(key, heldPostKeyPrefix) {
+				held++
+			}
+		}
+		assert.Equal(t, 1, held)
+
+		published, reason = post(p, user, "direct")
+		assert.Nil(t, published)
+		assert.Equal(t, "User is quarantined", reason, "direct messages are never held")
+	})
+
+	t.Run("release removes only the memberships the quarantine added", func(t *testing.T) {
+		p, api, user := newQuarantinePlugin(t, "")
+		api.teamRoles["holding-team"] = "team_user team_admin"
+		require.NoError(t, p.quarantineUser(user.Id, "suspicious", "mod"))
+
+		released, err := p.releaseUser(user.Id, "mod")
+		require.NoError(t, err)
+		assert.True(t, released)
+		assert.False(t, p.isQuarantined(user.Id))
+		assert.NotContains(t, api.channelRoles, "holding")
+		assert.Equal(t, "team_user team_admin", api.teamRoles["holding-team"])
+
+		published, _ := post(p, user, "town-square")
+		assert.NotNil(t, published)
+
+		released, err = p.releaseUser(user.Id, "mod")
+		require.NoError(t, err)
+		assert.False(t, released)
+
+		records, err := p.queryAuditLog(AuditQuery{UserID: user.Id})
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, quarantineRelease, records[0].Action)
+	})
+
+	t.Run("the notification of a quarantined user releases them", func(t *testing.T) {
+		p, api, user := newQuarantinePlugin(t, "")
+		p.configuration.BadUsernamesList = "newcomer"
+		p.configuration.ModerationNotifications = eventUserFlagged
+		p.configuration.UserResponses = "bad_usernames=quarantine"
+		p.badUsernamesRegex = splitWordListToRegex("newcomer", `(?mi)(%s)`)
+
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+		require.True(t, p.isQuarantined(user.Id))
+
+		var notification *model.Post
+		for _, created := range api.posts {
+			if created.ChannelId == "moderation" {
+				notification = created
+			}
+		}
+		require.NotNil(t, notification)
+		actions := notification.Attachments()[0].Actions
+		require.Len(t, actions, 1)
+
+		body, err := json.Marshal(&model.PostActionIntegrationRequest{PostId: notification.Id})
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, actions[0].Integration.URL[len("/plugins/"+manifest.Id):], bytes.NewReader(body))
+		request.Header.Set("Mattermost-User-Id", "mod")
+		recorder := httptest.NewRecorder()
+		p.ServeHTTP(nil, recorder, request)
+
+		var response model.PostActionIntegrationResponse
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+		require.NotNil(t, response.Update)
+		assert.Empty(t, response.Update.Attachments()[0].Actions)
+		assert.False(t, p.isQuarantined(user.Id))
+	})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// The kinds of rules that can be managed through the API, in addition to the lists and rules
+// of the plugin settings. Word rules are matched against posts like the WordRules setting;
+// username and domain rules are matched against new users like the bad usernames and bad
+// domains lists.
+const (
+	ruleKindWords     = "words"
+	ruleKindUsernames = "usernames"
+	ruleKindDomains   = "domains"
+
+	// storedRulesKeyPrefix prefixes the KV store keys holding the rules of each kind.
+	storedRulesKeyPrefix = "rules_"
+
+	// storedRulesChangedEvent tells the other servers of a cluster to reload the rules.
+	storedRulesChangedEvent = "stored_rules_changed"
+)
+
+// ruleKindTemplates are the regex templates rule patterns of each kind are compiled with, the
+// same as the corresponding settings.
+var ruleKindTemplates = map[string]string{
+	ruleKindWords:     wordRegexTemplate,
+	ruleKindUsernames: `(?mi)(%s)`,
+	ruleKindDomains:   defaultRegexTemplate,
+}
+
+func isRuleKind(kind string) bool {
+	_, ok := ruleKindTemplates[kind]
+	return ok
+}
+
+func storedRulesKey(kind string) string {
+	return storedRulesKeyPrefix + kind
+}
+
+// getStoredRules returns the compiled rules of a kind.
+func (p *Plugin) getStoredRules(kind string) []*compiledRule {
+	p.storedRulesLock.RLock()
+	defer p.storedRulesLock.RUnlock()
+
+	return p.storedRules[kind]
+}
+
+// loadStoredRules compiles the rules of every kind from the KV store.
+func (p *Plugin) loadStoredRules() error {
+	storedRules := map[string][]*compiledRule{}
+	for kind, regexTemplate := range ruleKindTemplates {
+		rules, _, err := p.listStoredRules(kind)
+		if err != nil {
+			return err
+		}
+		compiled, err := compileRules(rules, regexTemplate)
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s rules", kind)
+		}
+		storedRules[kind] = compiled
+	}
+
+	p.storedRulesLock.Lock()
+	defer p.storedRulesLock.Unlock()
+	p.storedRules = storedRules
+	return nil
+}
+
+// listStoredRules returns the rules of a kind and their stored value.
+func (p *Plugin) listStoredRules(kind string) ([]*Rule, []byte, error) {
+	data, appErr := p.API.KVGet(storedRulesKey(kind))
+	if appErr != nil {
+		return nil, nil, errors.Wrapf(appErr, "failed to load %s rules", kind)
+	}
+	if data == nil {
+		return []*Rule{}, nil, nil
+	}
+
+	var rules []*Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to decode %s rules", kind)
+	}
+	return rules, data, nil
+}
+
+// getStoredRule returns a rule by id, or nil if there is none.
+func (p *Plugin) getStoredRule(kind, id string) (*Rule, error) {
+	rules, _, err := p.listStoredRules(kind)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if rule.ID == id {
+			return rule, nil
+		}
+	}
+	return nil, nil
+}
+
+// validateStoredRule checks a rule before it is stored. Only word rules have an action and
+// exceptions, and only username and domain rules have a response.
+func (p *Plugin) validateStoredRule(kind string, rule *Rule) error {
+	if kind == ruleKindWords {
+		if err := validateRule(rule); err != nil {
+			return err
+		}
+		if rule.Response != "" {
+			return fmt.Errorf("%s rules have no response", kind)
+		}
+		if rule.ID == badWordsListRuleID {
+			return fmt.Errorf("rule id %s is reserved", rule.ID)
+		}
+		if rule.Action == RuleActionHold && p.getConfiguration().ModerationChannelID == "" {
+			return fmt.Errorf("rule %s holds posts for review, which needs a moderation channel", rule.ID)
+		}
+		for _, configured := range p.structuredWordRules {
+			if configured.ID == rule.ID {
+				return fmt.Errorf("rule id %s is already used by the word rules setting", rule.ID)
+			}
+		}
+	} else {
+		if rule.ID == "" {
+			return errors.New("rule has no id")
+		}
+		if rule.Pattern == "" {
+			return fmt.Errorf("rule %s has no pattern", rule.ID)
+		}
+		if rule.Action != "" {
+			return fmt.Errorf("%s rules have no action", kind)
+		}
+		if len(rule.Exceptions) > 0 {
+			return fmt.Errorf("%s rules have no exceptions", kind)
+		}
+		if rule.Response != "" && !isUserResponse(rule.Response) {
+			return fmt.Errorf("rule %s has unknown response: %s", rule.ID, rule.Response)
+		}
+		if rule.Response == UserResponseApproval && p.getConfiguration().ModerationChannelID == "" {
+			return fmt.Errorf("rule %s holds users for approval, which needs a moderation channel", rule.ID)
+		}
+	}
+
+	_, err := compileRules([]*Rule{rule}, ruleKindTemplates[kind])
+	return err
+}
+
+// updateStoredRules applies an update to the rules of a kind, retrying when the rules are
+// changed concurrently, then reloads the rules on every server.
+func (p *Plugin) updateStoredRules(kind string, update func([]*Rule) ([]*Rule, error)) error {
+	what := kind + " rules"
+	_, updated, err := updateKVJSON(p.API, storedRulesKey(kind), what, func() ([]*Rule, []byte, error) {
+		return p.listStoredRules(kind)
+	}, func(rules []*Rule) ([]*Rule, bool, error) {
+		rules, err := update(rules)
+		return rules, err == nil, err
+	})
+	if err != nil || !updated {
+		return err
+	}
+
+	if err := p.loadStoredRules(); err != nil {
+		return err
+	}
+	if err := p.API.PublishPluginClusterEvent(
+		model.PluginClusterEvent{Id: storedRulesChangedEvent},
+		model.PluginClusterEventSendOptions{SendType: model.PluginClusterEventSendTypeReliable},
+	); err != nil {
+		p.API.LogWarn("Failed to tell the cluster to reload rules", "err", err.Error())
+	}
+	return nil
+}
+
+var (
+	// errRuleNotFound is returned when replacing or deleting a rule that does not exist.
+	errRuleNotFound = errors.New("rule not found")
+	// errRuleExists is returned when creating a rule with the id of an existing one.
+	errRuleExists = errors.New("a rule with this id already exists")
+	// errInvalidRule is returned when creating or replacing a rule that does not validate.
+	errInvalidRule = errors.New("invalid rule")
+)
+
+func (p *Plugin) createStoredRule(kind string, rule *Rule) error {
+	if err := p.validateStoredRule(kind, rule); err != nil {
+		return fmt.Errorf("%w: %s", errInvalidRule, err)
+	}
+	return p.updateStoredRules(kind, func(rules []*Rule) ([]*Rule, error) {
+		for _, existing := range rules {
+			if existing.ID == rule.ID {
+				return nil, errRuleExists
+			}
+		}
+		return append(rules, rule), nil
+	})
+}
+
+func (p *Plugin) replaceStoredRule(kind string, rule *Rule) error {
+	if err := p.validateStoredRule(kind, rule); err != nil {
+		return fmt.Errorf("%w: %s", errInvalidRule, err)
+	}
+	return p.updateStoredRules(kind, func(rules []*Rule) ([]*Rule, error) {
+		for i, existing := range rules {
+			if existing.ID == rule.ID {
+				rules[i] = rule
+				return rules, nil
+			}
+		}
+		return nil, errRuleNotFound
+	})
+}
+
+func (p *Plugin) deleteStoredRule(kind, id string) error {
+	return p.updateStoredRules(kind, func(rules []*Rule) ([]*Rule, error) {
+		for i, existing := range rules {
+			if existing.ID == id {
+				return append(rules[:i], rules[i+1:]...), nil
+			}
+		}
+		return nil, errRuleNotFound
+	})
+}
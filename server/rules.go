@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RuleAction is what happens to a post that matches a rule.
+type RuleAction string
+
+const (
+	// RuleActionCensor replaces the matched text with the censor character.
+	RuleActionCensor RuleAction = "censor"
+	// RuleActionReject refuses the post and warns its author.
+	RuleActionReject RuleAction = "reject"
+	// RuleActionHold withholds the post for moderator review.
+	RuleActionHold RuleAction = "hold"
+	// RuleActionAlert lets the post through untouched and only reports the match.
+	RuleActionAlert RuleAction = "alert"
+)
+
+// filterAction maps the rule action onto the decision of the post filter pipeline.
+func (a RuleAction) filterAction() FilterAction {
+	switch a {
+	case RuleActionReject:
+		return FilterActionReject
+	case RuleActionHold:
+		return FilterActionHold
+	case RuleActionCensor:
+		return FilterActionCensor
+	default:
+		return FilterActionAllow
+	}
+}
+
+// Rule is a single structured entry of the word filter, as configured in the WordRules setting.
+type Rule struct {
+	// ID identifies the rule in logs and reports. It must be unique.
+	ID string `json:"id"`
+
+	// Pattern is a regular expression matched against whole words, like the entries of the bad
+	// words list.
+	Pattern string `json:"pattern"`
+
+	// Category groups rules for reporting, e.g. "slur", "profanity" or "spam".
+	Category string `json:"category,omitempty"`
+
+	// Severity ranks rules with the same action against each other; higher is more severe.
+	// The most severe of the rules that match a post is the one reported as having fired.
+	Severity int `json:"severity,omitempty"`
+
+	// Action is what happens to a post matching the rule. Defaults to censor.
+	Action RuleAction `json:"action,omitempty"`
+
+	// Response is what happens to a new user matching a username or domain rule. Defaults to
+	// the response of the check in the UserResponses setting.
+	Response UserResponse `json:"response,omitempty"`
+
+	// Exceptions are known-good words and phrases the rule must not fire on, e.g. "Cox
+	// Communications" for a rule matching "cox". Regular expressions are interpreted.
+	Exceptions []string `json:"exceptions,omitempty"`
+}
+
+// compiledRule is a Rule with its pattern and exceptions compiled.
+type compiledRule struct {
+	*Rule
+	regex      *regexp.Regexp
+	exceptions *regexp.Regexp
+}
+
+// The ID reported for matches of the plain BadWordsList setting.
+const badWordsListRuleID = "BadWordsList"
+
+// parseRules decodes and validates a JSON array of rules.
+func parseRules(rulesJSON string) ([]*Rule, error) {
+	if strings.TrimSpace(rulesJSON) == "" {
+		return nil, nil
+	}
+
+	var rules []*Rule
+	if err := json.Unmarshal([]byte(rulesJSON), &rules); err != nil {
+		return nil, fmt.Errorf("rules must be a JSON array: %w", err)
+	}
+
+	seen := map[string]bool{}
+	for i, rule := range rules {
+		if rule.ID == "" {
+			return nil, fmt.Errorf("rule %d has no id", i)
+		}
+		if seen[rule.ID] {
+			return nil, fmt.Errorf("duplicate rule id: %s", rule.ID)
+		}
+		seen[rule.ID] = true
+
+		if err := validateRule(rule); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// validateRule checks a single word rule and defaults its action to censor.
+func validateRule(rule *Rule) error {
+	if rule.ID == "" {
+		return errors.New("rule has no id")
+	}
+	if rule.Pattern == "" {
+		return fmt.Errorf("rule %s has no pattern", rule.ID)
+	}
+
+	switch rule.Action {
+	case "":
+		rule.Action = RuleActionCensor
+	case RuleActionCensor, RuleActionReject, RuleActionHold, RuleActionAlert:
+	default:
+		return fmt.Errorf("rule %s has unknown action: %s", rule.ID, rule.Action)
+	}
+	return nil
+}
+
+// compileRules compiles the pattern of every rule with the given regex template.
+func compileRules(rules []*Rule, regexTemplate string) ([]*compiledRule, error) {
+	compiled := make([]*compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		regex, err := regexp.Compile(fmt.Sprintf(regexTemplate, rule.Pattern))
+		if err != nil {
+			return nil, fmt.Errorf("rule %s has an invalid pattern: %w", rule.ID, err)
+		}
+		compiledRule := &compiledRule{Rule: rule, regex: regex}
+		if len(rule.Exceptions) > 0 {
+			compiledRule.exceptions, err = regexp.Compile(fmt.Sprintf(regexTemplate, strings.Join(rule.Exceptions, "|")))
+			if err != nil {
+				return nil, fmt.Errorf("rule %s has an invalid exception: %w", rule.ID, err)
+			}
+		}
+		compiled = append(compiled, compiledRule)
+	}
+	return compiled, nil
+}
+
+// wordRules returns the rules the bad words filter applies: the BadWordsList setting as a
+// single rule whose action follows RejectPosts, followed by the structured WordRules and the
+// word rules managed through the API.
+func (p *Plugin) wordRules(configuration *configuration) []*compiledRule {
+	var rules []*compiledRule
+	if p.badWordsRegex != nil {
+		action := RuleActionCensor
+		if configuration.RejectPosts {
+			action = RuleActionReject
+		}
+		rules = append(rules, &compiledRule{
+			Rule:  &Rule{ID: badWordsListRuleID, Pattern: configuration.BadWordsList, Action: action},
+			regex: p.badWordsRegex,
+		})
+	}
+	rules = append(rules, p.structuredWordRules...)
+	return append(rules, p.getStoredRules(ruleKindWords)...)
+}
+
+// sortRulesBySeverity orders rules from the one that decides the outcome of a post: the most
+// severe action first, then the highest severity. Rules that tie keep their order.
+func sortRulesBySeverity(rules []*Rule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		ai, aj := rules[i].Action.filterAction(), rules[j].Action.filterAction()
+		if ai != aj {
+			return ai > aj
+		}
+		return rules[i].Severity > rules[j].Severity
+	})
+}
+
+// ruleIDs joins the ids of the given rules for logs and reports.
+func ruleIDs(rules []*Rule) string {
+	ids := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		ids = append(ids, rule.ID)
+	}
+	return strings.Join(ids, ", ")
+}
+
+// loadWordRules parses and compiles the WordRules setting.
+func loadWordRules(rulesJSON string) ([]*compiledRule, error) {
+	rules, err := parseRules(rulesJSON)
+	if err != nil {
+		return nil, err
+	}
+	return compileRules(rules, wordRegexTemplate)
+}
+
+// ruleMatch is a match of a rule, both as found in a normalized variant of the text and as
+// the span of the original text it covers.
+type ruleMatch struct {
+	span textSpan
+	text string
+}
+
+// matchRule finds the matches of a rule in every variant of a normalized text, without
+// duplicates. Matches that lie within a protected span, or within an exception of the rule,
+// are dropped.
+func matchRule(rule *compiledRule, variants []*mappedText, protected []textSpan) []ruleMatch {
+	if rule.exceptions != nil {
+		protected = append(protectedSpans(rule.exceptions, variants), protected...)
+	}
+
+	var matches []ruleMatch
+	seen := map[textSpan]bool{}
+	for _, variant := range variants {
+		for _, location := range findWords(rule.regex, variant.text) {
+			start, end := variant.originalSpan(location[0], location[1])
+			span := textSpan{start, end}
+			if seen[span] || spanCovered(span, protected) {
+				continue
+			}
+			seen[span] = true
+			matches = append(matches, ruleMatch{span: span, text: variant.text[location[0]:location[1]]})
+		}
+	}
+	return matches
+}
+
+// protectedSpans returns the spans of the original text matched by an allow list in any
+// variant of the text.
+func protectedSpans(allowed *regexp.Regexp, variants []*mappedText) []textSpan {
+	if allowed == nil {
+		return nil
+	}
+
+	var spans []textSpan
+	for _, variant := range variants {
+		for _, location := range findWords(allowed, variant.text) {
+			start, end := variant.originalSpan(location[0], location[1])
+			spans = append(spans, textSpan{start, end})
+		}
+	}
+	return spans
+}
+
+// spanCovered reports whether the span lies entirely within one of the protected spans.
+func spanCovered(span textSpan, protected []textSpan) bool {
+	for _, p := range protected {
+		if p.start <= span.start && span.end <= p.end {
+			return true
+		}
+	}
+	return false
+}
+
+// listEntry is a single entry of a comma separated word list.
+type listEntry struct {
+	entry string
+	regex *regexp.Regexp
+}
+
+// compileListEntries compiles every entry of a comma separated word list on its own, so that
+// a match of the list can be traced back to the entry that caused it.
+func compileListEntries(wordList string) []listEntry {
+	var entries []listEntry
+	for _, entry := range strings.Split(wordList, ",") {
+		if regex, err := regexp.Compile(`(?i)^(?:` + entry + `)$`); err == nil {
+			entries = append(entries, listEntry{entry: entry, regex: regex})
+		}
+	}
+	return entries
+}
+
+// matchingListEntry returns the entry of a compiled word list that matches the text.
+func matchingListEntry(entries []listEntry, text string) (string, bool) {
+	for _, entry := range entries {
+		if entry.regex.MatchString(text) {
+			return entry.entry, true
+		}
+	}
+	return "", false
+}
+
+// listEntryRules splits the matches of the BadWordsList rule by the entry of the list that
+// matched, and returns a copy of the rule for each entry along with its first match. Matches
+// that no single entry explains are reported against the whole list.
+func (p *Plugin) listEntryRules(rule *Rule, matches []ruleMatch) ([]*Rule, []ruleMatch) {
+	var rules []*Rule
+	var firstMatches []ruleMatch
+	seen := map[string]bool{}
+	for _, match := range matches {
+		pattern, found := matchingListEntry(p.badWordsEntries, match.text)
+		if !found {
+			pattern = rule.Pattern
+		}
+		if seen[pattern] {
+			continue
+		}
+		seen[pattern] = true
+
+		entryRule := *rule
+		entryRule.Pattern = pattern
+		rules = append(rules, &entryRule)
+		firstMatches = append(firstMatches, match)
+	}
+	return rules, firstMatches
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRules(t *testing.T) {
+	t.Run("empty setting has no rules", func(t *testing.T) {
+		rules, err := parseRules("  ")
+		assert.NoError(t, err)
+		assert.Empty(t, rules)
+	})
+
+	t.Run("parses rules and defaults the action to censor", func(t *testing.T) {
+		rules, err := parseRules(`[
+			{"id": "slur", "pattern": "abc", "category": "slur", "severity": 3, "action": "reject"},
+			{"id": "mild", "pattern": "def"}
+		]`)
+		require.NoError(t, err)
+		require.Len(t, rules, 2)
+
+		assert.Equal(t, &Rule{ID: "slur", Pattern: "abc", Category: "slur", Severity: 3, Action: RuleActionReject}, rules[0])
+		assert.Equal(t, RuleActionCensor, rules[1].Action)
+	})
+
+	for name, rulesJSON := range map[string]string{
+		"not an array":   `{"id": "a"}`,
+		"missing id":     `[{"pattern": "abc"}]`,
+		"duplicate id":   `[{"id": "a", "pattern": "abc"}, {"id": "a", "pattern": "def"}]`,
+		"missing regex":  `[{"id": "a"}]`,
+		"unknown action": `[{"id": "a", "pattern": "abc", "action": "explode"}]`,
+	} {
+		t.Run("rejects "+name, func(t *testing.T) {
+			_, err := parseRules(rulesJSON)
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("rejects invalid patterns when compiling", func(t *testing.T) {
+		_, err := loadWordRules(`[{"id": "broken", "pattern": "a(b"}]`)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "broken")
+	})
+}
+
+func TestSortRulesBySeverity(t *testing.T) {
+	rules := []*Rule{
+		{ID: "mild", Action: RuleActionCensor, Severity: 5},
+		{ID: "spam", Action: RuleActionHold, Severity: 1},
+		{ID: "slur", Action: RuleActionReject, Severity: 2},
+		{ID: "insult", Action: RuleActionReject, Severity: 3},
+		{ID: "watch", Action: RuleActionAlert},
+	}
+	sortRulesBySeverity(rules)
+	assert.Equal(t, "insult, slur, spam, mild, watch", ruleIDs(rules))
+}
+
+func TestWordRules(t *testing.T) {
+	newRulesPlugin := func(t *testing.T, rulesJSON string) (*Plugin, *MembershipMockAPI) {
+		p, api := newTestPlugin(t, &configuration{
+			BadWordsList:    "mild",
+			CensorCharacter: "*",
+			WarningMessage:  "Not allowed: %s",
+			PostFilters:     "bad_words",
+		})
+		// The rules are set directly, as hold rules without a moderation channel do not validate.
+		rules, err := loadWordRules(rulesJSON)
+		require.NoError(t, err)
+		p.structuredWordRules = rules
+		return p, api
+	}
+
+	rulesJSON := `[
+		{"id": "slurs", "pattern": "slur", "category": "slur", "severity": 3, "action": "reject"},
+		{"id": "spam", "pattern": "buy now", "category": "spam", "action": "hold"},
+		{"id": "watch", "pattern": "watched", "action": "alert"}
+	]`
+
+	t.Run("mild words from the list are censored", func(t *testing.T) {
+		p, _ := newRulesPlugin(t, rulesJSON)
+
+		rpost, reason := p.MessageWillBePosted(&plugin.Context{}, &model.Post{Message: "a mild word"})
+		assert.Empty(t, reason)
+		assert.Equal(t, "a **** word", rpost.Message)
+	})
+
+	t.Run("reject rules win over censoring", func(t *testing.T) {
+		p, api := newRulesPlugin(t, rulesJSON)
+		var warning string
+		api.SendEphemeralPostFunc = func(userID string, post *model.Post) *model.Post {
+			warning = post.Message
+			return post
+		}
+
+		result := p.checkPostBadWords(p.getConfiguration(), &model.Post{Message: "a mild slur"})
+		assert.Equal(t, FilterActionReject, result.Action)
+		assert.Equal(t, "slurs", result.Rules[0].ID, "the rule that decided is reported first")
+		assert.Equal(t, "slur", result.Rules[0].Category)
+
+		rpost, reason := p.MessageWillBePosted(&plugin.Context{}, &model.Post{Message: "a mild slur"})
+		assert.Nil(t, rpost)
+		assert.Contains(t, reason, "slur")
+		assert.Equal(t, "Not allowed: mild, slur", warning)
+	})
+
+	t.Run("hold rules withhold the post", func(t *testing.T) {
+		p, api := newRulesPlugin(t, rulesJSON)
+
+		result := p.checkPostBadWords(p.getConfiguration(), &model.Post{Message: "buy now!"})
+		assert.Equal(t, FilterActionHold, result.Action)
+
+		rpost, reason := p.MessageWillBePosted(&plugin.Context{}, &model.Post{Message: "buy now!"})
+		assert.Nil(t, rpost)
+		assert.Contains(t, reason, "held for review")
+		// Without a moderation channel there is nowhere to review the post.
+		assert.Len(t, api.warnings, 1)
+	})
+
+	t.Run("alert rules let the post through and report it", func(t *testing.T) {
+		p, api := newRulesPlugin(t, rulesJSON)
+
+		in := &model.Post{Message: "being watched"}
+		rpost, reason := p.MessageWillBePosted(&plugin.Context{}, in)
+		assert.Empty(t, reason)
+		assert.Equal(t, "being watched", rpost.Message)
+		assert.Equal(t, []string{"Post matched alert rules"}, api.warnings)
+	})
+
+	t.Run("the bad words list follows RejectPosts", func(t *testing.T) {
+		p, _ := newRulesPlugin(t, "")
+		p.configuration.RejectPosts = true
+
+		result := p.checkPostBadWords(p.getConfiguration(), &model.Post{Message: "a mild word"})
+		assert.Equal(t, FilterActionReject, result.Action)
+		assert.Equal(t, badWordsListRuleID, result.Rules[0].ID)
+	})
+}
+
+func TestAllowedWords(t *testing.T) {
+	newAllowlistPlugin := func(t *testing.T, allowedWords, rulesJSON string) (*Plugin, *MembershipMockAPI) {
+		return newTestPlugin(t, &configuration{
+			BadWordsList:     "cox,pawn,hell",
+			AllowedWordsList: allowedWords,
+			CensorCharacter:  "*",
+			PostFilters:      "bad_words",
+			WordRules:        rulesJSON,
+		})
+	}
+
+	t.Run("allowed phrases protect the words inside them", func(t *testing.T) {
+		p, _ := newAllowlistPlugin(t, "Cox Communications,pawn", "")
+
+		for in, out := range map[string]string{
+			"my ISP is Cox Communications": "my ISP is Cox Communications",
+			"cox is down again":            "*** is down again",
+			"the pawn moves forward":       "the pawn moves forward",
+			"what the hell":                "what the ****",
+		} {
+			rpost, reason := p.MessageWillBePosted(&plugin.Context{}, &model.Post{Message: in})
+			assert.Empty(t, reason)
+			assert.Equal(t, out, rpost.Message)
+		}
+	})
+
+	t.Run("rules can have their own exceptions", func(t *testing.T) {
+		p, _ := newAllowlistPlugin(t, "", `[{"id": "shells", "pattern": "\\w*hell\\w*", "action": "reject", "exceptions": ["shell", "shells"]}]`)
+
+		result := p.checkPostBadWords(p.getConfiguration(), &model.Post{Message: "run it in a shell"})
+		assert.Equal(t, FilterActionAllow, result.Action)
+
+		result = p.checkPostBadWords(p.getConfiguration(), &model.Post{Message: "hellfire"})
+		assert.Equal(t, FilterActionReject, result.Action)
+		assert.Equal(t, "shells", result.Rules[0].ID)
+	})
+
+	t.Run("reports which list entry fired", func(t *testing.T) {
+		p, api := newAllowlistPlugin(t, "", "")
+		p.configuration.LogWordMatches = true
+
+		result := p.checkPostBadWords(p.getConfiguration(), &model.Post{Message: "check, PAWN", UserId: "user"})
+		require.Len(t, result.Rules, 1)
+		assert.Equal(t, badWordsListRuleID, result.Rules[0].ID)
+		assert.Equal(t, "pawn", result.Rules[0].Pattern)
+
+		require.Len(t, api.infos, 1)
+		assert.Equal(t, "pawn", api.infos[0]["pattern"])
+		assert.Equal(t, "PAWN", api.infos[0]["match"])
+		assert.Equal(t, "user", api.infos[0]["user_id"])
+	})
+
+	t.Run("reports every list entry that fired", func(t *testing.T) {
+		p, _ := newAllowlistPlugin(t, "", "")
+
+		result := p.checkPostBadWords(p.getConfiguration(), &model.Post{Message: "pawn to cox, pawn again"})
+		require.Len(t, result.Rules, 2)
+		assert.Equal(t, "pawn", result.Rules[0].Pattern)
+		assert.Equal(t, "cox", result.Rules[1].Pattern)
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Shadow mode lets a feature reach its decisions as usual, but only record them instead of
+// enforcing them. The ShadowMode setting lists the features to run in shadow mode, by the name
+// of their post filter or shadowNewUsers for the new user validators, or shadowAll.
+const (
+	shadowAll      = "all"
+	shadowNewUsers = "new_users"
+)
+
+// validateShadowMode checks that every feature in the ShadowMode setting exists.
+func (p *Plugin) validateShadowMode(configuration *configuration) error {
+	available := p.postFilters()
+	for _, name := range strings.Split(configuration.ShadowMode, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == shadowAll || name == shadowNewUsers {
+			continue
+		}
+		if _, ok := available[name]; !ok {
+			return fmt.Errorf("unknown shadow mode feature: %q", name)
+		}
+	}
+	return nil
+}
+
+// isShadowed reports whether the feature only records its decisions.
+func isShadowed(configuration *configuration, feature string) bool {
+	for _, name := range strings.Split(configuration.ShadowMode, ",") {
+		name = strings.TrimSpace(name)
+		if name == shadowAll || name == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// recordShadowDecision records what a feature in shadow mode would have done.
+func (p *Plugin) recordShadowDecision(feature, decision string, keyValuePairs ...any) {
+	p.API.LogInfo("Shadow mode decision", append([]any{"feature", feature, "decision", decision}, keyValuePairs...)...)
+}
+
+// recordShadowFilterResult records the decision of a post filter in shadow mode.
+func (p *Plugin) recordShadowFilterResult(feature string, post *model.Post, result *FilterResult) {
+	p.recordShadowDecision(feature, result.Action.String(),
+		"user_id", post.UserId,
+		"channel_id", post.ChannelId,
+		"reason", result.Reason,
+		"rules", ruleIDs(result.Rules),
+		"matches", strings.Join(result.Matches, ", "),
+	)
+}
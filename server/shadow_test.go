@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShadowMode(t *testing.T) {
+	newShadowPlugin := func(t *testing.T, shadowMode string) (*Plugin, *MembershipMockAPI) {
+		p, api := newTestPlugin(t, &configuration{
+			CensorCharacter:    "*",
+			BadWordsList:       "darn",
+			BadUsernamesList:   "baduser",
+			RejectPosts:        true,
+			BlockNewUserPM:     true,
+			BlockNewUserPMTime: "24h",
+			ShadowMode:         shadowMode,
+		})
+		api.GetUserFunc = func(userID string) (*model.User, *model.AppError) {
+			return &model.User{Id: userID, CreateAt: model.GetMillis()}, nil
+		}
+		return p, api
+	}
+
+	t.Run("shadowed filters record their decisions and let the post through", func(t *testing.T) {
+		p, api := newShadowPlugin(t, "bad_words, direct_messages")
+
+		in := &model.Post{Message: "oh darn", UserId: "user", ChannelId: "dm"}
+		rpost, reason := p.MessageWillBePosted(&plugin.Context{}, in)
+		assert.Empty(t, reason)
+		require.NotNil(t, rpost)
+		assert.Equal(t, "oh darn", rpost.Message)
+
+		require.Len(t, api.infos, 2)
+		assert.Equal(t, directMessagesFilterName, api.infos[0]["feature"])
+		assert.Equal(t, "reject", api.infos[0]["decision"])
+		assert.Equal(t, badWordsFilterName, api.infos[1]["feature"])
+		assert.Equal(t, "darn", api.infos[1]["matches"])
+	})
+
+	t.Run("other features are still enforced", func(t *testing.T) {
+		p, api := newShadowPlugin(t, "direct_messages")
+
+		rpost, reason := p.MessageWillBePosted(&plugin.Context{}, &model.Post{Message: "oh darn", ChannelId: "dm"})
+		assert.Nil(t, rpost)
+		assert.Contains(t, reason, "darn")
+		assert.Len(t, api.infos, 1)
+	})
+
+	t.Run("shadowed user validation does not touch the user", func(t *testing.T) {
+		p, api := newShadowPlugin(t, "all")
+
+		user := &model.User{Id: "user", Username: "baduser"}
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+		assert.Equal(t, "baduser", user.Username)
+
+		require.Len(t, api.infos, 1)
+		assert.Equal(t, shadowNewUsers, api.infos[0]["feature"])
+		assert.Equal(t, "deactivate", api.infos[0]["decision"])
+	})
+
+	t.Run("rejects unknown features", func(t *testing.T) {
+		p := &Plugin{}
+		assert.NoError(t, p.validateShadowMode(&configuration{ShadowMode: "all,new_users,bad_words"}))
+		assert.Error(t, p.validateShadowMode(&configuration{ShadowMode: "bad_emails"}))
+	})
+}
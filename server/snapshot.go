@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// userSnapshotKeyPrefix prefixes the KV store keys of the snapshots of sanitized users.
+const userSnapshotKeyPrefix = "user_snapshot_"
+
+// UserSnapshot is what a user looked like before the plugin sanitized them, so that a
+// moderator can put everything back after a false positive.
+type UserSnapshot struct {
+	UserID   string                `json:"user_id"`
+	Username string                `json:"username"`
+	Nickname string                `json:"nickname"`
+	Email    string                `json:"email"`
+	Roles    string                `json:"roles"`
+	Teams    []*MembershipSnapshot `json:"teams,omitempty"`
+	Channels []*MembershipSnapshot `json:"channels,omitempty"`
+	TakenAt  int64                 `json:"taken_at"`
+}
+
+// MembershipSnapshot is a team or channel the user was a member of, with their roles in it.
+type MembershipSnapshot struct {
+	ID    string `json:"id"`
+	Roles string `json:"roles"`
+}
+
+func userSnapshotKey(userID string) string {
+	return userSnapshotKeyPrefix + userID
+}
+
+// snapshotUser records the attributes and memberships of a user before sanitizing them.
+// Direct and group messages are left out, as sanitizing does not remove the user from them.
+//
+// A snapshot that already exists is kept and returned until a restore consumes it: a user
+// sanitized twice, e.g. after being renamed and then deactivated, must be restored to what
+// they looked like before the first time.
+func (p *Plugin) snapshotUser(user *model.User) (*UserSnapshot, error) {
+	if existing, err := p.getUserSnapshot(user.Id); err != nil || existing != nil {
+		return existing, err
+	}
+
+	snapshot := &UserSnapshot{
+		UserID:   user.Id,
+		Username: user.Username,
+		Nickname: user.Nickname,
+		Email:    user.Email,
+		Roles:    user.Roles,
+		TakenAt:  model.GetMillis(),
+	}
+
+	teams, appErr := p.API.GetTeamsForUser(user.Id)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get teams of user")
+	}
+	for _, team := range teams {
+		member, appErr := p.API.GetTeamMember(team.Id, user.Id)
+		if appErr != nil {
+			return nil, errors.Wrapf(appErr, "failed to get membership of team %s", team.Id)
+		}
+		snapshot.Teams = append(snapshot.Teams, &MembershipSnapshot{ID: team.Id, Roles: member.Roles})
+
+		channels, appErr := p.API.GetChannelsForTeamForUser(team.Id, user.Id, false)
+		if appErr != nil {
+			return nil, errors.Wrapf(appErr, "failed to get channels of team %s", team.Id)
+		}
+		for _, channel := range channels {
+			if channel.Type == model.ChannelTypeDirect || channel.Type == model.ChannelTypeGroup {
+				continue
+			}
+			member, appErr := p.API.GetChannelMember(channel.Id, user.Id)
+			if appErr != nil {
+				return nil, errors.Wrapf(appErr, "failed to get membership of channel %s", channel.Id)
+			}
+			snapshot.Channels = append(snapshot.Channels, &MembershipSnapshot{ID: channel.Id, Roles: member.Roles})
+		}
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode user snapshot")
+	}
+	stored, appErr := p.API.KVSetWithOptions(userSnapshotKey(user.Id), data, model.PluginKVSetOptions{
+		Atomic:   true,
+		OldValue: nil,
+	})
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to store user snapshot")
+	}
+	if !stored {
+		// Another server took a snapshot in the meantime.
+		return p.getUserSnapshot(user.Id)
+	}
+	return snapshot, nil
+}
+
+// getUserSnapshot returns the snapshot of a user, or nil if there is none.
+func (p *Plugin) getUserSnapshot(userID string) (*UserSnapshot, error) {
+	data, appErr := p.API.KVGet(userSnapshotKey(userID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to load user snapshot")
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	var snapshot UserSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, errors.Wrap(err, "failed to decode user snapshot")
+	}
+	return &snapshot, nil
+}
+
+// RestoreResult reports how a restore went. Failures are the steps that could not be
+// completed; the snapshot is kept until every step succeeds, so that the restore can be run
+// again.
+type RestoreResult struct {
+	UserID      string   `json:"user_id"`
+	Username    string   `json:"username"`
+	Reactivated bool     `json:"reactivated"`
+	HadSnapshot bool     `json:"had_snapshot"`
+	Failures    []string `json:"failures,omitempty"`
+}
+
+// errUserNotRestorable is returned when restoring a user that is active and has no snapshot.
+var errUserNotRestorable = errors.New("user is not deactivated and has no snapshot to restore")
+
+// restoreUser reactivates a user and rebuilds them from their snapshot, if there is one:
+// their username, nickname and email, and their team and channel memberships with the roles
+// they had in them.
+func (p *Plugin) restoreUser(actor, userID string) (*RestoreResult, error) {
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get user")
+	}
+	snapshot, err := p.getUserSnapshot(userID)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil && user.DeleteAt == 0 {
+		return nil, errUserNotRestorable
+	}
+
+	result := &RestoreResult{UserID: userID, HadSnapshot: snapshot != nil}
+	fail := func(format string, args ...any) {
+		result.Failures = append(result.Failures, fmt.Sprintf(format, args...))
+	}
+
+	// Keep the background retries from sanitizing the user again.
+	if err := p.cancelCleanup(userID); err != nil {
+		return nil, err
+	}
+	email := user.Email
+	if snapshot != nil {
+		email = snapshot.Email
+	}
+	if email != "" {
+		if _, err := p.unbanEmail(email, actor); err != nil {
+			fail("unban email %s: %s", email, err.Error())
+		}
+	}
+
+	if user.DeleteAt != 0 {
+		if appErr := p.API.UpdateUserActive(userID, true); appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to reactivate user")
+		}
+		result.Reactivated = true
+	}
+
+	if snapshot != nil {
+		if user, appErr = p.API.GetUser(userID); appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to get reactivated user")
+		}
+		user.Username = snapshot.Username
+		user.Nickname = snapshot.Nickname
+		user.Email = snapshot.Email
+		if updated, appErr := p.API.UpdateUser(user); appErr != nil {
+			fail("restore username %s, nickname and email: %s", snapshot.Username, appErr.Error())
+		} else {
+			user = updated
+		}
+		if user.Roles != snapshot.Roles {
+			fail("system roles are %q instead of %q and must be restored manually", user.Roles, snapshot.Roles)
+		}
+
+		for _, team := range snapshot.Teams {
+			if _, appErr := p.API.CreateTeamMember(team.ID, userID); appErr != nil {
+				fail("rejoin team %s: %s", team.ID, appErr.Error())
+				continue
+			}
+			if _, appErr := p.API.UpdateTeamMemberRoles(team.ID, userID, team.Roles); appErr != nil {
+				fail("restore roles %q in team %s: %s", team.Roles, team.ID, appErr.Error())
+			}
+		}
+		for _, channel := range snapshot.Channels {
+			if _, appErr := p.API.AddChannelMember(channel.ID, userID); appErr != nil {
+				fail("rejoin channel %s: %s", channel.ID, appErr.Error())
+				continue
+			}
+			if _, appErr := p.API.UpdateChannelMemberRoles(channel.ID, userID, channel.Roles); appErr != nil {
+				fail("restore roles %q in channel %s: %s", channel.Roles, channel.ID, appErr.Error())
+			}
+		}
+
+		if len(result.Failures) == 0 {
+			if appErr := p.API.KVDelete(userSnapshotKey(userID)); appErr != nil {
+				p.API.LogWarn("Failed to remove user snapshot", "user_id", userID, "err", appErr.Error())
+			}
+		}
+	}
+
+	result.Username = user.Username
+	cacheUser := *user
+	p.cache.Put(user.Id, &cacheUser)
+
+	p.audit(&AuditRecord{
+		Actor:   actor,
+		UserID:  userID,
+		Feature: shadowNewUsers,
+		Action:  "restore",
+		Excerpt: excerpt(strings.Join(result.Failures, "; ")),
+	})
+	return result, nil
+}
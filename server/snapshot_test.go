@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreUser(t *testing.T) {
+	newRestorePlugin := func(t *testing.T) (*Plugin, *MembershipMockAPI, *model.User) {
+		p, api := newTestPlugin(t, &configuration{EnableAuditLog: true})
+		user := &model.User{
+			Id:       model.NewId(),
+			Username: "cox-fan",
+			Nickname: "Cox",
+			Email:    "fan@example.com",
+			Roles:    model.SystemUserRoleId,
+		}
+		api.users[user.Id] = user
+		api.teamRoles["team"] = "team_user team_admin"
+		api.channelRoles["town-square"] = "channel_user"
+		api.channelRoles["announcements"] = "channel_user channel_admin"
+		api.channelTeams["town-square"] = "team"
+		api.channelTeams["announcements"] = "team"
+		return p, api, user
+	}
+
+	t.Run("rebuilds a sanitized user from the snapshot", func(t *testing.T) {
+		p, api, user := newRestorePlugin(t)
+
+		sanitized := *user
+		p.cleanupUser(&sanitized)
+		require.NotZero(t, api.users[user.Id].DeleteAt)
+		require.Equal(t, "sanitized-"+user.Id, api.users[user.Id].Username)
+		require.Empty(t, api.teamRoles)
+
+		result, err := p.restoreUser("mod", user.Id)
+		require.NoError(t, err)
+		assert.True(t, result.Reactivated)
+		assert.True(t, result.HadSnapshot)
+		assert.Empty(t, result.Failures)
+		assert.Equal(t, "cox-fan", result.Username)
+
+		restored := api.users[user.Id]
+		assert.Zero(t, restored.DeleteAt)
+		assert.Equal(t, "cox-fan", restored.Username)
+		assert.Equal(t, "Cox", restored.Nickname)
+		assert.Equal(t, map[string]string{"team": "team_user team_admin"}, api.teamRoles)
+		assert.Equal(t, map[string]string{
+			"town-square":   "channel_user",
+			"announcements": "channel_user channel_admin",
+		}, api.channelRoles)
+		assert.NotContains(t, api.kv, userSnapshotKey(user.Id))
+
+		records, err := p.queryAuditLog(AuditQuery{UserID: user.Id})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, "restore", records[0].Action)
+		assert.Equal(t, "mod", records[0].Actor)
+	})
+
+	t.Run("keeps the snapshot when a step fails", func(t *testing.T) {
+		p, api, user := newRestorePlugin(t)
+		api.failChannels["announcements"] = true
+
+		sanitized := *user
+		p.cleanupUser(&sanitized)
+
+		result, err := p.restoreUser("mod", user.Id)
+		require.NoError(t, err)
+		require.Len(t, result.Failures, 1)
+		assert.Contains(t, result.Failures[0], "rejoin channel announcements")
+		assert.Contains(t, api.kv, userSnapshotKey(user.Id))
+	})
+
+	t.Run("keeps the oldest snapshot until it is restored", func(t *testing.T) {
+		p, api, user := newRestorePlugin(t)
+
+		_, err := p.snapshotUser(user)
+		require.NoError(t, err)
+		renamed := *user
+		renamed.Username = "sanitized-" + user.Id
+		snapshot, err := p.snapshotUser(&renamed)
+		require.NoError(t, err)
+		assert.Equal(t, "cox-fan", snapshot.Username)
+
+		api.users[user.Id] = &renamed
+		_, err = p.restoreUser("mod", user.Id)
+		require.NoError(t, err)
+		assert.Equal(t, "cox-fan", api.users[user.Id].Username)
+
+		snapshot, err = p.snapshotUser(&renamed)
+		require.NoError(t, err)
+		assert.Equal(t, renamed.Username, snapshot.Username, "a restore consumes the snapshot")
+	})
+
+	t.Run("refuses active users without a snapshot", func(t *testing.T) {
+		p, _, user := newRestorePlugin(t)
+
+		_, err := p.restoreUser("mod", user.Id)
+		assert.ErrorIs(t, err, errUserNotRestorable)
+	})
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// mappedText is a normalized copy of a text that remembers, for every byte, which bytes of the
+// original text produced it. Matches found in the normalized text can then be traced back to
+// the original, even when normalization changed the length of the text.
+type mappedText struct {
+	original string
+	text     string
+
+	// starts[i] and ends[i] delimit the bytes of the original text that produced text[i].
+	starts []int
+	ends   []int
+}
+
+// newMappedText wraps a text without normalizing it.
+func newMappedText(s string) *mappedText {
+	m := &mappedText{
+		original: s,
+		text:     s,
+		starts:   make([]int, len(s)),
+		ends:     make([]int, len(s)),
+	}
+	for i := 0; i < len(s); {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		for j := i; j < i+size; j++ {
+			m.starts[j] = i
+			m.ends[j] = i + size
+		}
+		i += size
+	}
+	return m
+}
+
+// mappedTextBuilder assembles a new mappedText from pieces of an existing one.
+type mappedTextBuilder struct {
+	source *mappedText
+	text   strings.Builder
+	starts []int
+	ends   []int
+}
+
+func (m *mappedText) builder() *mappedTextBuilder {
+	return &mappedTextBuilder{
+		source: m,
+		starts: make([]int, 0, len(m.text)),
+		ends:   make([]int, 0, len(m.text)),
+	}
+}
+
+// write appends s, which replaces the bytes [start, end) of the source text.
+func (b *mappedTextBuilder) write(s string, start, end int) {
+	b.text.WriteString(s)
+	for i := 0; i < len(s); i++ {
+		b.starts = append(b.starts, b.source.starts[start])
+		b.ends = append(b.ends, b.source.ends[end-1])
+	}
+}
+
+func (b *mappedTextBuilder) build() *mappedText {
+	return &mappedText{
+		original: b.source.original,
+		text:     b.text.String(),
+		starts:   b.starts,
+		ends:     b.ends,
+	}
+}
+
+// mapRunes builds a new mappedText by replacing every rune of the text with the output of f.
+// The replacement of a rune is mapped to the original bytes of that rune.
+func (m *mappedText) mapRunes(f func(r rune) string) *mappedText {
+	b := m.builder()
+	for i := 0; i < len(m.text); {
+		r, size := utf8.DecodeRuneInString(m.text[i:])
+		b.write(f(r), i, i+size)
+		i += size
+	}
+	return b.build()
+}
+
+// originalSpan translates a byte range of the normalized text into the byte range of the
+// original text it came from. The range is widened to cover combining marks that follow it in
+// the original, so censoring never leaves an orphaned accent behind.
+func (m *mappedText) originalSpan(start, end int) (int, int) {
+	if start >= end || start >= len(m.starts) {
+		return 0, 0
+	}
+	originalStart := m.starts[start]
+	originalEnd := m.ends[end-1]
+	for originalEnd < len(m.original) {
+		r, size := utf8.DecodeRuneInString(m.original[originalEnd:])
+		if !isGraphemeExtender(r) {
+			break
+		}
+		originalEnd += size
+	}
+	return originalStart, originalEnd
+}
+
+// foldAccents strips the diacritics of a single rune, e.g. "ì" becomes "i".
+func foldAccents(r rune) string {
+	decomposed := norm.NFD.String(string(r))
+	stripped := strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, decomposed)
+	return norm.NFC.String(stripped)
+}
+
+// withoutAccents returns the text with its diacritics stripped, mapped to the original.
+func withoutAccents(s string) *mappedText {
+	return newMappedText(s).mapRunes(foldAccents)
+}
+
+const zeroWidthJoiner = '\u200d'
+
+// isGraphemeExtender reports whether the rune attaches to the character before it instead of
+// being displayed on its own: combining marks, variation selectors and emoji skin tones.
+func isGraphemeExtender(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) ||
+		unicode.Is(unicode.Variation_Selector, r) ||
+		(r >= 0x1f3fb && r <= 0x1f3ff)
+}
+
+// graphemeCount counts the user-perceived characters of a text. Combining marks and emoji
+// modifiers belong to the character before them, characters joined with a zero-width joiner
+// count as one, and invisible formatting characters do not count at all.
+func graphemeCount(s string) int {
+	count := 0
+	joined := false
+	for _, r := range s {
+		switch {
+		case r == zeroWidthJoiner:
+			joined = true
+		case isGraphemeExtender(r), unicode.Is(unicode.Cf, r):
+		case joined:
+			joined = false
+		default:
+			count++
+		}
+	}
+	return count
+}
+
+// textSpan is a byte range of a text.
+type textSpan struct {
+	start, end int
+}
+
+// censorSpans replaces every span of the text with the censor character, repeated once per
+// character of the censored text. Overlapping spans are merged first.
+func censorSpans(s string, spans []textSpan, censorCharacter string) string {
+	if len(spans) == 0 {
+		return s
+	}
+
+	sorted := make([]textSpan, len(spans))
+	copy(sorted, spans)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(sorted); i++ {
+		span := sorted[i]
+		for i+1 < len(sorted) && sorted[i+1].start < span.end {
+			if sorted[i+1].end > span.end {
+				span.end = sorted[i+1].end
+			}
+			i++
+		}
+		if span.start < last {
+			span.start = last
+		}
+		b.WriteString(s[last:span.start])
+		b.WriteString(strings.Repeat(censorCharacter, graphemeCount(s[span.start:span.end])))
+		last = span.end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// findWords returns the byte ranges of all matches of the regex in the text. Regexes built from
+// wordRegexTemplate consume the characters around a word to check its boundaries, so the range
+// of a match is the range of its first group, if it has one. The next search starts right after
+// the group, so that the boundary between two words counts for both.
+func findWords(regex *regexp.Regexp, text string) [][]int {
+	if regex.NumSubexp() == 0 {
+		return regex.FindAllStringIndex(text, -1)
+	}
+
+	var locations [][]int
+	for offset := 0; offset <= len(text); {
+		location := regex.FindStringSubmatchIndex(text[offset:])
+		if location == nil {
+			break
+		}
+		start, end := offset+location[2], offset+location[3]
+		if location[2] < 0 || end <= start {
+			// An empty match: move on to the next character.
+			_, size := utf8.DecodeRuneInString(text[offset+location[0]:])
+			offset += location[0] + max(size, 1)
+			continue
+		}
+		locations = append(locations, []int{start, end})
+		offset = end
+	}
+	return locations
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOriginalSpan(t *testing.T) {
+	t.Run("maps accent stripped matches back to the original", func(t *testing.T) {
+		text := withoutAccents("oh shìt!")
+		assert.Equal(t, "oh shit!", text.text)
+
+		start, end := text.originalSpan(3, 7)
+		assert.Equal(t, "shìt", text.original[start:end])
+	})
+
+	t.Run("includes decomposed combining marks", func(t *testing.T) {
+		text := withoutAccents("cafe\u0301 ok")
+		assert.Equal(t, "cafe ok", text.text)
+
+		start, end := text.originalSpan(0, 4)
+		assert.Equal(t, "cafe\u0301", text.original[start:end])
+	})
+}
+
+func TestGraphemeCount(t *testing.T) {
+	for in, count := range map[string]int{
+		"abc":             3,
+		"shìt":            4,
+		"cafe\u0301":      4,
+		"блядь":           5,
+		"👍":               1,
+		"👍🏽":              1,
+		"👨\u200d👩\u200d👧": 1,
+		"❤\ufe0f":         1,
+	} {
+		assert.Equal(t, count, graphemeCount(in), in)
+	}
+}
+
+func TestCensorSpans(t *testing.T) {
+	t.Run("merges overlapping spans", func(t *testing.T) {
+		assert.Equal(t, "*****f", censorSpans("abcdef", []textSpan{{2, 5}, {0, 3}}, "*"))
+	})
+
+	t.Run("supports multi-character censors", func(t *testing.T) {
+		assert.Equal(t, "a\\*\\*d", censorSpans("abcd", []textSpan{{1, 3}}, "\\*"))
+	})
+}
+
+func TestCensorMultibyteText(t *testing.T) {
+	p := Plugin{
+		configuration: &configuration{
+			CensorCharacter: "*",
+			BadWordsList:    "shit,abc,блядь",
+		},
+	}
+	p.badWordsRegex = regexp.MustCompile(wordListToRegex(p.getConfiguration().BadWordsList, wordRegexTemplate))
+
+	for name, tc := range map[string]struct{ in, out string }{
+		"accented word":            {"oh shìt!", "oh ****!"},
+		"decomposed accent":        {"oh shi\u0300t!", "oh ****!"},
+		"accented capitals":        {"ÀBÇ", "***"},
+		"cyrillic word":            {"ну блядь", "ну *****"},
+		"emoji before":             {"👍abc", "👍***"},
+		"emoji after":              {"abc🎉 yay", "***🎉 yay"},
+		"only whole occurrences":   {"abc abcdef", "*** abcdef"},
+		"text around accents kept": {"résumé abc", "résumé ***"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rpost, reason := p.MessageWillBePosted(&plugin.Context{}, &model.Post{Message: tc.in})
+			assert.Empty(t, reason)
+			assert.Equal(t, tc.out, rpost.Message)
+		})
+	}
+}
+
+func TestFindWords(t *testing.T) {
+	find := func(list, text string) [][]int {
+		return findWords(regexp.MustCompile(wordListToRegex(list, wordRegexTemplate)), text)
+	}
+
+	t.Run("falls back to shorter alternatives at the same offset", func(t *testing.T) {
+		assert.Equal(t, [][]int{{0, 3}}, find("foo bar,foo", "foo barx"))
+		assert.Equal(t, [][]int{{0, 7}}, find("foo bar,foo", "foo bar"))
+	})
+
+	t.Run("adjacent words share a separator", func(t *testing.T) {
+		assert.Equal(t, [][]int{{0, 3}, {4, 7}}, find("abc", "abc abc"))
+	})
+
+	t.Run("skips words inside other words", func(t *testing.T) {
+		assert.Empty(t, find("abc", "xabc abcx"))
+		assert.Equal(t, [][]int{{5, 8}}, find("abc", "abcx abc"))
+	})
+}
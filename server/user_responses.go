@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// UserResponse is what happens to a new user that fails a check.
+type UserResponse string
+
+const (
+	// UserResponseFlag only flags the user and notifies the moderators.
+	UserResponseFlag UserResponse = "flag"
+	// UserResponseSanitizeUsername replaces the username and nickname, and keeps the account.
+	UserResponseSanitizeUsername UserResponse = "sanitize_username"
+	// UserResponseQuarantine keeps the account, but keeps the user from posting.
+	UserResponseQuarantine UserResponse = "quarantine"
+	// UserResponseApproval deactivates the account until a moderator approves it.
+	UserResponseApproval UserResponse = "approval"
+	// UserResponseDeactivate sanitizes the user, removes them from their teams and
+	// deactivates them.
+	UserResponseDeactivate UserResponse = "deactivate"
+)
+
+// userResponseSeverity ranks the responses, so that the strongest response of several failed
+// checks can be picked.
+var userResponseSeverity = map[UserResponse]int{
+	UserResponseFlag:             1,
+	UserResponseSanitizeUsername: 2,
+	UserResponseQuarantine:       3,
+	UserResponseApproval:         4,
+	UserResponseDeactivate:       5,
+}
+
+// userResponseDescriptions complete "a new user would be ..." in shadow mode notifications.
+var userResponseDescriptions = map[UserResponse]string{
+	UserResponseFlag:             "flagged",
+	UserResponseSanitizeUsername: "renamed",
+	UserResponseQuarantine:       "quarantined",
+	UserResponseApproval:         "deactivated until a moderator approves them",
+	UserResponseDeactivate:       "sanitized and deactivated",
+}
+
+// userResponseTitles are the titles of the notifications of each response.
+var userResponseTitles = map[UserResponse]string{
+	UserResponseFlag:             "New user flagged",
+	UserResponseSanitizeUsername: "Username sanitized",
+	UserResponseQuarantine:       "New user quarantined",
+	UserResponseDeactivate:       "User sanitized and deactivated",
+}
+
+func isUserResponse(response UserResponse) bool {
+	_, ok := userResponseSeverity[response]
+	return ok
+}
+
+// The names of the new user checks, as used in the UserResponses setting.
+const (
+	validatorBadUsernames     = "bad_usernames"
+	validatorBadDomains       = "bad_domains"
+	validatorAllowedDomains   = "allowed_domains"
+	validatorBannedEmails     = "banned_emails"
+	validatorSuspiciousEmails = "suspicious_emails"
+)
+
+var userValidatorNames = []string{
+	validatorBadUsernames,
+	validatorBadDomains,
+	validatorAllowedDomains,
+	validatorBannedEmails,
+	validatorSuspiciousEmails,
+}
+
+// userViolation is a failed check of a new user.
+type userViolation struct {
+	// validator is the name of the check that failed.
+	validator string
+	// ruleID is the rule that matched, if the check is rule based.
+	ruleID string
+	// response is the response of the rule, if it has one.
+	response UserResponse
+	reason   string
+}
+
+func (v *userViolation) Error() string {
+	return v.reason
+}
+
+// parseUserResponses parses the UserResponses setting: comma separated pairs of a check and
+// its response, e.g. "bad_usernames=deactivate,bad_domains=flag".
+func parseUserResponses(setting string) (map[string]UserResponse, error) {
+	responses := map[string]UserResponse{}
+	for _, pair := range strings.Split(setting, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		validator, response, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("expected check=response, got %q", strings.TrimSpace(pair))
+		}
+		validator = strings.TrimSpace(validator)
+		response = strings.TrimSpace(response)
+
+		if !containsString(userValidatorNames, validator) {
+			return nil, fmt.Errorf("unknown check: %q", validator)
+		}
+		if !isUserResponse(UserResponse(response)) {
+			return nil, fmt.Errorf("unknown response for %s: %q", validator, response)
+		}
+		responses[validator] = UserResponse(response)
+	}
+	return responses, nil
+}
+
+// userResponse picks the response to the failed checks of a new user: the strongest of the
+// responses of the matching rules, or else of the checks, which default to deactivation.
+func userResponse(configuration *configuration, violations []error) UserResponse {
+	// Invalid settings are reported when the configuration is loaded.
+	configured, _ := parseUserResponses(configuration.UserResponses)
+
+	var strongest UserResponse
+	for _, err := range violations {
+		response := UserResponseDeactivate
+		var violation *userViolation
+		if errors.As(err, &violation) {
+			if violation.response != "" {
+				response = violation.response
+			} else if validatorResponse, ok := configured[violation.validator]; ok {
+				response = validatorResponse
+			}
+		}
+		if userResponseSeverity[response] > userResponseSeverity[strongest] {
+			strongest = response
+		}
+	}
+	return strongest
+}
+
+// respondToUser carries out the response to the failed checks of a new user.
+func (p *Plugin) respondToUser(response UserResponse, user *model.User, reasons []string) error {
+	reason := strings.Join(reasons, "; ")
+
+	switch response {
+	case UserResponseFlag:
+		_, err := p.flagUser(user.Id, reason, auditActorSystem)
+		return err
+	case UserResponseSanitizeUsername:
+		return p.sanitizeUsername(user)
+	case UserResponseQuarantine:
+		return p.quarantineUser(user.Id, reason, auditActorSystem)
+	case UserResponseApproval:
+		return p.requireApproval(user, reasons)
+	default:
+		return p.cleanupUser(user)
+	}
+}
+
+// sanitizeUsername replaces the username and nickname of the user, and leaves the account
+// otherwise untouched. The user is snapshotted first, so that they can be restored.
+func (p *Plugin) sanitizeUsername(user *model.User) error {
+	if _, err := p.snapshotUser(user); err != nil {
+		return err
+	}
+
+	user.Nickname = fmt.Sprintf("sanitized-%s", user.Id)
+	user.Username = fmt.Sprintf("sanitized-%s", user.Id)
+	if _, appErr := p.API.UpdateUser(user); appErr != nil {
+		return errors.Wrap(appErr, "failed to sanitize username")
+	}
+	return nil
+}
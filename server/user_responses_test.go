@@ -0,0 +1,235 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUserResponses(t *testing.T) {
+	t.Run("parses check and response pairs", func(t *testing.T) {
+		responses, err := parseUserResponses(" bad_usernames = quarantine, bad_domains=flag ")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]UserResponse{
+			validatorBadUsernames: UserResponseQuarantine,
+			validatorBadDomains:   UserResponseFlag,
+		}, responses)
+	})
+
+	t.Run("defaults to no responses", func(t *testing.T) {
+		responses, err := parseUserResponses("")
+		require.NoError(t, err)
+		assert.Empty(t, responses)
+	})
+
+	for _, setting := range []string{"bad_usernames", "bad_emails=flag", "bad_domains=ban"} {
+		t.Run("rejects "+setting, func(t *testing.T) {
+			_, err := parseUserResponses(setting)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestValidateApprovalResponses(t *testing.T) {
+	p := &Plugin{}
+	approval := map[string]UserResponse{validatorBadUsernames: UserResponseApproval, validatorBadDomains: UserResponseFlag}
+
+	assert.NoError(t, p.validateApprovalResponses(&configuration{ModerationChannelID: "moderation"}, approval))
+	assert.NoError(t, p.validateApprovalResponses(&configuration{}, map[string]UserResponse{validatorBadDomains: UserResponseFlag}))
+	assert.EqualError(t, p.validateApprovalResponses(&configuration{}, approval),
+		"a moderation channel is needed to review the new users sent for approval by check bad_usernames")
+
+	p.storedRules = map[string][]*compiledRule{
+		ruleKindDomains: {{Rule: &Rule{ID: "disposable", Response: UserResponseApproval}}},
+	}
+	assert.EqualError(t, p.validateApprovalResponses(&configuration{}, nil),
+		"a moderation channel is needed to review the new users sent for approval by rule disposable")
+}
+
+func TestUserResponse(t *testing.T) {
+	config := &configuration{UserResponses: "bad_usernames=flag,bad_domains=sanitize_username"}
+
+	assert.Equal(t, UserResponseFlag, userResponse(config, []error{
+		&userViolation{validator: validatorBadUsernames},
+	}))
+	assert.Equal(t, UserResponseSanitizeUsername, userResponse(config, []error{
+		&userViolation{validator: validatorBadUsernames},
+		&userViolation{validator: validatorBadDomains},
+	}), "the strongest response wins")
+	assert.Equal(t, UserResponseApproval, userResponse(config, []error{
+		&userViolation{validator: validatorBadUsernames, ruleID: "impersonation", response: UserResponseApproval},
+	}), "rules override the response of their check")
+	assert.Equal(t, UserResponseDeactivate, userResponse(&configuration{}, []error{
+		&userViolation{validator: validatorBadUsernames},
+	}), "checks deactivate by default")
+}
+
+func TestUserResponses(t *testing.T) {
+	newRespondingPlugin := func(t *testing.T, responses string) (*Plugin, *MembershipMockAPI, *model.User) {
+		p, api := newTestPlugin(t, &configuration{
+			BadUsernamesList:        "baduser",
+			EnableAuditLog:          true,
+			ModerationChannelID:     "moderation",
+			ModerationNotifications: "user_sanitized,user_flagged,post_rejected",
+			Moderators:              "mod",
+			UserResponses:           responses,
+		})
+		user := &model.User{Id: model.NewId(), Username: "baduser", Email: "user@example.com"}
+		api.users[user.Id] = user
+		api.teamRoles["team"] = model.TeamUserRoleId
+		return p, api, user
+	}
+
+	moderationPosts := func(api *MembershipMockAPI) []*model.Post {
+		var posts []*model.Post
+		for _, post := range api.posts {
+			if post.ChannelId == "moderation" {
+				posts = append(posts, post)
+			}
+		}
+		return posts
+	}
+
+	t.Run("deactivates by default", func(t *testing.T) {
+		p, api, user := newRespondingPlugin(t, "")
+
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+
+		assert.NotZero(t, api.users[user.Id].DeleteAt)
+		assert.Equal(t, "sanitized-"+user.Id, api.users[user.Id].Username)
+		assert.Empty(t, api.teamRoles)
+	})
+
+	t.Run("flags the user", func(t *testing.T) {
+		p, api, user := newRespondingPlugin(t, "bad_usernames=flag")
+
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+
+		assert.Zero(t, api.users[user.Id].DeleteAt)
+		assert.Equal(t, "baduser", api.users[user.Id].Username)
+		flagged, err := p.getFlaggedUser(user.Id)
+		require.NoError(t, err)
+		require.NotNil(t, flagged)
+		assert.Contains(t, flagged.Reason, "username matches moderation list")
+
+		posts := moderationPosts(api)
+		require.Len(t, posts, 1)
+		assert.Equal(t, "New user flagged", posts[0].Attachments()[0].Title)
+	})
+
+	t.Run("sanitizes the username and keeps the account", func(t *testing.T) {
+		p, api, user := newRespondingPlugin(t, "bad_usernames=sanitize_username")
+
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+
+		assert.Zero(t, api.users[user.Id].DeleteAt)
+		assert.Equal(t, "sanitized-"+user.Id, api.users[user.Id].Username)
+		assert.Equal(t, map[string]string{"team": model.TeamUserRoleId}, api.teamRoles)
+		assert.Contains(t, api.kv, userSnapshotKey(user.Id))
+	})
+
+	t.Run("quarantined users cannot post", func(t *testing.T) {
+		p, api, user := newRespondingPlugin(t, "bad_usernames=quarantine")
+
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+
+		assert.Zero(t, api.users[user.Id].DeleteAt)
+		assert.True(t, p.isQuarantined(user.Id))
+
+		post, reason := p.FilterPost(&model.Post{UserId: user.Id, ChannelId: "town-square", Message: "hello"})
+		assert.Nil(t, post)
+		assert.Equal(t, "User is quarantined", reason)
+
+		released, err := p.releaseUser(user.Id, "mod")
+		require.NoError(t, err)
+		assert.True(t, released)
+
+		post, _ = p.FilterPost(&model.Post{UserId: user.Id, ChannelId: "town-square", Message: "hello"})
+		assert.NotNil(t, post)
+	})
+
+	t.Run("users waiting for approval are approved", func(t *testing.T) {
+		p, api, user := newRespondingPlugin(t, "bad_usernames=approval")
+
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+
+		assert.NotZero(t, api.users[user.Id].DeleteAt)
+		assert.Equal(t, "baduser", api.users[user.Id].Username)
+		posts := moderationPosts(api)
+		require.Len(t, posts, 1, "the approval request is the only notification")
+		require.Len(t, posts[0].Attachments()[0].Actions, 2)
+
+		reviewPost, err := p.reviewPendingUser(user.Id, holdDecisionApprove, "mod")
+		require.NoError(t, err)
+		require.NotNil(t, reviewPost)
+		assert.Zero(t, api.users[user.Id].DeleteAt)
+		assert.Equal(t, "baduser", api.users[user.Id].Username)
+
+		reviewPost, err = p.reviewPendingUser(user.Id, holdDecisionDeny, "mod")
+		require.NoError(t, err)
+		assert.Nil(t, reviewPost, "the user was already reviewed")
+	})
+
+	t.Run("users stay pending when they cannot be reactivated", func(t *testing.T) {
+		p, api, user := newRespondingPlugin(t, "bad_usernames=approval")
+
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+		api.failActivations = 1
+		_, err := p.reviewPendingUser(user.Id, holdDecisionApprove, "mod")
+		require.Error(t, err)
+		assert.NotZero(t, api.users[user.Id].DeleteAt)
+
+		reviewPost, err := p.reviewPendingUser(user.Id, holdDecisionApprove, "mod")
+		require.NoError(t, err)
+		require.NotNil(t, reviewPost, "the user can be reviewed again")
+		assert.Zero(t, api.users[user.Id].DeleteAt)
+	})
+
+	t.Run("denied users are sanitized", func(t *testing.T) {
+		p, api, user := newRespondingPlugin(t, "bad_usernames=approval")
+
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+		_, err := p.reviewPendingUser(user.Id, holdDecisionDeny, "mod")
+		require.NoError(t, err)
+
+		assert.NotZero(t, api.users[user.Id].DeleteAt)
+		assert.Equal(t, "sanitized-"+user.Id, api.users[user.Id].Username)
+	})
+
+	t.Run("approval rules need a moderation channel", func(t *testing.T) {
+		p, _, _ := newRespondingPlugin(t, "")
+		p.configuration.ModerationChannelID = ""
+
+		err := p.createStoredRule(ruleKindUsernames, &Rule{ID: "lookalike", Pattern: "baduser", Response: UserResponseApproval})
+		assert.Error(t, err)
+	})
+
+	t.Run("rules choose their own response", func(t *testing.T) {
+		p, api, user := newRespondingPlugin(t, "bad_usernames=deactivate")
+		p.badUsernamesRegex = nil
+		require.NoError(t, p.createStoredRule(ruleKindUsernames, &Rule{
+			ID:       "lookalike",
+			Pattern:  "baduser",
+			Response: UserResponseFlag,
+		}))
+
+		p.UserHasBeenCreated(&plugin.Context{}, user)
+
+		assert.Zero(t, api.users[user.Id].DeleteAt)
+		flagged, err := p.getFlaggedUser(user.Id)
+		require.NoError(t, err)
+		assert.NotNil(t, flagged)
+	})
+
+	t.Run("word rules have no response", func(t *testing.T) {
+		p, _, _ := newRespondingPlugin(t, "")
+
+		err := p.createStoredRule(ruleKindWords, &Rule{ID: "spam", Pattern: "spam", Response: UserResponseFlag})
+		assert.ErrorIs(t, err, errInvalidRule)
+		err = p.createStoredRule(ruleKindDomains, &Rule{ID: "spam", Pattern: "spam.com", Response: "ban"})
+		assert.ErrorIs(t, err, errInvalidRule)
+	})
+}
@@ -2,50 +2,81 @@ package main
 
 import (
 	"fmt"
-	"strings"
-	"unicode"
 
 	"github.com/mattermost/mattermost/server/public/model"
-	"golang.org/x/text/runes"
-	"golang.org/x/text/transform"
-	"golang.org/x/text/unicode/norm"
+	"github.com/pkg/errors"
 )
 
-func removeAccents(s string) string {
-	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
-	output, _, e := transform.String(t, s)
-	if e != nil {
-		return s
+func (p *Plugin) isDirectMessage(channelID string) (bool, error) {
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to get channel")
 	}
-
-	return output
+	return channel.Type == model.ChannelTypeDirect, nil
 }
 
-func (p *Plugin) isDirectMessage(channelID string) bool {
-	channel, err := p.API.GetChannel(channelID)
-	if err != nil {
-		panic("couldn't find channel")
+// The ways warnings can be delivered to users, as selected by the WarningDelivery setting.
+const (
+	warningDeliveryEphemeral     = "ephemeral"
+	warningDeliveryDirectMessage = "direct_message"
+)
+
+// sendUserWarningForPost tells the author of a post why it was not published, either with an
+// ephemeral message next to the post or with a direct message from the bot that stays around.
+func (p *Plugin) sendUserWarningForPost(post *model.Post, message string) {
+	if p.getConfiguration().WarningDelivery == warningDeliveryDirectMessage {
+		err := p.sendUserDirectMessageForPost(post, message)
+		if err == nil {
+			return
+		}
+		p.API.LogWarn("Failed to send warning as a direct message", "user_id", post.UserId, "err", err.Error())
 	}
-	return channel.Type == model.ChannelTypeDirect
+	p.sendUserEphemeralMessageForPost(post, message)
 }
 
 func (p *Plugin) sendUserEphemeralMessageForPost(post *model.Post, message string) {
 	p.API.SendEphemeralPost(post.UserId, &model.Post{
+		UserId:    p.botUserID,
 		ChannelId: post.ChannelId,
 		Message:   message,
 		RootId:    post.RootId,
 	})
 }
 
-func EndsWith(search []string, email string) bool {
-	// search is a slice of strings with WHOLE domains to match against
-	// email is a string containing an email which we will compare the DOMAIN part only.
-	domain := strings.SplitN(email, "@", 2)
-	target := domain[1]
-	for _, s := range search {
-		// if the TARGET ends with search
-		if target == s {
-			fmt.Printf("FOUND: %s in %s\n", s, target)
+// sendUserDirectMessageForPost sends the warning from the bot in a direct message, quoting
+// the post it is about.
+func (p *Plugin) sendUserDirectMessageForPost(post *model.Post, message string) error {
+	if p.botUserID == "" {
+		return fmt.Errorf("the bot user is not set up")
+	}
+
+	channel, appErr := p.API.GetDirectChannel(post.UserId, p.botUserID)
+	if appErr != nil {
+		return appErr
+	}
+
+	warning := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channel.Id,
+		Message:   message,
+	}
+	if post.Message != "" {
+		model.ParseSlackAttachment(warning, []*model.SlackAttachment{{
+			Fallback: post.Message,
+			Title:    "Your message",
+			Text:     post.Message,
+			Footer:   "Posted in " + p.channelName(post.ChannelId),
+		}})
+	}
+	if _, appErr := p.API.CreatePost(warning); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
 			return true
 		}
 	}
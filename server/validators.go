@@ -8,18 +8,65 @@ import (
 
 func (p *Plugin) checkBadEmail(user *model.User) error {
 	email := user.Email
-	if p.configuration.BuiltinBadDomains && EndsWith(*p.badDomainsList, email) {
-		return fmt.Errorf("email domain is in builtin list of bad domains: %v", email)
+	if entry, source, found := p.checkedDomainLists().match(emailDomain(email)); found {
+		reason := fmt.Sprintf("email domain is in builtin list of bad domains: %v", email)
+		if source != builtinDomainSource {
+			reason = fmt.Sprintf("email domain matches %s in domain %s: %v", entry, source, email)
+		}
+		return &userViolation{
+			validator: validatorBadDomains,
+			reason:    reason,
+		}
 	}
-	if p.badDomainsRegex != nil && p.badDomainsRegex.MatchString(email) {
-		return fmt.Errorf("email domain matches moderations list: %v", email)
+	rules := p.getStoredRules(ruleKindDomains)
+	if p.badDomainsRegex == nil && len(rules) == 0 {
+		return nil
+	}
+	// Match the variants of an address, like "spam+1@gmail.com", like the address itself.
+	normalized := normalizeEmail(email)
+	if p.badDomainsRegex != nil && (p.badDomainsRegex.MatchString(email) || p.badDomainsRegex.MatchString(normalized)) {
+		return &userViolation{
+			validator: validatorBadDomains,
+			reason:    fmt.Sprintf("email domain matches moderations list: %v", email),
+		}
+	}
+	for _, rule := range rules {
+		if rule.regex.MatchString(email) || rule.regex.MatchString(normalized) {
+			return &userViolation{
+				validator: validatorBadDomains,
+				ruleID:    rule.ID,
+				response:  rule.Response,
+				reason:    fmt.Sprintf("email domain matches rule %s: %v", rule.ID, email),
+			}
+		}
 	}
 	return nil
 }
 
 func (p *Plugin) checkBadUsername(user *model.User) error {
-	if p.badUsernamesRegex != nil && (p.badUsernamesRegex.MatchString(user.Username) || p.badUsernamesRegex.MatchString(user.Nickname)) {
-		return fmt.Errorf("username matches moderation list: %v", user.Username)
+	rules := p.getStoredRules(ruleKindUsernames)
+	if p.badUsernamesRegex == nil && len(rules) == 0 {
+		return nil
+	}
+	for _, name := range []string{user.Username, user.Nickname} {
+		for _, variant := range normalizeText(p.getConfiguration(), name) {
+			if p.badUsernamesRegex != nil && p.badUsernamesRegex.MatchString(variant.text) {
+				return &userViolation{
+					validator: validatorBadUsernames,
+					reason:    fmt.Sprintf("username matches moderation list: %v", user.Username),
+				}
+			}
+			for _, rule := range rules {
+				if rule.regex.MatchString(variant.text) {
+					return &userViolation{
+						validator: validatorBadUsernames,
+						ruleID:    rule.ID,
+						response:  rule.Response,
+						reason:    fmt.Sprintf("username matches rule %s: %v", rule.ID, user.Username),
+					}
+				}
+			}
+		}
 	}
 	return nil
 }